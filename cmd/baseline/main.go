@@ -5,29 +5,29 @@ import (
 	"fmt"
 	"log"
 	"os"
-	"runtime/pprof"
+	"strings"
 
 	"1brc/internal/brc"
+	"1brc/internal/format"
+	"1brc/internal/prof"
 )
 
 func main() {
-	cpuprofile := flag.String("cpuprofile", "", "write cpu profile to file")
+	profiles := prof.Register(flag.CommandLine)
 	inputFile := flag.String("i", "data/1m.txt", "input file")
+	rounding := flag.String("rounding", format.RoundHalfUp, "rounding: "+strings.Join(format.RoundingModes, ", "))
 	flag.Parse()
 
-	if *cpuprofile != "" {
-		f, err := os.Create(*cpuprofile)
-		if err != nil {
-			log.Fatal(err)
-		}
-		pprof.StartCPUProfile(f)
-		defer pprof.StopCPUProfile()
+	stopProfiles, err := profiles.Start()
+	if err != nil {
+		log.Fatal(err)
 	}
+	defer stopProfiles()
 
 	f, err := os.Open(*inputFile)
 	if err != nil {
 		log.Fatal(err)
 	}
 	defer f.Close()
-	fmt.Println(brc.Baseline(f))
+	fmt.Println(brc.BaselineRounding(f, *rounding))
 }
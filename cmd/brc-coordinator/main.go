@@ -0,0 +1,83 @@
+// brc-coordinator splits the input files in ranges handed out to the
+// brc-worker processes connecting to it, and prints the merged results.
+//
+//	brc-coordinator -listen :7070 data/1b.txt
+//	brc-worker -coordinator host:7070   # on as many hosts as wanted
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"log/slog"
+	"net"
+	"os"
+	"os/signal"
+	"slices"
+	"strings"
+	"time"
+
+	"1brc/internal/dist"
+	"1brc/internal/format"
+	"1brc/internal/prof"
+)
+
+func main() {
+	var files []string
+	flag.Func("f", "input file, can be repeated, files can also be given as arguments", func(s string) error {
+		files = append(files, s)
+		return nil
+	})
+	listen := flag.String("listen", "127.0.0.1:7070", "address the workers connect to")
+	rangeSize := flag.Int64("range-size", 64*1024*1024, "size of the ranges handed out to the workers")
+	timeout := flag.Duration("task-timeout", 5*time.Minute, "time a worker has to process a range before it's given to another one, 0 for no limit")
+	attempts := flag.Int("attempts", 3, "number of times a range can fail before giving up")
+	rounding := flag.String("rounding", format.RoundHalfUp, "rounding of the means: "+strings.Join(format.RoundingModes, ", "))
+	debug := flag.Bool("debug", false, "enable debug logging")
+	profiles := prof.Register(flag.CommandLine)
+	flag.Parse()
+	files = append(files, flag.Args()...)
+
+	stopProfiles, err := profiles.Start()
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer stopProfiles()
+
+	if *debug {
+		slog.SetLogLoggerLevel(slog.LevelDebug)
+	}
+	if len(files) == 0 {
+		log.Fatal("no input file")
+	}
+	if !slices.Contains(format.RoundingModes, *rounding) {
+		log.Fatalf("unknown rounding: %s", *rounding)
+	}
+
+	t0 := time.Now()
+	tasks, err := dist.Split(files, *rangeSize)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	ln, err := net.Listen("tcp", *listen)
+	if err != nil {
+		log.Fatal(err)
+	}
+	slog.Info("Waiting for workers", "addr", ln.Addr(), "ranges", len(tasks))
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+	c := dist.Coordinator{TaskTimeout: *timeout, MaxAttempts: *attempts}
+	results, err := c.Run(ctx, ln, tasks)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	if err := results.Encode(os.Stdout, results.Names(), *rounding); err != nil {
+		log.Fatal(err)
+	}
+	fmt.Println()
+	log.Printf("took: %0.3f", time.Since(t0).Seconds())
+}
@@ -0,0 +1,56 @@
+// brc-worker processes the ranges of a brc-coordinator. The input files must
+// be readable at the same path as on the coordinator.
+package main
+
+import (
+	"flag"
+	"log"
+	"log/slog"
+	"net"
+	"runtime"
+	"time"
+
+	"1brc/internal/dist"
+	"1brc/internal/prof"
+)
+
+func main() {
+	coordinator := flag.String("coordinator", "127.0.0.1:7070", "address of the coordinator")
+	nworkers := flag.Int("n", runtime.NumCPU(), "number of parsing goroutines")
+	chunkSize := flag.Int("chunksize", 2*1024*1024, "size of the chunks a range is split in")
+	dialTimeout := flag.Duration("dial-timeout", 30*time.Second, "how long to retry connecting to the coordinator")
+	debug := flag.Bool("debug", false, "enable debug logging")
+	profiles := prof.Register(flag.CommandLine)
+	flag.Parse()
+
+	stopProfiles, err := profiles.Start()
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer stopProfiles()
+
+	if *debug {
+		slog.SetLogLoggerLevel(slog.LevelDebug)
+	}
+
+	// the coordinator may not be listening yet
+	var conn net.Conn
+	deadline := time.Now().Add(*dialTimeout)
+	for {
+		conn, err = net.Dial("tcp", *coordinator)
+		if err == nil || time.Now().After(deadline) {
+			break
+		}
+		slog.Debug("Coordinator not ready", "err", err)
+		time.Sleep(100 * time.Millisecond)
+	}
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer conn.Close()
+
+	w := dist.Worker{NWorkers: *nworkers, ChunkSize: *chunkSize}
+	if err := w.Serve(conn); err != nil {
+		log.Fatal(err)
+	}
+}
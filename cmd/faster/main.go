@@ -5,24 +5,21 @@ import (
 	"fmt"
 	"log"
 	"os"
-	"runtime/pprof"
 
 	"1brc/internal/brc"
+	"1brc/internal/prof"
 )
 
 func main() {
-	cpuprofile := flag.String("cpuprofile", "", "write cpu profile to file")
+	profiles := prof.Register(flag.CommandLine)
 	inputFile := flag.String("i", "data/10m.txt", "input file")
 	flag.Parse()
 
-	if *cpuprofile != "" {
-		f, err := os.Create(*cpuprofile)
-		if err != nil {
-			log.Fatal(err)
-		}
-		pprof.StartCPUProfile(f)
-		defer pprof.StopCPUProfile()
+	stopProfiles, err := profiles.Start()
+	if err != nil {
+		log.Fatal(err)
 	}
+	defer stopProfiles()
 
 	f, err := os.Open(*inputFile)
 	if err != nil {
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"runtime"
+	"time"
+
+	"golang.org/x/sys/unix"
+
+	"1brc/internal/fastbrc"
+)
+
+// convertMain is fastbrc convert: it writes a measurements file in the .brcb
+// binary format, that fastbrc -f reads without parsing text
+func convertMain(args []string) {
+	fs := flag.NewFlagSet("convert", flag.ExitOnError)
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "usage: %s convert [flags] data.txt data.brcb\n", os.Args[0])
+		fs.PrintDefaults()
+	}
+	nworkers := fs.Int("n", runtime.NumCPU(), "number of workers")
+	chunkSize := fs.Int("chunksize", 2*1024*1024, "size of the chunks of text encoded by the workers, the blocks of the .brcb file")
+	fs.Parse(args)
+
+	if fs.NArg() != 2 {
+		fs.Usage()
+		os.Exit(2)
+	}
+	t0 := time.Now()
+	in, out := fs.Arg(0), fs.Arg(1)
+
+	data, err := fastbrc.MmapFile(in, fastbrc.InputWillNeed)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer unix.Munmap(data)
+	if data[len(data)-1] != '\n' {
+		log.Fatalf("%s doesn't end with a line", in)
+	}
+
+	f, err := os.Create(out)
+	if err != nil {
+		log.Fatal(err)
+	}
+	chunker := fastbrc.NewAtomicChunker(data, *chunkSize)
+	stats, err := fastbrc.ConvertBRCB(f, chunker, chunker, *nworkers)
+	if err != nil {
+		f.Close()
+		os.Remove(out)
+		log.Fatalf("convert: %s", err)
+	}
+	if err := f.Close(); err != nil {
+		log.Fatal(err)
+	}
+	log.Printf("converted %d rows of %d stations in %d blocks, %d bytes to %d, took: %0.3f",
+		stats.Rows, stats.Stations, stats.Blocks, len(data), stats.Bytes, time.Since(t0).Seconds())
+}
@@ -4,19 +4,19 @@ go 1.23.5
 
 require (
 	github.com/cespare/xxhash/v2 v2.3.0
+	github.com/hashicorp/go-immutable-radix/v2 v2.1.0
+	github.com/plar/go-adaptive-radix-tree/v2 v2.0.3
 	github.com/stretchr/testify v1.10.0
+	github.com/zeebo/xxh3 v1.0.2
 	golang.org/x/exp v0.0.0-20250228200357-dead58393ab7
+	golang.org/x/sys v0.31.0
 )
 
 require (
 	github.com/davecgh/go-spew v1.1.1 // indirect
-	github.com/hashicorp/go-immutable-radix/v2 v2.1.0 // indirect
 	github.com/hashicorp/golang-lru/v2 v2.0.0 // indirect
 	github.com/klauspost/cpuid/v2 v2.0.9 // indirect
-	github.com/plar/go-adaptive-radix-tree/v2 v2.0.3 // indirect
 	github.com/pmezard/go-difflib v1.0.0 // indirect
 	github.com/twmb/murmur3 v1.1.8 // indirect
-	github.com/zeebo/xxh3 v1.0.2 // indirect
-	golang.org/x/sys v0.31.0 // indirect
 	gopkg.in/yaml.v3 v3.0.1 // indirect
 )
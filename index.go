@@ -0,0 +1,133 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"log/slog"
+	"os"
+	"runtime"
+	"slices"
+	"time"
+
+	"golang.org/x/sys/unix"
+
+	"1brc/internal/fastbrc"
+)
+
+// indexMain is fastbrc index: it writes the station index of a measurements
+// file next to it, that fastbrc -station uses to only parse the chunks of the
+// station
+func indexMain(args []string) {
+	fs := flag.NewFlagSet("index", flag.ExitOnError)
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "usage: %s index [flags] data.txt\n", os.Args[0])
+		fs.PrintDefaults()
+	}
+	nworkers := fs.Int("n", runtime.NumCPU(), "number of workers")
+	chunkSize := fs.Int("chunksize", 1024*1024, "size of the indexed chunks, the smallest part of the input parsed by -station")
+	sorted := fs.String("sort", "", "first write the lines grouped by station to this file and index it instead: in random order every station is in every chunk")
+	fs.Parse(args)
+
+	if fs.NArg() != 1 {
+		fs.Usage()
+		os.Exit(2)
+	}
+	t0 := time.Now()
+	in := fs.Arg(0)
+
+	data, err := fastbrc.MmapFile(in, fastbrc.InputWillNeed)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer unix.Munmap(data)
+	if data[len(data)-1] != '\n' {
+		log.Fatalf("%s doesn't end with a line", in)
+	}
+
+	if *sorted != "" {
+		if err := sortFile(*sorted, data, *chunkSize, *nworkers); err != nil {
+			os.Remove(*sorted)
+			log.Fatalf("sort: %s", err)
+		}
+		log.Printf("sorted %s to %s, took: %0.3f", in, *sorted, time.Since(t0).Seconds())
+		unix.Munmap(data)
+		in = *sorted
+		data, err = fastbrc.MmapFile(in, fastbrc.InputWillNeed)
+		if err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	fi, err := os.Stat(in)
+	if err != nil {
+		log.Fatal(err)
+	}
+	ix, err := fastbrc.BuildIndex(data, *chunkSize, *nworkers)
+	if err != nil {
+		log.Fatalf("index: %s", err)
+	}
+	ix.ModTime = fi.ModTime().UnixNano()
+	b := fastbrc.MarshalIndex(ix)
+	if err := os.WriteFile(fastbrc.IndexPath(in), b, 0o644); err != nil {
+		log.Fatal(err)
+	}
+	log.Printf("indexed %d stations in %d chunks to %s, %d bytes, took: %0.3f",
+		len(ix.Chunks), len(ix.Ends), fastbrc.IndexPath(in), len(b), time.Since(t0).Seconds())
+}
+
+// sortFile writes the lines of data grouped by station to the file name
+func sortFile(name string, data []byte, sectionSize, nworkers int) error {
+	f, err := os.Create(name)
+	if err != nil {
+		return err
+	}
+	if err := f.Truncate(int64(len(data))); err != nil {
+		f.Close()
+		return err
+	}
+	if err := fastbrc.SortByStation(f, data, sectionSize, nworkers); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// stationRanges returns the parts of f where the stations names occur, from
+// its index. With normalize, the names of the index are matched once
+// normalized too.
+func stationRanges(f *os.File, indexFile string, stations []string, normalize func(string) string) ([]fastbrc.Range, error) {
+	b, err := os.ReadFile(indexFile)
+	if err != nil {
+		return nil, fmt.Errorf("%w, write it with fastbrc index", err)
+	}
+	ix, err := fastbrc.UnmarshalIndex(b)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", indexFile, err)
+	}
+	fi, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+	if ix.Stale(fi) {
+		return nil, fmt.Errorf("%s is stale, %s changed since it was indexed", indexFile, f.Name())
+	}
+	if normalize != nil {
+		// the index has the names as written in the input
+		for name := range ix.Chunks {
+			if n := normalize(name); n != name && slices.Contains(stations, n) {
+				stations = append(stations, name)
+			}
+		}
+	}
+	ranges := ix.Ranges(stations)
+	if ranges == nil {
+		ranges = []fastbrc.Range{}
+	}
+	var size int
+	for _, r := range ranges {
+		size += r.End - r.Start
+	}
+	slog.Debug("Station ranges", "ranges", len(ranges), "bytes", size, "of", ix.Size)
+	return ranges, nil
+}
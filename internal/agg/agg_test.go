@@ -0,0 +1,60 @@
+package agg
+
+import (
+	"testing"
+	"unsafe"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"1brc/internal/format"
+)
+
+func TestStationSize(t *testing.T) {
+	// the tables of the workers are indexed with unsafe, and sized for it
+	assert.Equal(t, uintptr(40), unsafe.Sizeof(Station{}))
+}
+
+func TestStationAdd(t *testing.T) {
+	s := NewStation(-15)
+	s.Add(99)
+	s.NewMeasurementNoBranch(15)
+	assert.Equal(t, Station{Min: -15, Max: 99, Total: 99, N: 3}, *s)
+	assert.Equal(t, "-1.5/3.3/9.9", s.Format(format.RoundHalfUp))
+	assert.InDelta(t, 3.3, s.Value("mean"), 1e-9)
+	assert.Equal(t, 11.4, s.Value("range"))
+
+	s.Excluded = true
+	s.Add(999)
+	assert.Equal(t, int32(4), s.N)
+	assert.Equal(t, int16(99), s.Max)
+}
+
+func TestResultsMerge(t *testing.T) {
+	r := NewResults()
+	r.MergeTable([]Station{
+		{Min: 10, Max: 20, Total: 30, N: 2, Name: []byte("Abha")},
+		{Min: 32767, Max: -32767},
+		{Min: 5, Max: 5, Total: 5, N: 1, Excluded: true, Name: []byte("Oslo")},
+	})
+	r.MergeResults(Results{"Abha": {Min: -10, Max: 30, Total: 20, N: 2}})
+	r.Merge("Abha", &Station{Min: 0, Max: 0, Total: 0, N: 1})
+
+	require.Len(t, r, 2)
+	assert.Equal(t, Station{Min: -10, Max: 30, Total: 50, N: 5}, *r["Abha"])
+	assert.Equal(t, Station{Min: 5, Max: 5, Total: 5, N: 1, Excluded: true}, *r["Oslo"])
+	assert.Equal(t, []string{"Abha", "Oslo"}, r.Names())
+	assert.Equal(t, "{Abha=-1.0/1.0/3.0, Oslo=0.5/0.5/0.5}", r.String())
+}
+
+func TestWelford(t *testing.T) {
+	values := []float64{2, 4, 4, 4, 5, 5, 7, 9}
+	var all Welford
+	for _, v := range values {
+		all.Add(v)
+	}
+	assert.Equal(t, int64(8), all.N)
+	assert.InDelta(t, 5, all.Mean, 1e-12)
+	assert.InDelta(t, 2, all.Stddev(), 1e-12)
+	assert.Zero(t, (&Welford{N: 1, Mean: 3}).Stddev())
+}
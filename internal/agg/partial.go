@@ -0,0 +1,330 @@
+package agg
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"maps"
+	"slices"
+	"strings"
+
+	"1brc/internal/format"
+)
+
+// Partial is a station table that can be saved with Marshal and merged
+// with others later, i.e: one per file or per worker of a batch job. Unlike
+// Station, sums and counts don't overflow when merging a lot of them.
+type Partial struct {
+	Stations map[string]*PartialStation
+}
+
+// Histograms count the measurements of each value, from -99.9 to 99.9
+const (
+	HistogramBins   = 1999
+	HistogramOffset = 999
+)
+
+// PartialStation is the aggregate of a station in a Partial, temperatures
+// are in tenths
+type PartialStation struct {
+	Min   int16
+	Max   int16
+	Sum   int64
+	Count int64
+	// Histogram has HistogramBins counts, the one of value v at
+	// v+HistogramOffset. It's optional, nil when not tracked.
+	Histogram []uint64
+}
+
+func NewPartial() *Partial {
+	return &Partial{Stations: make(map[string]*PartialStation)}
+}
+
+// NewPartialResults returns the partial of r, without the excluded stations
+func NewPartialResults(r Results) *Partial {
+	p := NewPartial()
+	for name, s := range r {
+		p.AddStation(name, s)
+	}
+	return p
+}
+
+// AddStation merges s to the station named name, unless s is excluded or
+// empty
+func (p *Partial) AddStation(name string, s *Station) {
+	p.AddHistogram(name, s, nil)
+}
+
+// AddHistogram is AddStation with the histogram h of s, nil when not tracked
+func (p *Partial) AddHistogram(name string, s *Station, h []uint64) {
+	if s.Excluded || s.N == 0 {
+		return
+	}
+	p.merge(name, &PartialStation{Min: s.Min, Max: s.Max, Sum: int64(s.Total), Count: int64(s.N), Histogram: h})
+}
+
+// Merge merges o into p. Merging is commutative and associative, a station
+// keeps its histogram only when all of its parts have one.
+func (p *Partial) Merge(o *Partial) {
+	for name, s := range o.Stations {
+		p.merge(name, s)
+	}
+}
+
+func (p *Partial) merge(name string, o *PartialStation) {
+	s, ok := p.Stations[name]
+	if !ok {
+		s := *o
+		s.Histogram = slices.Clone(o.Histogram)
+		p.Stations[name] = &s
+		return
+	}
+	s.Min = min(s.Min, o.Min)
+	s.Max = max(s.Max, o.Max)
+	s.Sum += o.Sum
+	s.Count += o.Count
+	if s.Histogram == nil || o.Histogram == nil {
+		s.Histogram = nil
+		return
+	}
+	for i, n := range o.Histogram {
+		s.Histogram[i] += n
+	}
+}
+
+// Names returns the names sorted in byte order
+func (p *Partial) Names() []string {
+	return slices.Sorted(maps.Keys(p.Stations))
+}
+
+// Encode writes the stations of names, in that order, see Results.Encode
+func (p *Partial) Encode(w io.Writer, names []string, rounding string) error {
+	e := format.NewEncoder(w, rounding)
+	for _, name := range names {
+		s := p.Stations[name]
+		e.Station(name, int64(s.Min), int64(s.Max), s.Sum, s.Count)
+	}
+	return e.Close()
+}
+
+// String formats all the stations sorted by name, see Results.String
+func (p *Partial) String() string {
+	var out strings.Builder
+	p.Encode(&out, p.Names(), format.RoundFloat)
+	return out.String()
+}
+
+// Encoding of a Partial, all integers are varints:
+//
+//	"BRCP" version flags nstations
+//	nstations * (len(name) name min max sum count)
+//	if flags&partialHistograms, for each station in the same order:
+//	  nbins * (bin-previous bin count), previous bin starts at -1
+//
+// Stations are sorted by name, the same Partial always gives the same bytes.
+const (
+	partialMagic   = "BRCP"
+	PartialVersion = 1
+
+	partialHistograms = 1 << 0
+)
+
+var ErrPartialFormat = errors.New("not a partial")
+
+// Marshal encodes p, see Unmarshal
+func Marshal(p *Partial) []byte {
+	names := p.Names()
+	var flags uint64
+	for _, name := range names {
+		if p.Stations[name].Histogram != nil {
+			flags |= partialHistograms
+		}
+	}
+
+	b := []byte(partialMagic)
+	b = binary.AppendUvarint(b, PartialVersion)
+	b = binary.AppendUvarint(b, flags)
+	b = binary.AppendUvarint(b, uint64(len(names)))
+	for _, name := range names {
+		s := p.Stations[name]
+		b = binary.AppendUvarint(b, uint64(len(name)))
+		b = append(b, name...)
+		b = binary.AppendVarint(b, int64(s.Min))
+		b = binary.AppendVarint(b, int64(s.Max))
+		b = binary.AppendVarint(b, s.Sum)
+		b = binary.AppendVarint(b, s.Count)
+	}
+	if flags&partialHistograms == 0 {
+		return b
+	}
+
+	for _, name := range names {
+		h := p.Stations[name].Histogram
+		if h == nil {
+			// not tracked, told apart from an empty histogram by a
+			// count of bins out of range
+			b = binary.AppendUvarint(b, HistogramBins+1)
+			continue
+		}
+		nbins := 0
+		for _, n := range h {
+			if n != 0 {
+				nbins++
+			}
+		}
+		b = binary.AppendUvarint(b, uint64(nbins))
+		prev := -1
+		for i, n := range h {
+			if n != 0 {
+				b = binary.AppendUvarint(b, uint64(i-prev))
+				b = binary.AppendUvarint(b, n)
+				prev = i
+			}
+		}
+	}
+	return b
+}
+
+// partialReader reads varints, keeping the first error
+type partialReader struct {
+	b   []byte
+	err error
+}
+
+func (r *partialReader) fail(format string, args ...any) {
+	if r.err == nil {
+		r.err = fmt.Errorf("%w: "+format, append([]any{ErrPartialFormat}, args...)...)
+	}
+}
+
+func (r *partialReader) uvarint() uint64 {
+	if r.err != nil {
+		return 0
+	}
+	v, n := binary.Uvarint(r.b)
+	if n <= 0 {
+		r.fail("truncated or invalid varint")
+		return 0
+	}
+	r.b = r.b[n:]
+	return v
+}
+
+func (r *partialReader) varint() int64 {
+	if r.err != nil {
+		return 0
+	}
+	v, n := binary.Varint(r.b)
+	if n <= 0 {
+		r.fail("truncated or invalid varint")
+		return 0
+	}
+	r.b = r.b[n:]
+	return v
+}
+
+func (r *partialReader) int16() int16 {
+	v := r.varint()
+	if v < -32768 || v > 32767 {
+		r.fail("value %d out of range", v)
+	}
+	return int16(v)
+}
+
+func (r *partialReader) bytes(n uint64) []byte {
+	if r.err != nil {
+		return nil
+	}
+	if n > uint64(len(r.b)) {
+		r.fail("truncated")
+		return nil
+	}
+	b := r.b[:n]
+	r.b = r.b[n:]
+	return b
+}
+
+// Unmarshal decodes a Partial encoded by Marshal
+func Unmarshal(b []byte) (*Partial, error) {
+	if !bytes.HasPrefix(b, []byte(partialMagic)) {
+		return nil, ErrPartialFormat
+	}
+	r := &partialReader{b: b[len(partialMagic):]}
+	version := r.uvarint()
+	if r.err == nil && version != PartialVersion {
+		return nil, fmt.Errorf("unsupported partial version %d, want %d", version, PartialVersion)
+	}
+	flags := r.uvarint()
+	if flags&^partialHistograms != 0 {
+		r.fail("unknown flags %#x", flags)
+	}
+	nstations := r.uvarint()
+	if nstations > uint64(len(r.b)) {
+		// each station takes more than a byte
+		r.fail("%d stations", nstations)
+	}
+
+	p := NewPartial()
+	names := make([]string, 0, nstations)
+	for range nstations {
+		name := string(r.bytes(r.uvarint()))
+		s := &PartialStation{
+			Min:   r.int16(),
+			Max:   r.int16(),
+			Sum:   r.varint(),
+			Count: r.varint(),
+		}
+		if r.err != nil {
+			break
+		}
+		if _, ok := p.Stations[name]; ok {
+			r.fail("duplicate station %q", name)
+			break
+		}
+		if s.Count <= 0 || s.Min > s.Max {
+			r.fail("invalid station %q", name)
+			break
+		}
+		p.Stations[name] = s
+		names = append(names, name)
+	}
+
+	if flags&partialHistograms != 0 {
+		for _, name := range names {
+			nbins := r.uvarint()
+			if r.err != nil || nbins == HistogramBins+1 {
+				continue
+			}
+			if nbins > HistogramBins {
+				r.fail("%d bins", nbins)
+				break
+			}
+			h := make([]uint64, HistogramBins)
+			bin := -1
+			for range nbins {
+				delta := r.uvarint()
+				if delta == 0 || delta > HistogramBins {
+					r.fail("invalid bin")
+					break
+				}
+				bin += int(delta)
+				if bin >= HistogramBins {
+					r.fail("bin out of range")
+					break
+				}
+				h[bin] = r.uvarint()
+			}
+			p.Stations[name].Histogram = h
+		}
+	}
+
+	if r.err == nil && len(r.b) > 0 {
+		r.fail("%d trailing bytes", len(r.b))
+	}
+	if r.err != nil {
+		return nil, r.err
+	}
+	return p, nil
+}
@@ -0,0 +1,146 @@
+package agg
+
+import (
+	"math/rand"
+	"reflect"
+	"testing"
+	"testing/quick"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// randomPartial generates partials sharing some of their stations
+type randomPartial struct {
+	*Partial
+}
+
+func (randomPartial) Generate(r *rand.Rand, size int) reflect.Value {
+	names := []string{"Abha", "Abéché", "A", "Yo", "St. John's", "Zürich", "Las Palmas de Gran Canaria"}
+	p := NewPartial()
+	for range r.Intn(len(names) + 1) {
+		name := names[r.Intn(len(names))]
+		a, b := int16(r.Intn(HistogramBins)-HistogramOffset), int16(r.Intn(HistogramBins)-HistogramOffset)
+		s := &PartialStation{Min: min(a, b), Max: max(a, b), Count: 1 + r.Int63n(int64(size)*1000+1)}
+		s.Sum = int64(s.Min)*s.Count + r.Int63n(int64(s.Max-s.Min)*s.Count+1)
+		if r.Intn(4) != 0 {
+			s.Histogram = make([]uint64, HistogramBins)
+			for range r.Intn(10) {
+				s.Histogram[r.Intn(HistogramBins)] += uint64(r.Intn(1000))
+			}
+		}
+		p.merge(name, s)
+	}
+	return reflect.ValueOf(randomPartial{p})
+}
+
+// clone copies p, merging mutates the destination
+func clone(p *Partial) *Partial {
+	c := NewPartial()
+	c.Merge(p)
+	return c
+}
+
+func merged(ps ...*Partial) *Partial {
+	m := NewPartial()
+	for _, p := range ps {
+		m.Merge(p)
+	}
+	return m
+}
+
+func TestPartialRoundTrip(t *testing.T) {
+	f := func(p randomPartial) bool {
+		u, err := Unmarshal(Marshal(p.Partial))
+		return err == nil && assert.Equal(t, p.Partial, u)
+	}
+	require.NoError(t, quick.Check(f, nil))
+}
+
+func TestPartialMergeCommutative(t *testing.T) {
+	f := func(a, b randomPartial) bool {
+		ab := clone(a.Partial)
+		ab.Merge(b.Partial)
+		ba := clone(b.Partial)
+		ba.Merge(a.Partial)
+		return assert.Equal(t, Marshal(ab), Marshal(ba))
+	}
+	require.NoError(t, quick.Check(f, nil))
+}
+
+func TestPartialMergeAssociative(t *testing.T) {
+	f := func(a, b, c randomPartial) bool {
+		left := merged(merged(a.Partial, b.Partial), c.Partial)
+		right := merged(a.Partial, merged(b.Partial, c.Partial))
+		return assert.Equal(t, Marshal(left), Marshal(right))
+	}
+	require.NoError(t, quick.Check(f, nil))
+}
+
+func TestPartialMergeIdentity(t *testing.T) {
+	f := func(a randomPartial) bool {
+		before := Marshal(a.Partial)
+		return assert.Equal(t, before, Marshal(merged(a.Partial, NewPartial()))) &&
+			assert.Equal(t, before, Marshal(merged(NewPartial(), a.Partial))) &&
+			// merging doesn't touch its argument
+			assert.Equal(t, before, Marshal(a.Partial))
+	}
+	require.NoError(t, quick.Check(f, nil))
+}
+
+func TestPartialOfResults(t *testing.T) {
+	whole := NewResults()
+	halves := []Results{NewResults(), NewResults()}
+	for i, m := range []int16{-15, 99, 15, 0, -999, 999, 5} {
+		name := []string{"Abha", "Oslo", "Yo"}[i%3]
+		whole.Merge(name, NewStation(m))
+		halves[i%2].Merge(name, NewStation(m))
+	}
+	whole.Merge("Excluded", &Station{N: 12, Excluded: true})
+
+	p := merged(NewPartialResults(halves[0]), NewPartialResults(halves[1]))
+	assert.Equal(t, NewPartialResults(whole), p)
+	delete(whole, "Excluded")
+	assert.Equal(t, whole.String(), p.String())
+}
+
+func TestAddHistogram(t *testing.T) {
+	h := make([]uint64, HistogramBins)
+	h[HistogramOffset-15] = 1
+	p := NewPartial()
+	p.AddHistogram("Abha", NewStation(-15), h)
+	p.AddHistogram("Abha", NewStation(-15), h)
+	p.AddHistogram("Excluded", &Station{N: 1, Excluded: true}, h)
+	assert.Equal(t, uint64(1), h[HistogramOffset-15], "h is copied")
+	require.Len(t, p.Stations, 1)
+	assert.Equal(t, uint64(2), p.Stations["Abha"].Histogram[HistogramOffset-15])
+	assert.Equal(t, int64(-30), p.Stations["Abha"].Sum)
+
+	// a part without histogram drops it
+	p.AddStation("Abha", NewStation(1))
+	assert.Nil(t, p.Stations["Abha"].Histogram)
+}
+
+func TestUnmarshalInvalid(t *testing.T) {
+	p := NewPartial()
+	p.merge("Abha", &PartialStation{Min: -15, Max: 99, Sum: 100, Count: 3, Histogram: make([]uint64, HistogramBins)})
+	p.Stations["Abha"].Histogram[12] = 3
+	p.merge("Yo", &PartialStation{Min: 1, Max: 1, Sum: 1, Count: 1})
+	b := Marshal(p)
+
+	u, err := Unmarshal(b)
+	require.NoError(t, err)
+	assert.Equal(t, p, u)
+
+	for i := range b {
+		_, err := Unmarshal(b[:i])
+		assert.ErrorIs(t, err, ErrPartialFormat, "truncated at %d", i)
+	}
+	_, err = Unmarshal(append(b, 0))
+	assert.ErrorIs(t, err, ErrPartialFormat)
+
+	future := append([]byte("BRCP"), b[4:]...)
+	future[4] = PartialVersion + 1
+	_, err = Unmarshal(future)
+	assert.ErrorContains(t, err, "unsupported partial version 2")
+}
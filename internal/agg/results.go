@@ -0,0 +1,74 @@
+package agg
+
+import (
+	"io"
+	"maps"
+	"slices"
+	"strings"
+
+	"1brc/internal/format"
+)
+
+// Results are the stations merged by name
+type Results map[string]*Station
+
+func NewResults() Results {
+	return make(Results, 2048)
+}
+
+// Merge adds s to the station named name. The first station of a name is
+// copied with its flags but without its name, the key has it.
+func (r Results) Merge(name string, s *Station) {
+	merged, ok := r[name]
+	if !ok {
+		r[name] = &Station{
+			Min:      s.Min,
+			Max:      s.Max,
+			Total:    s.Total,
+			N:        s.N,
+			Excluded: s.Excluded,
+			Unknown:  s.Unknown,
+		}
+		return
+	}
+	merged.Merge(s)
+}
+
+// MergeTable merges the stations of the table of a worker, the empty slots
+// without a name are skipped
+func (r Results) MergeTable(table []Station) {
+	for i := range table {
+		if len(table[i].Name) == 0 {
+			continue
+		}
+		r.Merge(string(table[i].Name), &table[i])
+	}
+}
+
+// MergeResults merges all the stations of o
+func (r Results) MergeResults(o Results) {
+	for name, s := range o {
+		r.Merge(name, s)
+	}
+}
+
+// Names returns the names sorted in byte order
+func (r Results) Names() []string {
+	return slices.Sorted(maps.Keys(r))
+}
+
+// Encode writes the stations of names, in that order
+func (r Results) Encode(w io.Writer, names []string, rounding string) error {
+	e := format.NewEncoder(w, rounding)
+	for _, name := range names {
+		r[name].Encode(e, name)
+	}
+	return e.Close()
+}
+
+// String formats all the stations sorted by name, rounded like FancyPrint
+func (r Results) String() string {
+	var out strings.Builder
+	r.Encode(&out, r.Names(), format.RoundFloat)
+	return out.String()
+}
@@ -0,0 +1,100 @@
+// Package agg holds the aggregate of the measurements of a station, shared by
+// the experiments of brc and the fast path of fastbrc, and the merged results.
+package agg
+
+import "1brc/internal/format"
+
+// Station aggregates measurements in tenths of degrees
+type Station struct {
+	Min   int16
+	Max   int16
+	Total int32
+	N     int32
+	// Excluded stations only count their lines in N, see fastbrc.Filter.
+	// The flags fit in the padding before Name.
+	Excluded bool
+	// Unknown stations are missing from the StationSet of the worker
+	Unknown bool
+	Name    []byte
+}
+
+// NewStation returns a station with a first measurement m
+func NewStation(m int16) *Station {
+	return &Station{
+		Min:   m,
+		Max:   m,
+		Total: int32(m),
+		N:     1,
+	}
+}
+
+// FancyPrint formats min/mean/max with the mean rounded by %.1f, see Format
+func (s *Station) FancyPrint() string {
+	return s.Format(format.RoundFloat)
+}
+
+// Format formats min/mean/max with the mean rounded as set by rounding, see
+// format.RoundingModes
+func (s *Station) Format(rounding string) string {
+	return format.Stats(int64(s.Min), int64(s.Max), int64(s.Total), int64(s.N), rounding)
+}
+
+// Encode writes the station to e, without allocating
+func (s *Station) Encode(e *format.Encoder, name string) {
+	e.Station(name, int64(s.Min), int64(s.Max), int64(s.Total), int64(s.N))
+}
+
+func (s *Station) NewMeasurement(m int16) {
+	s.N += 1
+	s.Total += int32(m)
+	if m < s.Min {
+		s.Min = m
+	}
+	if m > s.Max {
+		s.Max = m
+	}
+}
+
+// Add is NewMeasurement for the workers with a filter, lines of excluded
+// stations are only counted. Workers without one call NewMeasurement, the
+// branch is not on their hot path.
+func (s *Station) Add(m int16) {
+	if s.Excluded {
+		s.N++
+		return
+	}
+	s.NewMeasurement(m)
+}
+
+func (s *Station) NewMeasurementNoBranch(m int16) {
+	s.N += 1
+	s.Total += int32(m)
+	s.Min = min(m, s.Min)
+	s.Max = max(m, s.Max)
+}
+
+// Merge adds the measurements of o to s, the flags and name of s are kept
+func (s *Station) Merge(o *Station) {
+	s.Total += o.Total
+	s.N += o.N
+	s.Min = min(s.Min, o.Min)
+	s.Max = max(s.Max, o.Max)
+}
+
+// Value returns a numeric field of s, temperatures are in degrees.
+// Fields are mean, min, max, count and range.
+func (s *Station) Value(field string) float64 {
+	switch field {
+	case "mean":
+		return float64(s.Total) / 10 / float64(s.N)
+	case "min":
+		return float64(s.Min) / 10
+	case "max":
+		return float64(s.Max) / 10
+	case "count":
+		return float64(s.N)
+	case "range":
+		return float64(s.Max-s.Min) / 10
+	}
+	panic("unknown field " + field)
+}
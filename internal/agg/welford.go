@@ -0,0 +1,32 @@
+package agg
+
+import "math"
+
+// Welford is a running mean and variance updated one value at a time with
+// Welford's algorithm, which doesn't lose precision like the sum of squares
+// does.
+type Welford struct {
+	N    int64
+	Mean float64
+	// M2 is the sum of the squared differences to the mean
+	M2 float64
+}
+
+func (w *Welford) Add(x float64) {
+	w.N++
+	d := x - w.Mean
+	w.Mean += d / float64(w.N)
+	w.M2 += d * (x - w.Mean)
+}
+
+// Variance is the population variance, 0 until there are 2 values
+func (w *Welford) Variance() float64 {
+	if w.N < 2 {
+		return 0
+	}
+	return w.M2 / float64(w.N)
+}
+
+func (w *Welford) Stddev() float64 {
+	return math.Sqrt(w.Variance())
+}
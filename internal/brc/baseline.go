@@ -2,64 +2,21 @@ package brc
 
 import (
 	"bufio"
-	"fmt"
 	"io"
 	"log"
 	"sort"
 	"strconv"
 	"strings"
-)
-
-type StationInt16 struct {
-	Min   int16
-	Max   int16
-	Total int32
-	N     int32
-	Name  []byte
-}
-
-func num2str(i int16) string {
-	u, d := i/10, i%10
-
-	if i >= 0 {
-		return fmt.Sprintf("%d.%d", u, d)
-	}
 
-	u = -u
-	d = -d
-	return fmt.Sprintf("-%d.%d", u, d)
-}
-
-func (s *StationInt16) FancyPrint() string {
-	avg := float64(s.Total) / 10 / float64(s.N)
-	return num2str(s.Min) + "/" + fmt.Sprintf("%.1f", avg) + "/" + num2str(s.Max)
-}
-
-func (s *StationInt16) NewMeasurement(m int16) {
-	s.N += 1
-	s.Total += int32(m)
-	if m < s.Min {
-		s.Min = m
-	}
-	if m > s.Max {
-		s.Max = m
-	}
-}
+	"1brc/internal/agg"
+	"1brc/internal/format"
+)
 
-func (s *StationInt16) NewMeasurementNoBranch(m int16) {
-	s.N += 1
-	s.Total += int32(m)
-	s.Min = min(m, s.Min)
-	s.Max = max(m, s.Max)
-}
+// StationInt16 is shared with fastbrc, see agg.Station
+type StationInt16 = agg.Station
 
 func NewStationInt16(m int16) *StationInt16 {
-	return &StationInt16{
-		Min:   m,
-		Max:   m,
-		Total: int32(m),
-		N:     1,
-	}
+	return agg.NewStation(m)
 }
 
 type StationInt struct {
@@ -137,7 +94,12 @@ func NewStation(m float64) *Station {
 	}
 }
 
+// Baseline rounds like the reference implementation, see format.RoundHalfUp
 func Baseline(input io.Reader) string {
+	return BaselineRounding(input, format.RoundHalfUp)
+}
+
+func BaselineRounding(input io.Reader, rounding string) string {
 	stations := make(map[string]*Station)
 	scanner := bufio.NewScanner(input)
 	for scanner.Scan() {
@@ -163,15 +125,12 @@ func Baseline(input io.Reader) string {
 	}
 	sort.Strings(keys)
 
-	out := make([]string, 0, len(stations)+2)
-	out = append(out, "{")
-	for i, k := range keys {
-		if i == len(keys)-1 {
-			out = append(out, fmt.Sprintf("%s=%0.1f/%0.1f/%0.1f", k, stations[k].Min, stations[k].Total/float64(stations[k].N), stations[k].Max))
-		} else {
-			out = append(out, fmt.Sprintf("%s=%0.1f/%0.1f/%0.1f, ", k, stations[k].Min, stations[k].Total/float64(stations[k].N), stations[k].Max))
-		}
+	var out strings.Builder
+	e := format.NewEncoder(&out, rounding)
+	for _, k := range keys {
+		station := stations[k]
+		e.FloatStation(k, station.Min, station.Max, station.Total, station.N)
 	}
-	out = append(out, "}")
-	return strings.Join(out, "")
+	e.Close()
+	return out.String()
 }
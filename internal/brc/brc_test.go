@@ -4,7 +4,12 @@ import (
 	"io"
 	"log"
 	"os"
+	"strings"
 	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"1brc/internal/format"
 )
 
 func benchmark(b *testing.B, parserFunc func(io.Reader) string, inputFile string) {
@@ -125,3 +130,9 @@ func BenchmarkPatateParserMmap10m(b *testing.B) {
 func BenchmarkPatateBufferedReader10m(b *testing.B) {
 	benchmark(b, PatateBufferedReader, "../../data/10m.txt")
 }
+
+func TestBaselineRounding(t *testing.T) {
+	input := "Abha;0.1\nAbha;0.2\nAbha;0.3\nAbha;0.05\nOslo;-0.05\nOslo;0.0\n"
+	assert.Equal(t, "{Abha=0.1/0.2/0.3, Oslo=0.0/0.0/0.0}", Baseline(strings.NewReader(input)))
+	assert.Equal(t, "{Abha=0.1/0.2/0.3, Oslo=-0.1/-0.0/0.0}", BaselineRounding(strings.NewReader(input), format.RoundFloat))
+}
@@ -298,6 +298,7 @@ func benchmarkChunkLineScan(b *testing.B, inputFile string) {
 		"IndexByteUnsafe4BytesNoErrCheck": {parseChunkIndexByteUnsafe4BytesNoErrCheck},
 		"IndexBytePatate":                 {parseChunkPatate},
 		"IndexBytePatate8Bytes":           {parseChunkPatate8Bytes},
+		"Masks64":                         {func(b []byte) { fastbrc.LineScanMasks(b) }},
 	}
 
 	for name, tc := range tcs {
@@ -25,20 +25,55 @@ func NewMmapedSectionReaders(inputFile string, nsections int) ([]*io.SectionRead
 	}
 
 	sectionReaders := make([]*io.SectionReader, nsections)
-	mmlen := mm.Len()
+	sections := SectionBoundaries(mm, nsections)
+	for i := range sectionReaders {
+		if i < len(sections) {
+			sectionReaders[i] = io.NewSectionReader(mm, sections[i].Start, sections[i].End-sections[i].Start)
+		} else {
+			// not enough lines for everyone
+			sectionReaders[i] = io.NewSectionReader(mm, int64(mm.Len()), 0)
+		}
+	}
+
+	return sectionReaders, nil
+}
+
+// Section is a newline aligned part of the input, End is the position of the
+// '\n' of its last line.
+type Section struct {
+	Start int64
+	End   int64
+}
+
+// ByteReader is what SectionBoundaries needs from the input, i.e:
+// mmap.ReaderAt
+type ByteReader interface {
+	At(i int) byte
+	Len() int
+}
+
+// SectionBoundaries splits input in nsections of about the same size, moving
+// the end of each one to the end of its line. There are less than nsections
+// when the last ones would be empty.
+func SectionBoundaries(input ByteReader, nsections int) []Section {
+	sections := make([]Section, 0, nsections)
+	mmlen := input.Len()
 	sectionSize := mmlen / nsections
 	sectionStartPos := 0
 	// log.Printf("len: %d, sectionSize: %d", mmlen, sectionSize)
-	for i := range nsections {
+	for range nsections {
+		if sectionStartPos >= mmlen {
+			break
+		}
 		for j := min(sectionStartPos+sectionSize, mmlen-1); j < mmlen; j++ {
-			if mm.At(j) == '\n' {
+			if input.At(j) == '\n' {
 				// log.Printf("start: %10d, len: %10d, end: %10d", sectionStartPos, int64(j-sectionStartPos), j)
-				sectionReaders[i] = io.NewSectionReader(mm, int64(sectionStartPos), int64(j-sectionStartPos))
+				sections = append(sections, Section{Start: int64(sectionStartPos), End: int64(j)})
 				sectionStartPos = j + 1
 				break
 			}
 		}
 	}
 
-	return sectionReaders, nil
+	return sections
 }
@@ -14,3 +14,18 @@ func TestNewMmapedSectionReaders(t *testing.T) {
 		assert.NotNil(t, r)
 	}
 }
+
+type bytesAt []byte
+
+func (b bytesAt) At(i int) byte { return b[i] }
+func (b bytesAt) Len() int      { return len(b) }
+
+func TestSectionBoundaries(t *testing.T) {
+	input := bytesAt("Abha;1.0\nOslo;-2.5\nA;3.3\n")
+	assert.Equal(t, []Section{{0, 8}, {9, 18}, {19, 24}}, SectionBoundaries(input, 3))
+	assert.Equal(t, []Section{{0, 18}, {19, 24}}, SectionBoundaries(input, 2))
+	// the end of the 1st half is moved to the end of A;3.3
+	assert.Equal(t, []Section{{0, 14}, {15, 20}}, SectionBoundaries(bytesAt("Abha;1.0\nA;3.3\nB;1.1\n"), 2))
+	assert.Equal(t, []Section{{0, 8}, {9, 18}, {19, 24}}, SectionBoundaries(input, 10))
+	assert.Empty(t, SectionBoundaries(bytesAt(""), 2))
+}
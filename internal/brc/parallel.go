@@ -3,14 +3,14 @@ package brc
 import (
 	"bufio"
 	"bytes"
-	"fmt"
 	"io"
 	"log"
 	"runtime"
 	"slices"
 	"sort"
-	"strings"
 	"sync"
+
+	"1brc/internal/agg"
 )
 
 func Carotte(inputFile string) string {
@@ -32,50 +32,11 @@ func Carotte(inputFile string) string {
 	}
 
 	wg.Wait()
-	mergedStations := make(map[string]*StationInt16, 2048)
-	for i := range stationMaps {
-		for k, v := range stationMaps[i] {
-			merged, ok := mergedStations[k]
-			if !ok {
-				merged = &StationInt16{
-					Min:   v.Min,
-					Max:   v.Max,
-					Total: v.Total,
-					N:     v.N,
-				}
-				mergedStations[k] = merged
-				continue
-			}
-
-			merged.Total += v.Total
-			merged.N += v.N
-			if v.Min < merged.Min {
-				v.Min = merged.Min
-			}
-			if v.Max > merged.Max {
-				v.Max = merged.Max
-			}
-		}
-	}
-
-	keys := make([]string, 0, len(mergedStations))
-	for k := range mergedStations {
-		keys = append(keys, k)
-	}
-	sort.Strings(keys)
-
-	out := make([]string, 0, len(mergedStations)+2)
-	out = append(out, "{")
-	for i, k := range keys {
-		station := mergedStations[k]
-		if i == len(keys)-1 {
-			out = append(out, fmt.Sprintf("%s=%s", k, station.FancyPrint()))
-		} else {
-			out = append(out, fmt.Sprintf("%s=%s, ", k, station.FancyPrint()))
-		}
+	results := agg.NewResults()
+	for _, stations := range stationMaps {
+		results.MergeResults(stations)
 	}
-	out = append(out, "}")
-	return strings.Join(out, "")
+	return results.String()
 }
 
 func ParallelReadSliceFixedInt16Unsafe(input io.Reader) map[string]*StationInt16 {
@@ -137,51 +98,11 @@ func ParallelReadSliceFixedInt16UnsafeBSearchNames(inputFile string) string {
 	}
 
 	wg.Wait()
-	mergedStations := make(map[string]*StationInt16, 2048)
-	for i := range stationTables {
-		table := stationTables[i]
-		for j := range table {
-			merged, ok := mergedStations[string(table[j].Name)]
-			if !ok {
-				merged = &StationInt16{
-					Min:   table[j].Min,
-					Max:   table[j].Max,
-					Total: table[j].Total,
-					N:     table[j].N,
-				}
-				mergedStations[string(table[j].Name)] = merged
-				continue
-			}
-
-			merged.Total += table[j].Total
-			merged.N += table[j].N
-			if table[j].Min < merged.Min {
-				table[j].Min = merged.Min
-			}
-			if table[j].Max > merged.Max {
-				table[j].Max = merged.Max
-			}
-		}
+	results := agg.NewResults()
+	for _, table := range stationTables {
+		results.MergeTable(table)
 	}
-
-	keys := make([]string, 0, len(mergedStations))
-	for k := range mergedStations {
-		keys = append(keys, k)
-	}
-	sort.Strings(keys)
-
-	out := make([]string, 0, len(mergedStations)+2)
-	out = append(out, "{")
-	for i, k := range keys {
-		station := mergedStations[k]
-		if i == len(keys)-1 {
-			out = append(out, fmt.Sprintf("%s=%s", k, station.FancyPrint()))
-		} else {
-			out = append(out, fmt.Sprintf("%s=%s, ", k, station.FancyPrint()))
-		}
-	}
-	out = append(out, "}")
-	return strings.Join(out, "")
+	return results.String()
 }
 
 func parallelReadSliceFixedInt16UnsafeBSearchNames(input io.Reader) []StationInt16 {
@@ -273,54 +194,11 @@ func ParallelReadSliceFixedInt16UnsafeOpenAddr(inputFile string) string {
 	}
 
 	wg.Wait()
-	mergedStations := make(map[string]*StationInt16, 2048)
-	for i := range stationTables {
-		table := stationTables[i]
-		for j := range table {
-			if len(table[j].Name) == 0 {
-				continue
-			}
-			merged, ok := mergedStations[string(table[j].Name)]
-			if !ok {
-				merged = &StationInt16{
-					Min:   table[j].Min,
-					Max:   table[j].Max,
-					Total: table[j].Total,
-					N:     table[j].N,
-				}
-				mergedStations[string(table[j].Name)] = merged
-				continue
-			}
-
-			merged.Total += table[j].Total
-			merged.N += table[j].N
-			if table[j].Min < merged.Min {
-				table[j].Min = merged.Min
-			}
-			if table[j].Max > merged.Max {
-				table[j].Max = merged.Max
-			}
-		}
+	results := agg.NewResults()
+	for _, table := range stationTables {
+		results.MergeTable(table)
 	}
-
-	keys := make([]string, 0, len(mergedStations))
-	for k := range mergedStations {
-		keys = append(keys, k)
-	}
-	sort.Strings(keys)
-
-	out := make([]string, 0, len(mergedStations)+2)
-	out = append(out, "{")
-	for i, k := range keys {
-		station := mergedStations[k]
-		if i == len(keys)-1 {
-			out = append(out, fmt.Sprintf("%s=%s", k, station.FancyPrint()))
-		} else {
-			out = append(out, fmt.Sprintf("%s=%s, ", k, station.FancyPrint()))
-		}
-	}
-	out = append(out, "}")
-	return strings.Join(out, "")
+	return results.String()
 }
 
 func ParallelRunner(inputFile string, nworkers int, parser func(io.Reader) []StationInt16) string {
@@ -341,54 +219,11 @@ func ParallelRunner(inputFile string, nworkers int, parser func(io.Reader) []Sta
 	}
 
 	wg.Wait()
-	mergedStations := make(map[string]*StationInt16, 2048)
-	for i := range stationTables {
-		table := stationTables[i]
-		for j := range table {
-			if len(table[j].Name) == 0 {
-				continue
-			}
-			merged, ok := mergedStations[string(table[j].Name)]
-			if !ok {
-				merged = &StationInt16{
-					Min:   table[j].Min,
-					Max:   table[j].Max,
-					Total: table[j].Total,
-					N:     table[j].N,
-				}
-				mergedStations[string(table[j].Name)] = merged
-				continue
-			}
-
-			merged.Total += table[j].Total
-			merged.N += table[j].N
-			if table[j].Min < merged.Min {
-				table[j].Min = merged.Min
-			}
-			if table[j].Max > merged.Max {
-				table[j].Max = merged.Max
-			}
-		}
-	}
-
-	keys := make([]string, 0, len(mergedStations))
-	for k := range mergedStations {
-		keys = append(keys, k)
-	}
-	sort.Strings(keys)
-
-	out := make([]string, 0, len(mergedStations)+2)
-	out = append(out, "{")
-	for i, k := range keys {
-		station := mergedStations[k]
-		if i == len(keys)-1 {
-			out = append(out, fmt.Sprintf("%s=%s", k, station.FancyPrint()))
-		} else {
-			out = append(out, fmt.Sprintf("%s=%s, ", k, station.FancyPrint()))
-		}
+	results := agg.NewResults()
+	for _, table := range stationTables {
+		results.MergeTable(table)
 	}
-	out = append(out, "}")
-	return strings.Join(out, "")
+	return results.String()
 }
 
 func parallelReadSliceFixedInt16UnsafeOpenAddr(input io.Reader) []StationInt16 {
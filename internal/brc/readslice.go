@@ -10,6 +10,8 @@ import (
 	"strconv"
 	"strings"
 	"unsafe"
+
+	"1brc/internal/agg"
 )
 
 func ReadSliceMmap(inputFile string) string {
@@ -281,24 +283,7 @@ func ReadSliceFixedInt16Unsafe(input io.Reader) string {
 		}
 	}
 
-	keys := make([]string, 0, len(stations))
-	for k := range stations {
-		keys = append(keys, k)
-	}
-	sort.Strings(keys)
-
-	out := make([]string, 0, len(stations)+2)
-	out = append(out, "{")
-	for i, k := range keys {
-		station := stations[k]
-		if i == len(keys)-1 {
-			out = append(out, fmt.Sprintf("%s=%s", k, station.FancyPrint()))
-		} else {
-			out = append(out, fmt.Sprintf("%s=%s, ", k, station.FancyPrint()))
-		}
-	}
-	out = append(out, "}")
-	return strings.Join(out, "")
+	return agg.Results(stations).String()
 }
 
 func ReadSliceStringHashFixedInt16Unsafe(input io.Reader) string {
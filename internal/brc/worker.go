@@ -2,13 +2,12 @@ package brc
 
 import (
 	"bytes"
-	"fmt"
 	"io"
 	"log"
 	"os"
-	"sort"
-	"strings"
 	"sync"
+
+	"1brc/internal/agg"
 )
 
 const chunksize = 256 * 1024
@@ -109,54 +108,11 @@ func ParallelWorkerRunner(inputFile string, nworkers int, parser func(<-chan *[]
 	}
 
 	wg.Wait()
-	mergedStations := make(map[string]*StationInt16, 2048)
-	for i := range stationTables {
-		table := stationTables[i]
-		for j := range table {
-			if len(table[j].Name) == 0 {
-				continue
-			}
-			merged, ok := mergedStations[string(table[j].Name)]
-			if !ok {
-				merged = &StationInt16{
-					Min:   table[j].Min,
-					Max:   table[j].Max,
-					Total: table[j].Total,
-					N:     table[j].N,
-				}
-				mergedStations[string(table[j].Name)] = merged
-				continue
-			}
-
-			merged.Total += table[j].Total
-			merged.N += table[j].N
-			if table[j].Min < merged.Min {
-				table[j].Min = merged.Min
-			}
-			if table[j].Max > merged.Max {
-				table[j].Max = merged.Max
-			}
-		}
-	}
-
-	keys := make([]string, 0, len(mergedStations))
-	for k := range mergedStations {
-		keys = append(keys, k)
-	}
-	sort.Strings(keys)
-
-	out := make([]string, 0, len(mergedStations)+2)
-	out = append(out, "{")
-	for i, k := range keys {
-		station := mergedStations[k]
-		if i == len(keys)-1 {
-			out = append(out, fmt.Sprintf("%s=%s", k, station.FancyPrint()))
-		} else {
-			out = append(out, fmt.Sprintf("%s=%s, ", k, station.FancyPrint()))
-		}
+	results := agg.NewResults()
+	for _, table := range stationTables {
+		results.MergeTable(table)
 	}
-	out = append(out, "}")
-	return strings.Join(out, "")
+	return results.String()
 }
 
 func ParallelChunkChannelFixedInt16UnsafeOpenAddr(chunkCh <-chan *[]byte) []StationInt16 {
@@ -0,0 +1,379 @@
+package columnar
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"slices"
+)
+
+// Ids of the flatbuffer unions and enums of the Arrow format, see Schema.fbs
+// and Message.fbs in the Arrow repository
+const (
+	arrowV5 = 4
+
+	arrowTypeInt  = 2
+	arrowTypeUtf8 = 5
+
+	arrowHeaderSchema          = 1
+	arrowHeaderDictionaryBatch = 2
+	arrowHeaderRecordBatch     = 3
+)
+
+// arrowContinuation starts the encapsulated messages of a stream
+const arrowContinuation = 0xffffffff
+
+// arrowBody is the body of a message, its buffers are 8 bytes aligned
+type arrowBody struct {
+	b []byte
+	// buffers are the offset and length of each buffer, as Buffer structs
+	buffers []byte
+}
+
+func (b *arrowBody) add(data []byte) {
+	b.buffers = binary.LittleEndian.AppendUint64(b.buffers, uint64(len(b.b)))
+	b.buffers = binary.LittleEndian.AppendUint64(b.buffers, uint64(len(data)))
+	b.b = append(b.b, data...)
+	b.b = append(b.b, make([]byte, (8-len(b.b)%8)%8)...)
+}
+
+// addColumn adds a column without nulls: an empty validity bitmap and its
+// values
+func (b *arrowBody) addColumn(values []byte) {
+	b.add(nil)
+	b.add(values)
+}
+
+// fieldNodes returns the FieldNode structs of n columns without nulls of
+// length rows
+func fieldNodes(n, rows int) []byte {
+	var nodes []byte
+	for range n {
+		nodes = binary.LittleEndian.AppendUint64(nodes, uint64(rows))
+		nodes = binary.LittleEndian.AppendUint64(nodes, 0)
+	}
+	return nodes
+}
+
+// recordBatch builds a RecordBatch table
+func recordBatch(fb *fbBuilder, rows int, nodes []byte, body *arrowBody) int {
+	nodesPos := fb.createStructs(nodes, len(nodes)/16)
+	buffersPos := fb.createStructs(body.buffers, len(body.buffers)/16)
+	fb.startTable(3)
+	fb.addScalar(0, uint64(rows), 8)
+	fb.addOffset(1, nodesPos)
+	fb.addOffset(2, buffersPos)
+	return fb.endTable()
+}
+
+func arrowInt(fb *fbBuilder, bitWidth int) int {
+	fb.startTable(2)
+	fb.addScalar(0, uint64(bitWidth), 4)
+	fb.addBool(1, true)
+	return fb.endTable()
+}
+
+// arrowSchema builds the Schema of a Table: the names are dictionary encoded
+// with int32 indices and dictionary id 0
+func arrowSchema(fb *fbBuilder) int {
+	fields := make([]int, len(Columns))
+	for i, column := range Columns {
+		name := fb.createString(column)
+		children := fb.createOffsets(nil)
+		var typeType byte
+		var typ, dictionary int
+		switch column {
+		case "name":
+			typeType = arrowTypeUtf8
+			fb.startTable(0)
+			typ = fb.endTable()
+			indexType := arrowInt(fb, 32)
+			fb.startTable(4)
+			fb.addScalar(0, 0, 8)
+			fb.addOffset(1, indexType)
+			fb.addBool(2, false)
+			dictionary = fb.endTable()
+		case "min", "max":
+			typeType = arrowTypeInt
+			typ = arrowInt(fb, 16)
+		default:
+			typeType = arrowTypeInt
+			typ = arrowInt(fb, 64)
+		}
+
+		fb.startTable(7)
+		fb.addOffset(0, name)
+		fb.addBool(1, false)
+		fb.addScalar(2, uint64(typeType), 1)
+		fb.addOffset(3, typ)
+		if dictionary != 0 {
+			fb.addOffset(4, dictionary)
+		}
+		fb.addOffset(5, children)
+		fields[i] = fb.endTable()
+	}
+	fieldsPos := fb.createOffsets(fields)
+
+	key, value := fb.createString("unit"), fb.createString(Unit)
+	fb.startTable(2)
+	fb.addOffset(0, key)
+	fb.addOffset(1, value)
+	unit := fb.endTable()
+	metadata := fb.createOffsets([]int{unit})
+
+	fb.startTable(3)
+	fb.addScalar(0, 0, 2) // little endian
+	fb.addOffset(1, fieldsPos)
+	fb.addOffset(2, metadata)
+	return fb.endTable()
+}
+
+// writeArrowMessage writes an encapsulated message, header builds its header
+func writeArrowMessage(w io.Writer, headerType byte, header func(fb *fbBuilder) int, body []byte) error {
+	fb := &fbBuilder{}
+	headerPos := header(fb)
+	fb.startTable(4)
+	fb.addScalar(0, arrowV5, 2)
+	fb.addScalar(1, uint64(headerType), 1)
+	fb.addOffset(2, headerPos)
+	fb.addScalar(3, uint64(len(body)), 8)
+	meta := fb.finish(fb.endTable())
+
+	var prefix [8]byte
+	binary.LittleEndian.PutUint32(prefix[:], arrowContinuation)
+	binary.LittleEndian.PutUint32(prefix[4:], uint32(len(meta)))
+	for _, b := range [][]byte{prefix[:], meta, body} {
+		if _, err := w.Write(b); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// WriteArrow writes t as an Arrow IPC stream: the schema, a dictionary batch
+// with the names and a record batch with the columns of Columns.
+func WriteArrow(w io.Writer, t *Table) error {
+	if err := t.check(); err != nil {
+		return err
+	}
+	bw := bufio.NewWriter(w)
+	n := t.Len()
+
+	if err := writeArrowMessage(bw, arrowHeaderSchema, arrowSchema, nil); err != nil {
+		return err
+	}
+
+	// the names are distinct, the dictionary is the names in order
+	var dict arrowBody
+	offsets := make([]byte, 0, 4*(n+1))
+	var data []byte
+	offsets = binary.LittleEndian.AppendUint32(offsets, 0)
+	for _, name := range t.Names {
+		data = append(data, name...)
+		offsets = binary.LittleEndian.AppendUint32(offsets, uint32(len(data)))
+	}
+	dict.add(nil)
+	dict.add(offsets)
+	dict.add(data)
+	err := writeArrowMessage(bw, arrowHeaderDictionaryBatch, func(fb *fbBuilder) int {
+		batch := recordBatch(fb, n, fieldNodes(1, n), &dict)
+		fb.startTable(3)
+		fb.addScalar(0, 0, 8)
+		fb.addOffset(1, batch)
+		fb.addBool(2, false)
+		return fb.endTable()
+	}, dict.b)
+	if err != nil {
+		return err
+	}
+
+	var body arrowBody
+	indices := make([]byte, 0, 4*n)
+	mins, maxs := make([]byte, 0, 2*n), make([]byte, 0, 2*n)
+	sums, counts := make([]byte, 0, 8*n), make([]byte, 0, 8*n)
+	for i := range n {
+		indices = binary.LittleEndian.AppendUint32(indices, uint32(i))
+		mins = binary.LittleEndian.AppendUint16(mins, uint16(t.Min[i]))
+		maxs = binary.LittleEndian.AppendUint16(maxs, uint16(t.Max[i]))
+		sums = binary.LittleEndian.AppendUint64(sums, uint64(t.Sum[i]))
+		counts = binary.LittleEndian.AppendUint64(counts, uint64(t.Count[i]))
+	}
+	for _, values := range [][]byte{indices, mins, maxs, sums, counts} {
+		body.addColumn(values)
+	}
+	err = writeArrowMessage(bw, arrowHeaderRecordBatch, func(fb *fbBuilder) int {
+		return recordBatch(fb, n, fieldNodes(len(Columns), n), &body)
+	}, body.b)
+	if err != nil {
+		return err
+	}
+
+	// end of stream
+	var eos [8]byte
+	binary.LittleEndian.PutUint32(eos[:], arrowContinuation)
+	if _, err := bw.Write(eos[:]); err != nil {
+		return err
+	}
+	return bw.Flush()
+}
+
+// arrowMessage is a message read by readArrowMessage
+type arrowMessage struct {
+	headerType byte
+	header     fbTable
+	body       []byte
+}
+
+// readArrowMessage reads the next message, it returns io.EOF at the end of
+// the stream
+func readArrowMessage(r io.Reader) (*arrowMessage, error) {
+	var prefix [8]byte
+	if _, err := io.ReadFull(r, prefix[:]); err != nil {
+		if errors.Is(err, io.ErrUnexpectedEOF) {
+			return nil, fmt.Errorf("arrow: truncated stream")
+		}
+		return nil, err
+	}
+	if binary.LittleEndian.Uint32(prefix[:]) != arrowContinuation {
+		return nil, fmt.Errorf("arrow: missing continuation marker")
+	}
+	size := binary.LittleEndian.Uint32(prefix[4:])
+	if size == 0 {
+		return nil, io.EOF
+	}
+	if size > 1<<24 {
+		return nil, fmt.Errorf("arrow: message of %d bytes too large", size)
+	}
+	meta := make([]byte, size)
+	if _, err := io.ReadFull(r, meta); err != nil {
+		return nil, fmt.Errorf("arrow: %w", err)
+	}
+
+	msg, err := fbRoot(meta)
+	if err != nil {
+		return nil, fmt.Errorf("arrow: %w", err)
+	}
+	if v := msg.uint(0, 2); v != arrowV5 {
+		return nil, fmt.Errorf("arrow: unsupported metadata version %d", v)
+	}
+	m := &arrowMessage{headerType: byte(msg.uint(1, 1))}
+	if m.header, err = msg.table(2); err != nil {
+		return nil, fmt.Errorf("arrow: %w", err)
+	}
+	bodyLength := msg.int64(3)
+	if bodyLength < 0 || bodyLength > 1<<40 {
+		return nil, fmt.Errorf("arrow: invalid body length %d", bodyLength)
+	}
+	// the body isn't allocated up front, the length may be corrupted
+	if m.body, err = io.ReadAll(io.LimitReader(r, bodyLength)); err != nil {
+		return nil, fmt.Errorf("arrow: %w", err)
+	}
+	if int64(len(m.body)) != bodyLength {
+		return nil, fmt.Errorf("arrow: truncated stream")
+	}
+	return m, nil
+}
+
+// arrowBuffers returns the buffers of a RecordBatch, with the length of its
+// columns
+func arrowBuffers(batch fbTable, body []byte) ([][]byte, int, error) {
+	rows := int(batch.int64(0))
+	pos, n, err := batch.vector(2, 16)
+	if err != nil {
+		return nil, 0, fmt.Errorf("arrow: %w", err)
+	}
+	buffers := make([][]byte, n)
+	for i := range buffers {
+		offset := binary.LittleEndian.Uint64(batch.b[pos+16*i:])
+		length := binary.LittleEndian.Uint64(batch.b[pos+16*i+8:])
+		if offset > uint64(len(body)) || length > uint64(len(body))-offset {
+			return nil, 0, fmt.Errorf("arrow: buffer out of the body")
+		}
+		buffers[i] = body[offset : offset+length]
+	}
+	return buffers, rows, nil
+}
+
+// ReadArrow reads a stream written by WriteArrow
+func ReadArrow(r io.Reader) (*Table, error) {
+	br := bufio.NewReader(r)
+	schema, err := readArrowMessage(br)
+	if err != nil {
+		return nil, err
+	}
+	if schema.headerType != arrowHeaderSchema {
+		return nil, fmt.Errorf("arrow: stream doesn't start with a schema")
+	}
+	fields, err := schema.header.tables(1)
+	if err != nil {
+		return nil, fmt.Errorf("arrow: %w", err)
+	}
+	var names []string
+	for _, f := range fields {
+		names = append(names, f.string(0))
+	}
+	if !slices.Equal(names, Columns) {
+		return nil, fmt.Errorf("arrow: unexpected columns %v", names)
+	}
+
+	t := &Table{}
+	var dictionary []string
+	for {
+		m, err := readArrowMessage(br)
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		switch m.headerType {
+		case arrowHeaderDictionaryBatch:
+			batch, err := m.header.table(1)
+			if err != nil {
+				return nil, fmt.Errorf("arrow: %w", err)
+			}
+			buffers, rows, err := arrowBuffers(batch, m.body)
+			if err != nil {
+				return nil, err
+			}
+			if len(buffers) != 3 || len(buffers[1]) < 4*(rows+1) {
+				return nil, fmt.Errorf("arrow: invalid dictionary")
+			}
+			dictionary = dictionary[:0]
+			for i := range rows {
+				start := binary.LittleEndian.Uint32(buffers[1][4*i:])
+				end := binary.LittleEndian.Uint32(buffers[1][4*i+4:])
+				if start > end || int(end) > len(buffers[2]) {
+					return nil, fmt.Errorf("arrow: invalid dictionary offsets")
+				}
+				dictionary = append(dictionary, string(buffers[2][start:end]))
+			}
+		case arrowHeaderRecordBatch:
+			buffers, rows, err := arrowBuffers(m.header, m.body)
+			if err != nil {
+				return nil, err
+			}
+			if len(buffers) != 2*len(Columns) || len(buffers[1]) < 4*rows || len(buffers[3]) < 2*rows ||
+				len(buffers[5]) < 2*rows || len(buffers[7]) < 8*rows || len(buffers[9]) < 8*rows {
+				return nil, fmt.Errorf("arrow: invalid record batch")
+			}
+			for i := range rows {
+				index := binary.LittleEndian.Uint32(buffers[1][4*i:])
+				if int(index) >= len(dictionary) {
+					return nil, fmt.Errorf("arrow: name index %d out of the dictionary", index)
+				}
+				t.Names = append(t.Names, dictionary[index])
+				t.Min = append(t.Min, int16(binary.LittleEndian.Uint16(buffers[3][2*i:])))
+				t.Max = append(t.Max, int16(binary.LittleEndian.Uint16(buffers[5][2*i:])))
+				t.Sum = append(t.Sum, int64(binary.LittleEndian.Uint64(buffers[7][8*i:])))
+				t.Count = append(t.Count, int64(binary.LittleEndian.Uint64(buffers[9][8*i:])))
+			}
+		default:
+			return nil, fmt.Errorf("arrow: unexpected message type %d", m.headerType)
+		}
+	}
+	return t, nil
+}
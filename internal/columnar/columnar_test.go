@@ -0,0 +1,119 @@
+package columnar
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"1brc/internal/agg"
+	"1brc/internal/format"
+)
+
+func testTable(n int) *Table {
+	t := &Table{}
+	for i := range n {
+		t.Names = append(t.Names, fmt.Sprintf("Station %d é", i))
+		t.Min = append(t.Min, int16(-999+i))
+		t.Max = append(t.Max, int16(999-i))
+		t.Sum = append(t.Sum, int64(i-n/2)*1_000_000_007)
+		t.Count = append(t.Count, int64(i+1)*3_000_000_000)
+	}
+	return t
+}
+
+func TestRoundTrip(t *testing.T) {
+	// 413 stations need 9 bits indices and long lists
+	for _, n := range []int{0, 1, 2, 9, 413} {
+		table := testTable(n)
+
+		var b bytes.Buffer
+		require.NoError(t, WriteArrow(&b, table))
+		got, err := ReadArrow(&b)
+		require.NoError(t, err)
+		assert.Equal(t, table.Len(), got.Len())
+		if n > 0 {
+			assert.Equal(t, table, got, "arrow %d", n)
+		}
+
+		b.Reset()
+		require.NoError(t, WriteParquet(&b, table))
+		got, err = ReadParquet(bytes.NewReader(b.Bytes()), int64(b.Len()))
+		require.NoError(t, err)
+		assert.Equal(t, table.Len(), got.Len())
+		if n > 0 {
+			assert.Equal(t, table, got, "parquet %d", n)
+		}
+	}
+}
+
+func TestFromResults(t *testing.T) {
+	results := agg.NewResults()
+	for _, m := range []int16{-15, 99, 15} {
+		results.Merge("Hamburg", agg.NewStation(m))
+	}
+	results.Merge("Abha", agg.NewStation(-1))
+	table := FromResults(results, []string{"Abha", "Hamburg"})
+	assert.Equal(t, &Table{
+		Names: []string{"Abha", "Hamburg"},
+		Min:   []int16{-1, -15},
+		Max:   []int16{-1, 99},
+		Sum:   []int64{-1, 99},
+		Count: []int64{1, 3},
+	}, table)
+
+	var b strings.Builder
+	require.NoError(t, table.Encode(&b, format.RoundHalfUp))
+	assert.Equal(t, "{Abha=-0.1/-0.1/-0.1, Hamburg=-1.5/3.3/9.9}", b.String())
+}
+
+func TestInvalid(t *testing.T) {
+	table := testTable(3)
+	table.Min = table.Min[:2]
+	assert.Error(t, WriteArrow(&bytes.Buffer{}, table))
+	assert.Error(t, WriteParquet(&bytes.Buffer{}, table))
+
+	var arrow, parquet bytes.Buffer
+	require.NoError(t, WriteArrow(&arrow, testTable(20)))
+	require.NoError(t, WriteParquet(&parquet, testTable(20)))
+	// truncated or corrupted files fail, without panics
+	for _, n := range []int{0, 7, 100, arrow.Len() / 2, arrow.Len() - 9} {
+		_, err := ReadArrow(bytes.NewReader(arrow.Bytes()[:n]))
+		assert.Error(t, err, "arrow truncated to %d", n)
+	}
+	for _, n := range []int{0, 7, 100, parquet.Len() / 2, parquet.Len() - 1} {
+		_, err := ReadParquet(bytes.NewReader(parquet.Bytes()[:n]), int64(n))
+		assert.Error(t, err, "parquet truncated to %d", n)
+	}
+	for i := range arrow.Len() {
+		b := bytes.Clone(arrow.Bytes())
+		b[i] ^= 0xa5
+		ReadArrow(bytes.NewReader(b))
+	}
+	for i := range parquet.Len() {
+		b := bytes.Clone(parquet.Bytes())
+		b[i] ^= 0xa5
+		ReadParquet(bytes.NewReader(b), int64(len(b)))
+	}
+}
+
+func TestUnpackHybrid(t *testing.T) {
+	values := []uint32{5, 0, 7, 1, 3, 3, 2, 6, 4, 1}
+	b := bitPacked(values)
+	assert.Equal(t, byte(3), b[0])
+	got, err := unpackHybrid(b[1:], int(b[0]), len(values))
+	require.NoError(t, err)
+	assert.Equal(t, values, got)
+
+	// a RLE run of 4 times 300 then a bit-packed run
+	b = []byte{4 << 1, 44, 1, 1<<1 | 1, 0xff, 0x01, 0, 0, 0, 0, 0, 0, 0, 0}
+	got, err = unpackHybrid(b, 9, 5)
+	require.NoError(t, err)
+	assert.Equal(t, []uint32{300, 300, 300, 300, 511}, got)
+
+	_, err = unpackHybrid(b[:5], 9, 5)
+	assert.Error(t, err)
+}
@@ -0,0 +1,241 @@
+package columnar
+
+import (
+	"encoding/binary"
+	"fmt"
+)
+
+// fbBuilder builds a flatbuffer back to front like the flatbuffers library:
+// objects are prepended, so the references of an object to the ones created
+// before point forward as required. Positions are counted from the end of the
+// buffer and everything is aligned on them, finish pads the buffer to 8 bytes
+// so they are aligned from the start too.
+type fbBuilder struct {
+	b []byte
+	// fields of the table being built: position by slot, 0 when absent
+	fields []int
+	// tableEnd is the position where the table being built starts
+	tableEnd int
+}
+
+func (fb *fbBuilder) pos() int {
+	return len(fb.b)
+}
+
+func (fb *fbBuilder) prepend(p []byte) {
+	fb.b = append(append(make([]byte, 0, len(p)+len(fb.b)), p...), fb.b...)
+}
+
+// align pads so that size bytes prepended next end aligned on alignment
+func (fb *fbBuilder) align(alignment, size int) {
+	if pad := (alignment - (fb.pos()+size)%alignment) % alignment; pad > 0 {
+		fb.prepend(make([]byte, pad))
+	}
+}
+
+func (fb *fbBuilder) scalar(v uint64, size int) {
+	fb.align(size, size)
+	var p [8]byte
+	binary.LittleEndian.PutUint64(p[:], v)
+	fb.prepend(p[:size])
+}
+
+// uoffset prepends a reference to the object at position target
+func (fb *fbBuilder) uoffset(target int) {
+	fb.align(4, 4)
+	fb.scalar(uint64(fb.pos()+4-target), 4)
+}
+
+func (fb *fbBuilder) createString(s string) int {
+	fb.align(4, len(s)+1)
+	fb.prepend(append([]byte(s), 0))
+	fb.scalar(uint64(len(s)), 4)
+	return fb.pos()
+}
+
+// createOffsets creates a vector of references to tables or strings
+func (fb *fbBuilder) createOffsets(targets []int) int {
+	fb.align(4, 4*len(targets))
+	for i := len(targets) - 1; i >= 0; i-- {
+		fb.uoffset(targets[i])
+	}
+	fb.scalar(uint64(len(targets)), 4)
+	return fb.pos()
+}
+
+// createStructs creates a vector of n structs, aligned on 8 bytes
+func (fb *fbBuilder) createStructs(data []byte, n int) int {
+	fb.align(8, len(data))
+	fb.align(4, len(data)+4)
+	fb.prepend(data)
+	fb.scalar(uint64(n), 4)
+	return fb.pos()
+}
+
+func (fb *fbBuilder) startTable(nfields int) {
+	fb.fields = make([]int, nfields)
+	fb.tableEnd = fb.pos()
+}
+
+func (fb *fbBuilder) addScalar(slot int, v uint64, size int) {
+	fb.scalar(v, size)
+	fb.fields[slot] = fb.pos()
+}
+
+func (fb *fbBuilder) addBool(slot int, v bool) {
+	var b uint64
+	if v {
+		b = 1
+	}
+	fb.addScalar(slot, b, 1)
+}
+
+func (fb *fbBuilder) addOffset(slot int, target int) {
+	fb.uoffset(target)
+	fb.fields[slot] = fb.pos()
+}
+
+// endTable writes the table and its vtable, it returns the position of the
+// table
+func (fb *fbBuilder) endTable() int {
+	fb.align(4, 4)
+	fb.prepend(make([]byte, 4))
+	table := fb.pos()
+
+	vtable := make([]byte, 4+2*len(fb.fields))
+	binary.LittleEndian.PutUint16(vtable, uint16(len(vtable)))
+	binary.LittleEndian.PutUint16(vtable[2:], uint16(table-fb.tableEnd))
+	for slot, pos := range fb.fields {
+		if pos != 0 {
+			binary.LittleEndian.PutUint16(vtable[4+2*slot:], uint16(table-pos))
+		}
+	}
+	fb.prepend(vtable)
+	binary.LittleEndian.PutUint32(fb.b[fb.pos()-table:], uint32(fb.pos()-table))
+	return table
+}
+
+// finish writes the reference to the root table and returns the buffer,
+// padded to 8 bytes
+func (fb *fbBuilder) finish(root int) []byte {
+	fb.align(8, 4)
+	fb.uoffset(root)
+	return fb.b
+}
+
+// fbTable reads a table of a flatbuffer
+type fbTable struct {
+	b   []byte
+	pos int
+}
+
+// fbRoot returns the root table of b
+func fbRoot(b []byte) (fbTable, error) {
+	if len(b) < 4 {
+		return fbTable{}, fmt.Errorf("flatbuffer too short")
+	}
+	t := fbTable{b: b, pos: int(binary.LittleEndian.Uint32(b))}
+	return t, t.check()
+}
+
+func (t fbTable) check() error {
+	if t.pos < 0 || t.pos+4 > len(t.b) {
+		return fmt.Errorf("flatbuffer table out of bounds")
+	}
+	vtable := t.pos - int(int32(binary.LittleEndian.Uint32(t.b[t.pos:])))
+	if vtable < 0 || vtable+4 > len(t.b) || vtable+int(binary.LittleEndian.Uint16(t.b[vtable:])) > len(t.b) {
+		return fmt.Errorf("flatbuffer vtable out of bounds")
+	}
+	return nil
+}
+
+// field returns the position of the field in slot, 0 when absent
+func (t fbTable) field(slot int) int {
+	vtable := t.pos - int(int32(binary.LittleEndian.Uint32(t.b[t.pos:])))
+	if 4+2*slot >= int(binary.LittleEndian.Uint16(t.b[vtable:])) {
+		return 0
+	}
+	off := int(binary.LittleEndian.Uint16(t.b[vtable+4+2*slot:]))
+	if off == 0 {
+		return 0
+	}
+	return t.pos + off
+}
+
+func (t fbTable) uint(slot int, size int) uint64 {
+	pos := t.field(slot)
+	if pos == 0 || pos+size > len(t.b) {
+		return 0
+	}
+	var p [8]byte
+	copy(p[:], t.b[pos:pos+size])
+	return binary.LittleEndian.Uint64(p[:])
+}
+
+func (t fbTable) int64(slot int) int64 {
+	return int64(t.uint(slot, 8))
+}
+
+// indirect follows the reference in slot, it returns -1 when absent
+func (t fbTable) indirect(slot int) int {
+	pos := t.field(slot)
+	if pos == 0 || pos+4 > len(t.b) {
+		return -1
+	}
+	return pos + int(binary.LittleEndian.Uint32(t.b[pos:]))
+}
+
+func (t fbTable) table(slot int) (fbTable, error) {
+	pos := t.indirect(slot)
+	if pos < 0 {
+		return fbTable{}, fmt.Errorf("flatbuffer: missing table in slot %d", slot)
+	}
+	sub := fbTable{b: t.b, pos: pos}
+	return sub, sub.check()
+}
+
+func (t fbTable) string(slot int) string {
+	pos := t.indirect(slot)
+	if pos < 0 || pos+4 > len(t.b) {
+		return ""
+	}
+	n := int(binary.LittleEndian.Uint32(t.b[pos:]))
+	if pos+4+n > len(t.b) {
+		return ""
+	}
+	return string(t.b[pos+4 : pos+4+n])
+}
+
+// vector returns the position of the elements of the vector in slot and their
+// count, elements of size bytes must fit in the buffer
+func (t fbTable) vector(slot int, size int) (int, int, error) {
+	pos := t.indirect(slot)
+	if pos < 0 {
+		return 0, 0, nil
+	}
+	if pos+4 > len(t.b) {
+		return 0, 0, fmt.Errorf("flatbuffer vector out of bounds")
+	}
+	n := int(binary.LittleEndian.Uint32(t.b[pos:]))
+	if n < 0 || pos+4+n*size > len(t.b) {
+		return 0, 0, fmt.Errorf("flatbuffer vector out of bounds")
+	}
+	return pos + 4, n, nil
+}
+
+// tables returns the tables of the vector in slot
+func (t fbTable) tables(slot int) ([]fbTable, error) {
+	pos, n, err := t.vector(slot, 4)
+	if err != nil {
+		return nil, err
+	}
+	tables := make([]fbTable, n)
+	for i := range tables {
+		elem := pos + 4*i
+		tables[i] = fbTable{b: t.b, pos: elem + int(binary.LittleEndian.Uint32(t.b[elem:]))}
+		if err := tables[i].check(); err != nil {
+			return nil, err
+		}
+	}
+	return tables, nil
+}
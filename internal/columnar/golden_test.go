@@ -0,0 +1,219 @@
+package columnar
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"os"
+	"slices"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// goldenTable is the table of the golden files in testdata, written by
+// arrow-go with testdata/gen
+func goldenTable() *Table {
+	return &Table{
+		Names: []string{"Abha", "Hamburg", "Las Palmas de Gran Canaria", "Zürich", "Ouagadougou"},
+		Min:   []int16{-999, -153, 0, -1, 999},
+		Max:   []int16{999, 420, 0, -1, 999},
+		Sum:   []int64{-9_000_000_000_001, 12_345, 0, -3_000_000_000, 999 * 3_000_000_000},
+		Count: []int64{1, 42, 7, 3_000_000_000, 3_000_000_000},
+	}
+}
+
+func TestReadGolden(t *testing.T) {
+	b, err := os.ReadFile("testdata/stations.arrow")
+	require.NoError(t, err)
+	got, err := ReadArrow(bytes.NewReader(b))
+	require.NoError(t, err)
+	assert.Equal(t, goldenTable(), got)
+
+	b, err = os.ReadFile("testdata/stations.parquet")
+	require.NoError(t, err)
+	got, err = ReadParquet(bytes.NewReader(b), int64(len(b)))
+	require.NoError(t, err)
+	assert.Equal(t, goldenTable(), got)
+}
+
+// describeArrow returns what readers of an Arrow stream see of b: the schema,
+// the batches and their buffers, but not how the flatbuffers are laid out
+func describeArrow(t *testing.T, b []byte) []any {
+	var messages []any
+	r := bytes.NewReader(b)
+	for {
+		m, err := readArrowMessage(r)
+		if errors.Is(err, io.EOF) {
+			return messages
+		}
+		require.NoError(t, err)
+		switch m.headerType {
+		case arrowHeaderSchema:
+			fields, err := m.header.tables(1)
+			require.NoError(t, err)
+			var described []any
+			for _, f := range fields {
+				field := map[string]any{
+					"name":     f.string(0),
+					"nullable": f.uint(1, 1),
+					"type":     f.uint(2, 1),
+				}
+				if typ, err := f.table(3); err == nil {
+					field["int"] = []uint64{typ.uint(0, 4), typ.uint(1, 1)}
+				}
+				if dict, err := f.table(4); err == nil {
+					index, err := dict.table(1)
+					require.NoError(t, err)
+					field["dictionary"] = []uint64{dict.uint(0, 8), index.uint(0, 4), index.uint(1, 1), dict.uint(2, 1)}
+				}
+				described = append(described, field)
+			}
+			metadata := map[string]string{}
+			kvs, err := m.header.tables(2)
+			require.NoError(t, err)
+			for _, kv := range kvs {
+				metadata[kv.string(0)] = kv.string(1)
+			}
+			messages = append(messages, map[string]any{"schema": described, "endianness": m.header.uint(0, 2), "metadata": metadata})
+		case arrowHeaderDictionaryBatch:
+			batch, err := m.header.table(1)
+			require.NoError(t, err)
+			messages = append(messages, map[string]any{
+				"dictionary": m.header.int64(0),
+				"delta":      m.header.uint(2, 1),
+				"batch":      describeBatch(t, batch, m.body),
+			})
+		case arrowHeaderRecordBatch:
+			messages = append(messages, map[string]any{"record": describeBatch(t, m.header, m.body)})
+		default:
+			t.Fatalf("unexpected message type %d", m.headerType)
+		}
+	}
+}
+
+func describeBatch(t *testing.T, batch fbTable, body []byte) map[string]any {
+	pos, n, err := batch.vector(1, 16)
+	require.NoError(t, err)
+	var nodes [][2]int64
+	for i := range n {
+		node := batch.b[pos+16*i:]
+		nodes = append(nodes, [2]int64{int64(binary.LittleEndian.Uint64(node)), int64(binary.LittleEndian.Uint64(node[8:]))})
+	}
+	buffers, rows, err := arrowBuffers(batch, body)
+	require.NoError(t, err)
+	return map[string]any{"rows": rows, "nodes": nodes, "buffers": buffers}
+}
+
+func TestArrowLikeReference(t *testing.T) {
+	golden, err := os.ReadFile("testdata/stations.arrow")
+	require.NoError(t, err)
+	var b bytes.Buffer
+	require.NoError(t, WriteArrow(&b, goldenTable()))
+	assert.Equal(t, describeArrow(t, golden), describeArrow(t, b.Bytes()))
+}
+
+// parquetFile is what readers of a Parquet file see of it: the metadata and
+// the pages of each column chunk, with their header
+type parquetFile struct {
+	meta  thriftFields
+	pages [][]parquetPage
+}
+
+type parquetPage struct {
+	header thriftFields
+	data   []byte
+}
+
+func readParquetFile(t *testing.T, b []byte) parquetFile {
+	require.Equal(t, parquetMagic, string(b[:4]))
+	require.Equal(t, parquetMagic, string(b[len(b)-4:]))
+	n := int(binary.LittleEndian.Uint32(b[len(b)-8:]))
+	meta, err := readThrift(bufio.NewReader(bytes.NewReader(b[len(b)-8-n : len(b)-8])))
+	require.NoError(t, err)
+
+	f := parquetFile{meta: meta}
+	for _, rg := range meta.structs(4) {
+		for _, chunk := range rg.structs(1) {
+			cm := chunk.strct(3)
+			start := cm.int(9)
+			if _, ok := cm[11]; ok {
+				start = cm.int(11)
+			}
+			r := bufio.NewReader(bytes.NewReader(b[start : start+cm.int(7)]))
+			var pages []parquetPage
+			for {
+				h, err := readThrift(r)
+				if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+					break
+				}
+				require.NoError(t, err)
+				data := make([]byte, h.int(3))
+				_, err = io.ReadFull(r, data)
+				require.NoError(t, err)
+				pages = append(pages, parquetPage{h, data})
+			}
+			f.pages = append(f.pages, pages)
+		}
+	}
+	return f
+}
+
+// pick returns the fields ids of s
+func pick(s thriftFields, ids ...int16) thriftFields {
+	picked := thriftFields{}
+	for _, id := range ids {
+		if v, ok := s[id]; ok {
+			picked[id] = v
+		}
+	}
+	return picked
+}
+
+func TestParquetLikeReference(t *testing.T) {
+	b, err := os.ReadFile("testdata/stations.parquet")
+	require.NoError(t, err)
+	golden := readParquetFile(t, b)
+	var buf bytes.Buffer
+	require.NoError(t, WriteParquet(&buf, goldenTable()))
+	ours := readParquetFile(t, buf.Bytes())
+
+	// footer: the schema, the rows, the metadata and the column orders
+	for _, id := range []int16{3, 5, 7} {
+		assert.Equal(t, golden.meta[id], ours.meta[id], "file metadata field %d", id)
+	}
+	gschema, oschema := golden.meta.structs(2), ours.meta.structs(2)
+	require.Len(t, oschema, len(gschema))
+	assert.Equal(t, pick(gschema[0], 4, 5), pick(oschema[0], 4, 5), "schema root")
+	assert.Equal(t, gschema[1:], oschema[1:])
+
+	// column chunks: the types, the encodings used and where they start
+	gchunks, ochunks := golden.meta.structs(4)[0].structs(1), ours.meta.structs(4)[0].structs(1)
+	require.Len(t, ochunks, len(gchunks))
+	for i := range gchunks {
+		g, o := gchunks[i].strct(3), ochunks[i].strct(3)
+		assert.Equal(t, pick(g, 1, 3, 4, 5), pick(o, 1, 3, 4, 5), "column %d", i)
+		gencodings, _ := g[2].([]any)
+		oencodings, _ := o[2].([]any)
+		assert.ElementsMatch(t, gencodings, oencodings, "encodings of column %d", i)
+		_, gdict := g[11]
+		_, odict := o[11]
+		assert.Equal(t, gdict, odict, "dictionary page of column %d", i)
+	}
+
+	// pages: the dictionary page, the data pages and their bytes
+	require.Len(t, ours.pages, len(golden.pages))
+	for i := range golden.pages {
+		require.Len(t, ours.pages[i], len(golden.pages[i]), "pages of column %d", i)
+		for j, g := range golden.pages[i] {
+			o := ours.pages[i][j]
+			assert.Equal(t, pick(g.header, 1, 2, 3), pick(o.header, 1, 2, 3), "page %d of column %d", j, i)
+			assert.Equal(t, pick(g.header.strct(7), 1, 2), pick(o.header.strct(7), 1, 2), "dictionary page header of column %d", i)
+			assert.Equal(t, pick(g.header.strct(5), 1, 2, 3, 4), pick(o.header.strct(5), 1, 2, 3, 4), "data page header %d of column %d", j, i)
+			assert.True(t, slices.Equal(g.data, o.data), "page %d of column %d", j, i)
+		}
+	}
+}
@@ -0,0 +1,440 @@
+package columnar
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math/bits"
+	"slices"
+)
+
+// Enums of the Parquet format, see parquet.thrift in the parquet-format
+// repository
+const (
+	parquetInt32     = 1
+	parquetInt64     = 2
+	parquetByteArray = 6
+
+	parquetRequired = 0
+
+	parquetConvertedUTF8  = 0
+	parquetConvertedInt16 = 16
+	parquetConvertedInt64 = 18
+
+	parquetPlain         = 0
+	parquetRLE           = 3
+	parquetRLEDictionary = 8
+
+	parquetDataPage       = 0
+	parquetDictionaryPage = 2
+)
+
+const parquetMagic = "PAR1"
+
+// parquetColumn is a column chunk being written
+type parquetColumn struct {
+	typ       int32
+	encodings []int32
+	// dictionaryOffset is the offset of the dictionary page, 0 without one
+	dictionaryOffset int64
+	dataOffset       int64
+	size             int64
+}
+
+// writePage appends a page with its header to b
+func writePage(b []byte, typ int32, numValues int, encoding int32, data []byte) []byte {
+	h := &thriftWriter{}
+	h.begin(0)
+	h.i32(1, typ)
+	h.i32(2, int32(len(data)))
+	h.i32(3, int32(len(data)))
+	if typ == parquetDictionaryPage {
+		h.begin(7)
+		h.i32(1, int32(numValues))
+		h.i32(2, encoding)
+		h.end()
+	} else {
+		h.begin(5)
+		h.i32(1, int32(numValues))
+		h.i32(2, encoding)
+		h.i32(3, parquetRLE)
+		h.i32(4, parquetRLE)
+		h.end()
+	}
+	h.end()
+	return append(append(b, h.b...), data...)
+}
+
+// bitPacked encodes values as a single bit-packed run of the RLE/bit-packing
+// hybrid encoding, preceded by the bit width like the data pages of
+// RLE_DICTIONARY
+func bitPacked(values []uint32) []byte {
+	width := 1
+	if len(values) > 0 {
+		width = max(bits.Len32(slices.Max(values)), 1)
+	}
+	groups := (len(values) + 7) / 8
+	b := binary.AppendUvarint([]byte{byte(width)}, uint64(groups)<<1|1)
+	packed := make([]byte, groups*width)
+	for i, v := range values {
+		for bit := range width {
+			if v>>bit&1 != 0 {
+				n := i*width + bit
+				packed[n/8] |= 1 << (n % 8)
+			}
+		}
+	}
+	return append(b, packed...)
+}
+
+// unpackHybrid decodes n values of the RLE/bit-packing hybrid encoding
+func unpackHybrid(b []byte, width, n int) ([]uint32, error) {
+	if width > 32 {
+		return nil, fmt.Errorf("parquet: bit width %d too large", width)
+	}
+	values := make([]uint32, 0, n)
+	for len(values) < n {
+		header, size := binary.Uvarint(b)
+		if size <= 0 {
+			return nil, fmt.Errorf("parquet: truncated indices")
+		}
+		b = b[size:]
+		if header&1 == 0 {
+			// RLE run: the value in the bytes of width
+			count := int(min(header>>1, uint64(n-len(values))))
+			nbytes := (width + 7) / 8
+			if len(b) < nbytes {
+				return nil, fmt.Errorf("parquet: truncated indices")
+			}
+			var p [4]byte
+			copy(p[:], b[:nbytes])
+			b = b[nbytes:]
+			for range count {
+				values = append(values, binary.LittleEndian.Uint32(p[:]))
+			}
+			continue
+		}
+		groups := header >> 1
+		if groups > uint64(len(b)) || int(groups)*width > len(b) {
+			return nil, fmt.Errorf("parquet: truncated indices")
+		}
+		for i := range int(groups) * 8 {
+			var v uint32
+			for bit := range width {
+				n := i*width + bit
+				v |= uint32(b[n/8]>>(n%8)&1) << bit
+			}
+			if len(values) < n {
+				values = append(values, v)
+			}
+		}
+		b = b[int(groups)*width:]
+	}
+	return values, nil
+}
+
+// WriteParquet writes t as a Parquet file with a row group, without
+// compression. The names are dictionary encoded, min and max are int16 and
+// sum and count int64.
+func WriteParquet(w io.Writer, t *Table) error {
+	if err := t.check(); err != nil {
+		return err
+	}
+	n := t.Len()
+	b := []byte(parquetMagic)
+
+	var columns []parquetColumn
+	if n > 0 {
+		// the names are distinct, the dictionary is the names in order
+		var dict []byte
+		indices := make([]uint32, n)
+		for i, name := range t.Names {
+			dict = binary.LittleEndian.AppendUint32(dict, uint32(len(name)))
+			dict = append(dict, name...)
+			indices[i] = uint32(i)
+		}
+		names := parquetColumn{
+			typ:              parquetByteArray,
+			encodings:        []int32{parquetPlain, parquetRLE, parquetRLEDictionary},
+			dictionaryOffset: int64(len(b)),
+		}
+		b = writePage(b, parquetDictionaryPage, n, parquetPlain, dict)
+		names.dataOffset = int64(len(b))
+		b = writePage(b, parquetDataPage, n, parquetRLEDictionary, bitPacked(indices))
+		names.size = int64(len(b)) - names.dictionaryOffset
+		columns = append(columns, names)
+
+		var mins, maxs, sums, counts []byte
+		for i := range n {
+			mins = binary.LittleEndian.AppendUint32(mins, uint32(t.Min[i]))
+			maxs = binary.LittleEndian.AppendUint32(maxs, uint32(t.Max[i]))
+			sums = binary.LittleEndian.AppendUint64(sums, uint64(t.Sum[i]))
+			counts = binary.LittleEndian.AppendUint64(counts, uint64(t.Count[i]))
+		}
+		for i, values := range [][]byte{mins, maxs, sums, counts} {
+			c := parquetColumn{typ: parquetInt32, encodings: []int32{parquetPlain, parquetRLE}, dataOffset: int64(len(b))}
+			if i >= 2 {
+				c.typ = parquetInt64
+			}
+			b = writePage(b, parquetDataPage, n, parquetPlain, values)
+			c.size = int64(len(b)) - c.dataOffset
+			columns = append(columns, c)
+		}
+	}
+
+	meta := &thriftWriter{}
+	meta.begin(0)
+	meta.i32(1, 1)
+	meta.list(2, thriftStruct, len(Columns)+1)
+	meta.begin(0)
+	meta.string(4, "schema")
+	meta.i32(5, int32(len(Columns)))
+	meta.end()
+	for _, column := range Columns {
+		meta.begin(0)
+		switch column {
+		case "name":
+			meta.i32(1, parquetByteArray)
+		case "min", "max":
+			meta.i32(1, parquetInt32)
+		default:
+			meta.i32(1, parquetInt64)
+		}
+		meta.i32(3, parquetRequired)
+		meta.string(4, column)
+		switch column {
+		case "name":
+			meta.i32(6, parquetConvertedUTF8)
+			meta.begin(10)
+			meta.begin(1)
+			meta.end()
+			meta.end()
+		case "min", "max":
+			meta.i32(6, parquetConvertedInt16)
+			meta.begin(10)
+			meta.begin(10)
+			meta.byte(1, 16)
+			meta.bool(2, true)
+			meta.end()
+			meta.end()
+		default:
+			meta.i32(6, parquetConvertedInt64)
+			meta.begin(10)
+			meta.begin(10)
+			meta.byte(1, 64)
+			meta.bool(2, true)
+			meta.end()
+			meta.end()
+		}
+		meta.end()
+	}
+	meta.i64(3, int64(n))
+
+	var rowGroups int
+	if n > 0 {
+		rowGroups = 1
+	}
+	meta.list(4, thriftStruct, rowGroups)
+	if n > 0 {
+		var total int64
+		meta.begin(0)
+		meta.list(1, thriftStruct, len(columns))
+		for i, c := range columns {
+			total += c.size
+			meta.begin(0)
+			meta.i64(2, c.dataOffset)
+			meta.begin(3)
+			meta.i32(1, c.typ)
+			meta.list(2, thriftI32, len(c.encodings))
+			for _, e := range c.encodings {
+				meta.zigzag(int64(e))
+			}
+			meta.list(3, thriftBinary, 1)
+			meta.varint(uint64(len(Columns[i])))
+			meta.b = append(meta.b, Columns[i]...)
+			meta.i32(4, 0) // uncompressed
+			meta.i64(5, int64(n))
+			meta.i64(6, c.size)
+			meta.i64(7, c.size)
+			meta.i64(9, c.dataOffset)
+			if c.dictionaryOffset != 0 {
+				meta.i64(11, c.dictionaryOffset)
+			}
+			meta.end()
+			meta.end()
+		}
+		meta.i64(2, total)
+		meta.i64(3, int64(n))
+		meta.end()
+	}
+
+	meta.list(5, thriftStruct, 1)
+	meta.begin(0)
+	meta.string(1, "unit")
+	meta.string(2, Unit)
+	meta.end()
+	meta.string(6, "1brc fastbrc")
+	// TYPE_ORDER: the min and max statistics compare signed values
+	meta.list(7, thriftStruct, len(Columns))
+	for range Columns {
+		meta.begin(0)
+		meta.begin(1)
+		meta.end()
+		meta.end()
+	}
+	meta.end()
+
+	b = append(b, meta.b...)
+	b = binary.LittleEndian.AppendUint32(b, uint32(len(meta.b)))
+	b = append(b, parquetMagic...)
+	_, err := w.Write(b)
+	return err
+}
+
+// ReadParquet reads a file of size bytes written by WriteParquet
+func ReadParquet(r io.ReaderAt, size int64) (*Table, error) {
+	var footer [8]byte
+	if size < int64(len(parquetMagic)+len(footer)) {
+		return nil, fmt.Errorf("parquet: file too short")
+	}
+	if _, err := r.ReadAt(footer[:], size-8); err != nil {
+		return nil, fmt.Errorf("parquet: %w", err)
+	}
+	if string(footer[4:]) != parquetMagic {
+		return nil, fmt.Errorf("parquet: missing magic number")
+	}
+	metaSize := int64(binary.LittleEndian.Uint32(footer[:]))
+	if metaSize > size-8-int64(len(parquetMagic)) {
+		return nil, fmt.Errorf("parquet: metadata of %d bytes out of the file", metaSize)
+	}
+	meta, err := readThrift(bufio.NewReader(io.NewSectionReader(r, size-8-metaSize, metaSize)))
+	if err != nil {
+		return nil, fmt.Errorf("parquet: %w", err)
+	}
+
+	var names []string
+	for _, s := range meta.structs(2)[min(1, len(meta.structs(2))):] {
+		names = append(names, s.string(4))
+	}
+	if !slices.Equal(names, Columns) {
+		return nil, fmt.Errorf("parquet: unexpected columns %v", names)
+	}
+
+	t := &Table{}
+	for _, rg := range meta.structs(4) {
+		chunks := rg.structs(1)
+		if len(chunks) != len(Columns) {
+			return nil, fmt.Errorf("parquet: %d column chunks in a row group", len(chunks))
+		}
+		rows := rg.int(3)
+		var columns []*columnChunk
+		for _, chunk := range chunks {
+			cm := chunk.strct(3)
+			if cm.int(4) != 0 {
+				return nil, fmt.Errorf("parquet: unsupported codec %d", cm.int(4))
+			}
+			if cm.int(5) != rows {
+				return nil, fmt.Errorf("parquet: %d values in a row group of %d rows", cm.int(5), rows)
+			}
+			offset := cm.int(9)
+			if _, ok := cm[11]; ok {
+				offset = cm.int(11)
+			}
+			if offset < 0 || offset > size {
+				return nil, fmt.Errorf("parquet: column chunk out of the file")
+			}
+			c, err := readColumnChunk(bufio.NewReader(io.NewSectionReader(r, offset, size-offset)), int(rows))
+			if err != nil {
+				return nil, err
+			}
+			columns = append(columns, c)
+		}
+
+		names, values := columns[0], columns[1:]
+		if len(names.values) < 4*int(rows) || len(values[0].values) < 4*int(rows) || len(values[1].values) < 4*int(rows) ||
+			len(values[2].values) < 8*int(rows) || len(values[3].values) < 8*int(rows) {
+			return nil, fmt.Errorf("parquet: truncated column")
+		}
+		for i := range int(rows) {
+			index := binary.LittleEndian.Uint32(names.values[4*i:])
+			if int(index) >= len(names.dict) {
+				return nil, fmt.Errorf("parquet: name index %d out of the dictionary", index)
+			}
+			t.Names = append(t.Names, names.dict[index])
+			t.Min = append(t.Min, int16(binary.LittleEndian.Uint32(values[0].values[4*i:])))
+			t.Max = append(t.Max, int16(binary.LittleEndian.Uint32(values[1].values[4*i:])))
+			t.Sum = append(t.Sum, int64(binary.LittleEndian.Uint64(values[2].values[8*i:])))
+			t.Count = append(t.Count, int64(binary.LittleEndian.Uint64(values[3].values[8*i:])))
+		}
+	}
+	return t, nil
+}
+
+// columnChunk is a column chunk read by readColumnChunk
+type columnChunk struct {
+	// dict is the PLAIN dictionary of a dictionary encoded column
+	dict []string
+	// values are the PLAIN values, or the dictionary indices as uint32
+	values []byte
+}
+
+// readColumnChunk reads the pages of a column chunk of n values
+func readColumnChunk(r *bufio.Reader, n int) (*columnChunk, error) {
+	c := &columnChunk{}
+	var dictionary bool
+	for read := 0; read < n; {
+		h, err := readThrift(r)
+		if err != nil {
+			return nil, fmt.Errorf("parquet: %w", err)
+		}
+		pageSize := h.int(3)
+		if pageSize < 0 || pageSize > 1<<30 {
+			return nil, fmt.Errorf("parquet: invalid page size %d", pageSize)
+		}
+		page, err := io.ReadAll(io.LimitReader(r, pageSize))
+		if err != nil {
+			return nil, fmt.Errorf("parquet: %w", err)
+		}
+		if int64(len(page)) != pageSize {
+			return nil, fmt.Errorf("parquet: truncated page")
+		}
+
+		switch h.int(1) {
+		case parquetDictionaryPage:
+			dictionary = true
+			for b := page; len(b) > 0; {
+				if len(b) < 4 || int64(binary.LittleEndian.Uint32(b)) > int64(len(b)-4) {
+					return nil, fmt.Errorf("parquet: truncated dictionary")
+				}
+				size := binary.LittleEndian.Uint32(b)
+				c.dict = append(c.dict, string(b[4:4+size]))
+				b = b[4+size:]
+			}
+		case parquetDataPage:
+			dp := h.strct(5)
+			count := int(dp.int(1))
+			switch dp.int(2) {
+			case parquetPlain:
+				c.values = append(c.values, page...)
+			case parquetRLEDictionary:
+				if !dictionary || len(page) == 0 {
+					return nil, fmt.Errorf("parquet: dictionary encoded page without a dictionary")
+				}
+				indices, err := unpackHybrid(page[1:], int(page[0]), count)
+				if err != nil {
+					return nil, err
+				}
+				for _, i := range indices {
+					c.values = binary.LittleEndian.AppendUint32(c.values, i)
+				}
+			default:
+				return nil, fmt.Errorf("parquet: unsupported encoding %d", dp.int(2))
+			}
+			read += count
+		default:
+			return nil, fmt.Errorf("parquet: unsupported page type %d", h.int(1))
+		}
+	}
+	return c, nil
+}
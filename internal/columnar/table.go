@@ -0,0 +1,69 @@
+// Package columnar writes the merged stations as an Arrow IPC stream and as a
+// Parquet file. The encoders are minimal and only need the standard library.
+// The readers only support what the writers write, they exist for the round
+// trip tests and the tools reading the files back.
+package columnar
+
+import (
+	"fmt"
+	"io"
+
+	"1brc/internal/agg"
+	"1brc/internal/format"
+)
+
+// Table is the merged station table, one row per station. Measurements are
+// in tenths of degrees, like agg.Station.
+type Table struct {
+	Names []string
+	Min   []int16
+	Max   []int16
+	Sum   []int64
+	Count []int64
+}
+
+// Columns are the column names of a Table in the files, in order
+var Columns = []string{"name", "min", "max", "sum", "count"}
+
+// Unit is the unit of min, max and sum, stored in the metadata of the files
+const Unit = "tenths of degrees"
+
+// FromResults returns the table of the stations names of results, in that
+// order
+func FromResults(results agg.Results, names []string) *Table {
+	t := &Table{
+		Names: names,
+		Min:   make([]int16, len(names)),
+		Max:   make([]int16, len(names)),
+		Sum:   make([]int64, len(names)),
+		Count: make([]int64, len(names)),
+	}
+	for i, name := range names {
+		s := results[name]
+		t.Min[i], t.Max[i] = s.Min, s.Max
+		t.Sum[i], t.Count[i] = int64(s.Total), int64(s.N)
+	}
+	return t
+}
+
+func (t *Table) Len() int {
+	return len(t.Names)
+}
+
+// check reports columns of different lengths
+func (t *Table) check() error {
+	n := len(t.Names)
+	if len(t.Min) != n || len(t.Max) != n || len(t.Sum) != n || len(t.Count) != n {
+		return fmt.Errorf("columnar: columns of different lengths: %d names, %d min, %d max, %d sum, %d count", n, len(t.Min), len(t.Max), len(t.Sum), len(t.Count))
+	}
+	return nil
+}
+
+// Encode writes the table like the output of fastbrc
+func (t *Table) Encode(w io.Writer, rounding string) error {
+	e := format.NewEncoder(w, rounding)
+	for i, name := range t.Names {
+		e.Station(name, int64(t.Min[i]), int64(t.Max[i]), t.Sum[i], t.Count[i])
+	}
+	return e.Close()
+}
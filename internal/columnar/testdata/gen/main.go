@@ -0,0 +1,106 @@
+// Command gen writes the golden files of the columnar tests with the
+// reference Go implementation of Arrow and Parquet, arrow-go. It's a module
+// of its own so the repository doesn't depend on arrow-go:
+//
+//	cd internal/columnar/testdata/gen && go run .
+package main
+
+import (
+	"log"
+	"os"
+
+	"github.com/apache/arrow-go/v18/arrow"
+	"github.com/apache/arrow-go/v18/arrow/array"
+	"github.com/apache/arrow-go/v18/arrow/ipc"
+	"github.com/apache/arrow-go/v18/arrow/memory"
+	"github.com/apache/arrow-go/v18/parquet"
+	"github.com/apache/arrow-go/v18/parquet/pqarrow"
+)
+
+// the table of goldenTable in columnar_test.go
+var (
+	names  = []string{"Abha", "Hamburg", "Las Palmas de Gran Canaria", "Zürich", "Ouagadougou"}
+	mins   = []int16{-999, -153, 0, -1, 999}
+	maxs   = []int16{999, 420, 0, -1, 999}
+	sums   = []int64{-9_000_000_000_001, 12_345, 0, -3_000_000_000, 999 * 3_000_000_000}
+	counts = []int64{1, 42, 7, 3_000_000_000, 3_000_000_000}
+)
+
+var metadata = arrow.NewMetadata([]string{"unit"}, []string{"tenths of degrees"})
+
+func fields(name arrow.DataType) []arrow.Field {
+	return []arrow.Field{
+		{Name: "name", Type: name},
+		{Name: "min", Type: arrow.PrimitiveTypes.Int16},
+		{Name: "max", Type: arrow.PrimitiveTypes.Int16},
+		{Name: "sum", Type: arrow.PrimitiveTypes.Int64},
+		{Name: "count", Type: arrow.PrimitiveTypes.Int64},
+	}
+}
+
+func record(schema *arrow.Schema) arrow.Record {
+	b := array.NewRecordBuilder(memory.DefaultAllocator, schema)
+	defer b.Release()
+	switch nb := b.Field(0).(type) {
+	case *array.BinaryDictionaryBuilder:
+		for _, name := range names {
+			if err := nb.AppendString(name); err != nil {
+				log.Fatal(err)
+			}
+		}
+	case *array.StringBuilder:
+		nb.AppendValues(names, nil)
+	}
+	b.Field(1).(*array.Int16Builder).AppendValues(mins, nil)
+	b.Field(2).(*array.Int16Builder).AppendValues(maxs, nil)
+	b.Field(3).(*array.Int64Builder).AppendValues(sums, nil)
+	b.Field(4).(*array.Int64Builder).AppendValues(counts, nil)
+	return b.NewRecord()
+}
+
+func main() {
+	// Arrow IPC stream: the names are dictionary encoded like WriteArrow
+	schema := arrow.NewSchema(fields(&arrow.DictionaryType{IndexType: arrow.PrimitiveTypes.Int32, ValueType: arrow.BinaryTypes.String}), &metadata)
+	f, err := os.Create("../stations.arrow")
+	if err != nil {
+		log.Fatal(err)
+	}
+	w := ipc.NewWriter(f, ipc.WithSchema(schema))
+	rec := record(schema)
+	if err := w.Write(rec); err != nil {
+		log.Fatal(err)
+	}
+	rec.Release()
+	if err := w.Close(); err != nil {
+		log.Fatal(err)
+	}
+	if err := f.Close(); err != nil {
+		log.Fatal(err)
+	}
+
+	// Parquet: uncompressed v1 data pages, only the names dictionary
+	// encoded, like WriteParquet
+	schema = arrow.NewSchema(fields(arrow.BinaryTypes.String), &metadata)
+	f, err = os.Create("../stations.parquet")
+	if err != nil {
+		log.Fatal(err)
+	}
+	props := parquet.NewWriterProperties(
+		parquet.WithDictionaryDefault(false),
+		parquet.WithDictionaryFor("name", true),
+		parquet.WithDataPageVersion(parquet.DataPageV1),
+		parquet.WithStats(false),
+	)
+	pw, err := pqarrow.NewFileWriter(schema, f, props, pqarrow.DefaultWriterProps())
+	if err != nil {
+		log.Fatal(err)
+	}
+	rec = record(schema)
+	if err := pw.Write(rec); err != nil {
+		log.Fatal(err)
+	}
+	rec.Release()
+	if err := pw.Close(); err != nil {
+		log.Fatal(err)
+	}
+}
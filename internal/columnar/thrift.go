@@ -0,0 +1,248 @@
+package columnar
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"io"
+)
+
+// Types of the thrift compact protocol, the Parquet metadata is encoded with
+// it
+const (
+	thriftStop   = 0
+	thriftTrue   = 1
+	thriftFalse  = 2
+	thriftByte   = 3
+	thriftI16    = 4
+	thriftI32    = 5
+	thriftI64    = 6
+	thriftBinary = 8
+	thriftList   = 9
+	thriftStruct = 12
+)
+
+// thriftWriter writes structs with the compact protocol. Fields must be
+// written in increasing ids, the ids of the enclosing structs are stacked.
+type thriftWriter struct {
+	b    []byte
+	last int16
+	ids  []int16
+}
+
+func (w *thriftWriter) varint(v uint64) {
+	w.b = binary.AppendUvarint(w.b, v)
+}
+
+func (w *thriftWriter) zigzag(v int64) {
+	w.varint(uint64(v<<1) ^ uint64(v>>63))
+}
+
+func (w *thriftWriter) field(id int16, typ byte) {
+	if delta := id - w.last; delta > 0 && delta <= 15 {
+		w.b = append(w.b, byte(delta)<<4|typ)
+	} else {
+		w.b = append(w.b, typ)
+		w.zigzag(int64(id))
+	}
+	w.last = id
+}
+
+func (w *thriftWriter) i32(id int16, v int32) {
+	w.field(id, thriftI32)
+	w.zigzag(int64(v))
+}
+
+func (w *thriftWriter) i64(id int16, v int64) {
+	w.field(id, thriftI64)
+	w.zigzag(v)
+}
+
+func (w *thriftWriter) byte(id int16, v int8) {
+	w.field(id, thriftByte)
+	w.b = append(w.b, byte(v))
+}
+
+func (w *thriftWriter) bool(id int16, v bool) {
+	if v {
+		w.field(id, thriftTrue)
+	} else {
+		w.field(id, thriftFalse)
+	}
+}
+
+func (w *thriftWriter) string(id int16, s string) {
+	w.field(id, thriftBinary)
+	w.varint(uint64(len(s)))
+	w.b = append(w.b, s...)
+}
+
+// list starts a list of n elements of type typ
+func (w *thriftWriter) list(id int16, typ byte, n int) {
+	w.field(id, thriftList)
+	w.listHeader(typ, n)
+}
+
+func (w *thriftWriter) listHeader(typ byte, n int) {
+	if n < 15 {
+		w.b = append(w.b, byte(n)<<4|typ)
+	} else {
+		w.b = append(w.b, 0xf0|typ)
+		w.varint(uint64(n))
+	}
+}
+
+// begin starts a struct, as field id or as an element of a list or the top
+// level struct when id is 0
+func (w *thriftWriter) begin(id int16) {
+	if id != 0 {
+		w.field(id, thriftStruct)
+	}
+	w.ids = append(w.ids, w.last)
+	w.last = 0
+}
+
+func (w *thriftWriter) end() {
+	w.b = append(w.b, thriftStop)
+	w.last = w.ids[len(w.ids)-1]
+	w.ids = w.ids[:len(w.ids)-1]
+}
+
+// thriftFields is a struct read by readThrift, by field id. Values are int64
+// for the integers and bools, string, []any or thriftFields.
+type thriftFields map[int16]any
+
+func (s thriftFields) int(id int16) int64 {
+	v, _ := s[id].(int64)
+	return v
+}
+
+func (s thriftFields) string(id int16) string {
+	v, _ := s[id].(string)
+	return v
+}
+
+func (s thriftFields) structs(id int16) []thriftFields {
+	list, _ := s[id].([]any)
+	var structs []thriftFields
+	for _, v := range list {
+		if st, ok := v.(thriftFields); ok {
+			structs = append(structs, st)
+		}
+	}
+	return structs
+}
+
+func (s thriftFields) strct(id int16) thriftFields {
+	v, _ := s[id].(thriftFields)
+	return v
+}
+
+// thriftReader reads structs with the compact protocol, without knowing them
+type thriftReader struct {
+	r     *bufio.Reader
+	depth int
+}
+
+// readThrift reads a struct from r
+func readThrift(r *bufio.Reader) (thriftFields, error) {
+	tr := &thriftReader{r: r}
+	s, err := tr.strct()
+	if err != nil {
+		return nil, fmt.Errorf("thrift: %w", err)
+	}
+	return s, nil
+}
+
+func (r *thriftReader) zigzag() (int64, error) {
+	v, err := binary.ReadUvarint(r.r)
+	return int64(v>>1) ^ -int64(v&1), err
+}
+
+func (r *thriftReader) strct() (thriftFields, error) {
+	if r.depth++; r.depth > 32 {
+		return nil, fmt.Errorf("structs nested too deep")
+	}
+	defer func() { r.depth-- }()
+
+	s := make(thriftFields)
+	var id int16
+	for {
+		b, err := r.r.ReadByte()
+		if err != nil {
+			return nil, err
+		}
+		if b == thriftStop {
+			return s, nil
+		}
+		typ := b & 0x0f
+		if delta := int16(b >> 4); delta != 0 {
+			id += delta
+		} else {
+			v, err := r.zigzag()
+			if err != nil {
+				return nil, err
+			}
+			id = int16(v)
+		}
+		switch typ {
+		case thriftTrue:
+			s[id] = int64(1)
+		case thriftFalse:
+			s[id] = int64(0)
+		default:
+			if s[id], err = r.value(typ); err != nil {
+				return nil, err
+			}
+		}
+	}
+}
+
+func (r *thriftReader) value(typ byte) (any, error) {
+	switch typ {
+	case thriftTrue, thriftFalse:
+		// bools of lists are a byte
+		b, err := r.r.ReadByte()
+		return int64(b & 1), err
+	case thriftByte:
+		b, err := r.r.ReadByte()
+		return int64(int8(b)), err
+	case thriftI16, thriftI32, thriftI64:
+		return r.zigzag()
+	case thriftBinary:
+		n, err := binary.ReadUvarint(r.r)
+		if err != nil {
+			return nil, err
+		}
+		if n > 1<<24 {
+			return nil, fmt.Errorf("string of %d bytes too large", n)
+		}
+		b := make([]byte, n)
+		_, err = io.ReadFull(r.r, b)
+		return string(b), err
+	case thriftList:
+		h, err := r.r.ReadByte()
+		if err != nil {
+			return nil, err
+		}
+		n := uint64(h >> 4)
+		if n == 15 {
+			if n, err = binary.ReadUvarint(r.r); err != nil {
+				return nil, err
+			}
+		}
+		if n > 1<<20 {
+			return nil, fmt.Errorf("list of %d elements too large", n)
+		}
+		list := make([]any, n)
+		for i := range list {
+			if list[i], err = r.value(h & 0x0f); err != nil {
+				return nil, err
+			}
+		}
+		return list, nil
+	case thriftStruct:
+		return r.strct()
+	}
+	return nil, fmt.Errorf("unsupported type %d", typ)
+}
@@ -0,0 +1,167 @@
+package dist
+
+import (
+	"context"
+	"encoding/gob"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"sync"
+	"time"
+
+	"1brc/internal/agg"
+)
+
+// Coordinator hands out tasks to the workers connecting to it and merges
+// their results. The zero value is ready to use.
+type Coordinator struct {
+	// TaskTimeout is how long a worker has to return the result of a task
+	// before it's given to another one, 0 means no limit
+	TaskTimeout time.Duration
+	// MaxAttempts is how many times a task can fail before Run gives up,
+	// 0 means 3
+	MaxAttempts int
+}
+
+type failure struct {
+	task Task
+	err  error
+}
+
+// Run serves tasks on ln until all of them are done and returns the merged
+// stations. ln is closed when Run returns.
+func (c *Coordinator) Run(ctx context.Context, ln net.Listener, tasks []Task) (*agg.Partial, error) {
+	// on return: stop the connections, which tell their worker when idle,
+	// stop accepting and wait for all of them
+	var wg sync.WaitGroup
+	defer wg.Wait()
+	defer ln.Close()
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	maxAttempts := c.MaxAttempts
+	if maxAttempts <= 0 {
+		maxAttempts = 3
+	}
+
+	// every task is either in the queue or held by one connection, the
+	// queue never blocks
+	queue := make(chan Task, len(tasks))
+	for _, t := range tasks {
+		queue <- t
+	}
+	results := make(chan Result)
+	failures := make(chan failure)
+
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				if ctx.Err() == nil && !errors.Is(err, net.ErrClosed) {
+					slog.Error("accept failed", "err", err)
+				}
+				return
+			}
+			slog.Debug("worker connected", "addr", conn.RemoteAddr())
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				c.serve(ctx, conn, queue, results, failures)
+			}()
+		}
+	}()
+
+	merged := agg.NewPartial()
+	completed := make([]bool, len(tasks))
+	attempts := make([]int, len(tasks))
+	remaining := len(tasks)
+	for remaining > 0 {
+		select {
+		case r := <-results:
+			if completed[r.TaskID] {
+				continue
+			}
+			completed[r.TaskID] = true
+			remaining--
+			if r.Stations != nil {
+				merged.Merge(r.Stations)
+			}
+			slog.Debug("task done", "id", r.TaskID, "remaining", remaining)
+
+		case f := <-failures:
+			if completed[f.task.ID] {
+				continue
+			}
+			attempts[f.task.ID]++
+			if attempts[f.task.ID] >= maxAttempts {
+				return nil, fmt.Errorf("task %d (%s@%d) failed %d times: %w", f.task.ID, f.task.Path, f.task.Offset, attempts[f.task.ID], f.err)
+			}
+			slog.Warn("task failed, retrying", "id", f.task.ID, "attempt", attempts[f.task.ID], "err", f.err)
+			queue <- f.task
+
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+
+	return merged, nil
+}
+
+// serve hands out tasks to the worker on conn, one at a time
+func (c *Coordinator) serve(ctx context.Context, conn net.Conn, queue chan Task, results chan<- Result, failures chan<- failure) {
+	defer conn.Close()
+	// unblock the wait for a result, the coordinator is done with it
+	stop := context.AfterFunc(ctx, func() { conn.SetReadDeadline(time.Now()) })
+	defer stop()
+	enc := gob.NewEncoder(conn)
+	dec := gob.NewDecoder(conn)
+
+	for {
+		var t Task
+		select {
+		case t = <-queue:
+		case <-ctx.Done():
+			conn.SetDeadline(time.Now().Add(time.Second))
+			enc.Encode(Message{Done: true})
+			return
+		}
+
+		if c.TaskTimeout > 0 {
+			conn.SetDeadline(time.Now().Add(c.TaskTimeout))
+		}
+		var r Result
+		err := enc.Encode(Message{Task: &t})
+		if err == nil {
+			err = dec.Decode(&r)
+		}
+		if err == nil && r.TaskID != t.ID {
+			err = fmt.Errorf("got the result of task %d instead of %d", r.TaskID, t.ID)
+		}
+		if err != nil {
+			// the connection is unusable
+			select {
+			case failures <- failure{t, fmt.Errorf("worker %s: %w", conn.RemoteAddr(), err)}:
+			case <-ctx.Done():
+			}
+			return
+		}
+
+		if r.Err != "" {
+			select {
+			case failures <- failure{t, fmt.Errorf("worker %s: %w", conn.RemoteAddr(), errors.New(r.Err))}:
+			case <-ctx.Done():
+				return
+			}
+			continue
+		}
+
+		select {
+		case results <- r:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
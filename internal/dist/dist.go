@@ -0,0 +1,74 @@
+// Package dist spreads the aggregation of large inputs over several hosts.
+//
+// A coordinator splits the input files in newline aligned ranges, workers
+// connect to it and pull ranges one at a time, run fastbrc.ParseWorker on
+// them and send back the stations they found, as an agg.Partial. Workers read the files
+// themselves, they have to see them at the same path as the coordinator.
+//
+// Messages are gob encoded over a single TCP connection per worker. A range
+// held by a worker whose connection breaks, or which doesn't answer in time,
+// is handed out again.
+package dist
+
+import (
+	"fmt"
+	"path/filepath"
+
+	"golang.org/x/exp/mmap"
+
+	"1brc/internal/agg"
+	"1brc/internal/brc"
+)
+
+// Task is a range of lines of a file, the last one ends with its '\n'
+type Task struct {
+	ID     int
+	Path   string
+	Offset int64
+	Length int64
+}
+
+// Message is sent by the coordinator, either a task or the end of the work
+type Message struct {
+	Task *Task
+	Done bool
+}
+
+// Result is the answer of a worker to a Task
+type Result struct {
+	TaskID int
+	// Stations sum with int64, the merge of many tasks doesn't overflow
+	Stations *agg.Partial
+	// Err is set when the worker failed to process the task
+	Err string
+}
+
+// Split splits paths in tasks of about rangeSize bytes
+func Split(paths []string, rangeSize int64) ([]Task, error) {
+	if rangeSize <= 0 {
+		return nil, fmt.Errorf("invalid range size %d", rangeSize)
+	}
+
+	var tasks []Task
+	for _, path := range paths {
+		path, err := filepath.Abs(path)
+		if err != nil {
+			return nil, err
+		}
+		mm, err := mmap.Open(path)
+		if err != nil {
+			return nil, fmt.Errorf("mmap.Open: %w", err)
+		}
+		n := max(1, int((int64(mm.Len())+rangeSize-1)/rangeSize))
+		for _, section := range brc.SectionBoundaries(mm, n) {
+			tasks = append(tasks, Task{
+				ID:     len(tasks),
+				Path:   path,
+				Offset: section.Start,
+				Length: section.End - section.Start + 1,
+			})
+		}
+		mm.Close()
+	}
+	return tasks, nil
+}
@@ -0,0 +1,316 @@
+package dist
+
+import (
+	"bytes"
+	"context"
+	"encoding/gob"
+	"fmt"
+	"math/rand/v2"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"1brc/internal/agg"
+)
+
+// Worker processes of TestProcesses are this test binary, started with
+// BRC_DIST_WORKER set to the address of the coordinator
+func TestMain(m *testing.M) {
+	if addr := os.Getenv("BRC_DIST_WORKER"); addr != "" {
+		os.Exit(helperWorker(addr, os.Getenv("BRC_DIST_CRASH") != ""))
+	}
+	os.Exit(m.Run())
+}
+
+func helperWorker(addr string, crash bool) int {
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	if crash {
+		// take a task and die with it
+		var m Message
+		gob.NewDecoder(conn).Decode(&m)
+		return 3
+	}
+	w := Worker{NWorkers: 2, ChunkSize: 4096}
+	if err := w.Serve(conn); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	return 0
+}
+
+func writeMeasurements(t *testing.T, name string, nlines int, seed uint64) string {
+	r := rand.New(rand.NewPCG(seed, 2))
+	names := []string{"Abha", "Abéché", "Addis Ababa", "A", "St. John's", "Las Palmas de Gran Canaria", "Zürich", "Yo"}
+	var b bytes.Buffer
+	for range nlines {
+		v := r.IntN(1999) - 999
+		sign := ""
+		if v < 0 {
+			sign = "-"
+			v = -v
+		}
+		fmt.Fprintf(&b, "%s;%s%d.%d\n", names[r.IntN(len(names))], sign, v/10, v%10)
+	}
+	path := filepath.Join(t.TempDir(), name)
+	require.NoError(t, os.WriteFile(path, b.Bytes(), 0o644))
+	return path
+}
+
+// naive aggregates paths line by line
+func naive(t *testing.T, paths ...string) string {
+	results := agg.NewResults()
+	for _, path := range paths {
+		b, err := os.ReadFile(path)
+		require.NoError(t, err)
+		for _, line := range strings.Split(strings.TrimSuffix(string(b), "\n"), "\n") {
+			name, value, _ := strings.Cut(line, ";")
+			v, err := strconv.ParseFloat(value, 64)
+			require.NoError(t, err)
+			m := int16(v * 10)
+			if v < 0 {
+				m = int16(v*10 - 0.5)
+			} else {
+				m = int16(v*10 + 0.5)
+			}
+			results.Merge(name, agg.NewStation(m))
+		}
+	}
+	return results.String()
+}
+
+func listen(t *testing.T) net.Listener {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	return ln
+}
+
+func goWorker(t *testing.T, wg *sync.WaitGroup, addr string) {
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		conn, err := net.Dial("tcp", addr)
+		if !assert.NoError(t, err) {
+			return
+		}
+		defer conn.Close()
+		w := Worker{NWorkers: 2, ChunkSize: 4096}
+		assert.NoError(t, w.Serve(conn))
+	}()
+}
+
+func TestSplit(t *testing.T) {
+	paths := []string{writeMeasurements(t, "a.txt", 10000, 1), writeMeasurements(t, "b.txt", 100, 2)}
+	tasks, err := Split(paths, 16*1024)
+	require.NoError(t, err)
+
+	var size int64
+	for i, task := range tasks {
+		assert.Equal(t, i, task.ID)
+		b, err := os.ReadFile(task.Path)
+		require.NoError(t, err)
+		if task.Offset > 0 {
+			assert.Equal(t, byte('\n'), b[task.Offset-1], "task %d starts a line", i)
+		}
+		assert.Equal(t, byte('\n'), b[task.Offset+task.Length-1], "task %d ends a line", i)
+		size += task.Length
+	}
+	a, _ := os.Stat(paths[0])
+	b, _ := os.Stat(paths[1])
+	assert.Equal(t, a.Size()+b.Size(), size)
+	assert.Greater(t, len(tasks), 10)
+
+	_, err = Split(paths, 0)
+	assert.Error(t, err)
+}
+
+func TestCoordinator(t *testing.T) {
+	paths := []string{writeMeasurements(t, "a.txt", 20000, 1), writeMeasurements(t, "b.txt", 5000, 2)}
+	tasks, err := Split(paths, 8*1024)
+	require.NoError(t, err)
+
+	ln := listen(t)
+	var wg sync.WaitGroup
+	for range 3 {
+		goWorker(t, &wg, ln.Addr().String())
+	}
+	c := Coordinator{}
+	results, err := c.Run(context.Background(), ln, tasks)
+	require.NoError(t, err)
+	wg.Wait()
+	assert.Equal(t, naive(t, paths...), results.String())
+}
+
+func TestCoordinatorReassigns(t *testing.T) {
+	path := writeMeasurements(t, "a.txt", 20000, 3)
+	tasks, err := Split([]string{path}, 8*1024)
+	require.NoError(t, err)
+
+	ln := listen(t)
+	addr := ln.Addr().String()
+	var wg sync.WaitGroup
+	// a worker closing the connection with a task
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		conn, err := net.Dial("tcp", addr)
+		if !assert.NoError(t, err) {
+			return
+		}
+		var m Message
+		assert.NoError(t, gob.NewDecoder(conn).Decode(&m))
+		conn.Close()
+	}()
+	// a worker never answering
+	stuck, err := net.Dial("tcp", addr)
+	require.NoError(t, err)
+	defer stuck.Close()
+	// a worker failing its task
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		conn, err := net.Dial("tcp", addr)
+		if !assert.NoError(t, err) {
+			return
+		}
+		defer conn.Close()
+		var m Message
+		if assert.NoError(t, gob.NewDecoder(conn).Decode(&m)) {
+			assert.NoError(t, gob.NewEncoder(conn).Encode(Result{TaskID: m.Task.ID, Err: "disk on fire"}))
+		}
+	}()
+	// and a good one, late
+	time.AfterFunc(100*time.Millisecond, func() { goWorker(t, &wg, addr) })
+
+	c := Coordinator{TaskTimeout: 500 * time.Millisecond}
+	results, err := c.Run(context.Background(), ln, tasks)
+	require.NoError(t, err)
+	wg.Wait()
+	assert.Equal(t, naive(t, path), results.String())
+}
+
+func TestCoordinatorGivesUp(t *testing.T) {
+	path := writeMeasurements(t, "a.txt", 100, 4)
+	tasks, err := Split([]string{path}, 1024*1024)
+	require.NoError(t, err)
+	require.NoError(t, os.Remove(path))
+
+	ln := listen(t)
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		conn, err := net.Dial("tcp", ln.Addr().String())
+		if !assert.NoError(t, err) {
+			return
+		}
+		defer conn.Close()
+		w := Worker{}
+		w.Serve(conn)
+	}()
+	c := Coordinator{MaxAttempts: 2}
+	_, err = c.Run(context.Background(), ln, tasks)
+	assert.ErrorContains(t, err, "failed 2 times")
+	wg.Wait()
+}
+
+func TestCoordinatorSumsDontOverflow(t *testing.T) {
+	tasks := []Task{{ID: 0, Path: "a"}, {ID: 1, Path: "b"}, {ID: 2, Path: "c"}}
+	ln := listen(t)
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		conn, err := net.Dial("tcp", ln.Addr().String())
+		if !assert.NoError(t, err) {
+			return
+		}
+		defer conn.Close()
+		enc, dec := gob.NewEncoder(conn), gob.NewDecoder(conn)
+		for {
+			var m Message
+			if !assert.NoError(t, dec.Decode(&m)) || m.Done {
+				return
+			}
+			// each task is more than an int32 of measurements of 99.9
+			p := agg.NewPartial()
+			p.Stations["Abha"] = &agg.PartialStation{Min: 999, Max: 999, Sum: 999 * 3_000_000_000, Count: 3_000_000_000}
+			assert.NoError(t, enc.Encode(Result{TaskID: m.Task.ID, Stations: p}))
+		}
+	}()
+
+	c := Coordinator{}
+	results, err := c.Run(context.Background(), ln, tasks)
+	require.NoError(t, err)
+	wg.Wait()
+	assert.Equal(t, &agg.PartialStation{Min: 999, Max: 999, Sum: 3 * 999 * 3_000_000_000, Count: 9_000_000_000}, results.Stations["Abha"])
+	assert.Equal(t, "{Abha=99.9/99.9/99.9}", results.String())
+}
+
+// accepted is a listener returning already accepted connections first
+type accepted struct {
+	net.Listener
+	conns []net.Conn
+}
+
+func (l *accepted) Accept() (net.Conn, error) {
+	if len(l.conns) > 0 {
+		conn := l.conns[0]
+		l.conns = l.conns[1:]
+		return conn, nil
+	}
+	return l.Listener.Accept()
+}
+
+func TestProcesses(t *testing.T) {
+	paths := []string{writeMeasurements(t, "a.txt", 50000, 5), writeMeasurements(t, "b.txt", 50000, 6)}
+	tasks, err := Split(paths, 16*1024)
+	require.NoError(t, err)
+
+	ln := listen(t)
+	var cmds []*exec.Cmd
+	for i := range 4 {
+		cmd := exec.Command(os.Args[0], "-test.run=^$")
+		cmd.Env = append(os.Environ(), "BRC_DIST_WORKER="+ln.Addr().String())
+		if i == 0 {
+			cmd.Env = append(cmd.Env, "BRC_DIST_CRASH=1")
+		}
+		cmd.Stderr = os.Stderr
+		require.NoError(t, cmd.Start())
+		cmds = append(cmds, cmd)
+	}
+
+	// all the workers get to connect before the work is done
+	l := &accepted{Listener: ln}
+	for range cmds {
+		conn, err := ln.Accept()
+		require.NoError(t, err)
+		l.conns = append(l.conns, conn)
+	}
+
+	c := Coordinator{TaskTimeout: 10 * time.Second}
+	results, err := c.Run(context.Background(), l, tasks)
+	require.NoError(t, err)
+	assert.Equal(t, naive(t, paths...), results.String())
+
+	for i, cmd := range cmds {
+		err := cmd.Wait()
+		if i == 0 {
+			assert.Error(t, err, "crashing worker")
+		} else {
+			assert.NoError(t, err, "worker %d", i)
+		}
+	}
+}
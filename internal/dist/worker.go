@@ -0,0 +1,98 @@
+package dist
+
+import (
+	"encoding/gob"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+
+	"golang.org/x/sys/unix"
+
+	"1brc/internal/agg"
+	"1brc/internal/fastbrc"
+)
+
+// Worker processes the tasks of a coordinator
+type Worker struct {
+	// NWorkers is the number of ParseWorker goroutines per task
+	NWorkers int
+	// ChunkSize is the size of the chunks of a task given to the goroutines
+	ChunkSize int
+}
+
+// Serve processes the tasks sent on conn until the coordinator is done.
+// It returns nil when told so.
+func (w *Worker) Serve(conn net.Conn) error {
+	enc := gob.NewEncoder(conn)
+	dec := gob.NewDecoder(conn)
+	for {
+		var m Message
+		if err := dec.Decode(&m); err != nil {
+			if errors.Is(err, io.EOF) {
+				return errors.New("coordinator went away")
+			}
+			return err
+		}
+		if m.Done {
+			return nil
+		}
+		if m.Task == nil {
+			return fmt.Errorf("empty message")
+		}
+
+		r := Result{TaskID: m.Task.ID}
+		stations, err := w.Run(*m.Task)
+		if err != nil {
+			r.Err = err.Error()
+		}
+		r.Stations = stations
+		if err := enc.Encode(r); err != nil {
+			return err
+		}
+	}
+}
+
+// Run processes t and returns its stations
+func (w *Worker) Run(t Task) (*agg.Partial, error) {
+	data, err := fastbrc.MmapFile(t.Path, fastbrc.InputWillNeed)
+	if err != nil {
+		return nil, err
+	}
+	defer unix.Munmap(data)
+	if t.Offset < 0 || t.Length <= 0 || t.Offset+t.Length > int64(len(data)) {
+		return nil, fmt.Errorf("range %d+%d out of %s (%d bytes)", t.Offset, t.Length, t.Path, len(data))
+	}
+	b := data[t.Offset : t.Offset+t.Length]
+	if b[len(b)-1] != '\n' {
+		return nil, fmt.Errorf("range %d+%d of %s doesn't end a line", t.Offset, t.Length, t.Path)
+	}
+
+	nworkers := max(1, w.NWorkers)
+	chunkSize := w.ChunkSize
+	if chunkSize <= 0 {
+		chunkSize = 2 * 1024 * 1024
+	}
+	chunker := fastbrc.NewAtomicChunker(b, chunkSize)
+	tables := make([][]agg.Station, nworkers)
+	wg := sync.WaitGroup{}
+	wg.Add(nworkers)
+	for i := range nworkers {
+		go func() {
+			defer wg.Done()
+			tables[i] = fastbrc.ParseWorker(chunker)
+		}()
+	}
+	wg.Wait()
+
+	p := agg.NewPartial()
+	for _, table := range tables {
+		for i := range table {
+			if len(table[i].Name) > 0 {
+				p.AddStation(string(table[i].Name), &table[i])
+			}
+		}
+	}
+	return p, nil
+}
@@ -0,0 +1,266 @@
+package fastbrc
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// ParseCPUList parses a cpu list in the format used by the kernel in /sys and
+// by taskset(1), i.e: "0-3,8,10-11"
+func ParseCPUList(s string) ([]int, error) {
+	var cpus []int
+	s = strings.TrimSpace(s)
+	if s == "" {
+		return cpus, nil
+	}
+
+	for _, part := range strings.Split(s, ",") {
+		lo, hi, isRange := strings.Cut(part, "-")
+		first, err := strconv.Atoi(lo)
+		if err != nil {
+			return nil, fmt.Errorf("invalid cpu list %q: %w", s, err)
+		}
+		last := first
+		if isRange {
+			last, err = strconv.Atoi(hi)
+			if err != nil {
+				return nil, fmt.Errorf("invalid cpu list %q: %w", s, err)
+			}
+		}
+		if first < 0 || last < first {
+			return nil, fmt.Errorf("invalid cpu range %q", part)
+		}
+		for cpu := first; cpu <= last; cpu++ {
+			cpus = append(cpus, cpu)
+		}
+	}
+
+	slices.Sort(cpus)
+	return slices.Compact(cpus), nil
+}
+
+type NUMANode struct {
+	ID   int
+	CPUs []int
+}
+
+const sysNodePath = "/sys/devices/system/node"
+
+// NUMANodes reads the numa topology from /sys. Nodes without cpus are skipped.
+func NUMANodes() ([]NUMANode, error) {
+	return numaNodes(sysNodePath)
+}
+
+func numaNodes(root string) ([]NUMANode, error) {
+	dirs, err := filepath.Glob(filepath.Join(root, "node[0-9]*"))
+	if err != nil {
+		return nil, err
+	}
+	if len(dirs) == 0 {
+		return nil, fmt.Errorf("no numa node found in %s", root)
+	}
+
+	nodes := make([]NUMANode, 0, len(dirs))
+	for _, dir := range dirs {
+		id, err := strconv.Atoi(strings.TrimPrefix(filepath.Base(dir), "node"))
+		if err != nil {
+			continue
+		}
+		cpulist, err := os.ReadFile(filepath.Join(dir, "cpulist"))
+		if err != nil {
+			return nil, err
+		}
+		cpus, err := ParseCPUList(string(cpulist))
+		if err != nil {
+			return nil, fmt.Errorf("node%d: %w", id, err)
+		}
+		if len(cpus) == 0 {
+			continue
+		}
+		nodes = append(nodes, NUMANode{ID: id, CPUs: cpus})
+	}
+	slices.SortFunc(nodes, func(a, b NUMANode) int { return a.ID - b.ID })
+	return nodes, nil
+}
+
+// Placement describes on which cpus each worker runs, and for numa aware
+// placements, which region of the input each worker parses first.
+type Placement struct {
+	// WorkerCPUs is the cpu set of each worker, a nil set means not pinned.
+	WorkerCPUs [][]int
+	// WorkerRegion is the index of the input region of each worker, nil
+	// when the input isn't split in regions.
+	WorkerRegion []int
+	// RegionWorkers is the number of workers assigned to each region.
+	RegionWorkers []int
+}
+
+// NewCPUPlacement pins worker i to cpus[i%len(cpus)].
+func NewCPUPlacement(cpus []int, nworkers int) (*Placement, error) {
+	if len(cpus) == 0 {
+		return nil, fmt.Errorf("empty cpu list")
+	}
+	p := &Placement{WorkerCPUs: make([][]int, nworkers)}
+	for i := range nworkers {
+		p.WorkerCPUs[i] = []int{cpus[i%len(cpus)]}
+	}
+	return p, nil
+}
+
+// NewNUMAPlacement spreads workers over nodes in proportion to their number of
+// cpus, pins every worker to the cpus of its node and assigns one contiguous
+// region of the input to each node.
+// When allowed is not empty, only the cpus it contains are used.
+func NewNUMAPlacement(nodes []NUMANode, allowed []int, nworkers int) (*Placement, error) {
+	if len(allowed) > 0 {
+		filtered := make([]NUMANode, 0, len(nodes))
+		for _, node := range nodes {
+			cpus := slices.DeleteFunc(slices.Clone(node.CPUs), func(cpu int) bool {
+				return !slices.Contains(allowed, cpu)
+			})
+			if len(cpus) > 0 {
+				filtered = append(filtered, NUMANode{ID: node.ID, CPUs: cpus})
+			}
+		}
+		nodes = filtered
+	}
+	if len(nodes) == 0 {
+		return nil, fmt.Errorf("no usable numa node")
+	}
+
+	totalCPUs := 0
+	for _, node := range nodes {
+		totalCPUs += len(node.CPUs)
+	}
+
+	// largest remainder so the per node counts add up to nworkers
+	regionWorkers := make([]int, len(nodes))
+	remainders := make([]int, len(nodes))
+	assigned := 0
+	for i, node := range nodes {
+		regionWorkers[i] = nworkers * len(node.CPUs) / totalCPUs
+		remainders[i] = nworkers * len(node.CPUs) % totalCPUs
+		assigned += regionWorkers[i]
+	}
+	for ; assigned < nworkers; assigned++ {
+		best := 0
+		for i := range remainders {
+			if remainders[i] > remainders[best] {
+				best = i
+			}
+		}
+		regionWorkers[best]++
+		remainders[best] = -1
+	}
+
+	p := &Placement{
+		WorkerCPUs:    make([][]int, 0, nworkers),
+		WorkerRegion:  make([]int, 0, nworkers),
+		RegionWorkers: regionWorkers,
+	}
+	for i, n := range regionWorkers {
+		for range n {
+			p.WorkerCPUs = append(p.WorkerCPUs, nodes[i].CPUs)
+			p.WorkerRegion = append(p.WorkerRegion, i)
+		}
+	}
+	return p, nil
+}
+
+// Pin restricts the calling OS thread to the cpus of worker i.
+// The caller must have called runtime.LockOSThread.
+func (p *Placement) Pin(i int) error {
+	if p == nil || len(p.WorkerCPUs[i]) == 0 {
+		return nil
+	}
+	return PinThread(p.WorkerCPUs[i])
+}
+
+// RegionChunker splits its input in contiguous regions, each served by an
+// AtomicChunker. Workers take chunks from their own region first and then
+// help with the other regions once it is exhausted.
+type RegionChunker struct {
+	b       []byte
+	regions []*AtomicChunker
+}
+
+// NewRegionChunker splits input at line boundaries in len(weights) regions
+// with sizes proportional to weights.
+func NewRegionChunker(input []byte, weights []int, chunkSize int) *RegionChunker {
+	total := 0
+	for _, w := range weights {
+		total += w
+	}
+
+	c := &RegionChunker{b: input, regions: make([]*AtomicChunker, len(weights))}
+	whole := NewAtomicChunker(input, chunkSize)
+	start, acc := 0, 0
+	for i, w := range weights {
+		acc += w
+		end := len(input)
+		if i < len(weights)-1 {
+			end = whole.nextLineStart(int(int64(len(input)) * int64(acc) / int64(total)))
+		}
+		c.regions[i] = NewAtomicChunker(input[start:end], chunkSize)
+		start = end
+	}
+	return c
+}
+
+func (c *RegionChunker) ForWorker(region int) ChunkGetter {
+	return &regionWorkerChunker{c: c, region: region}
+}
+
+// NextChunk serves chunks from the regions in order, for callers that are not
+// region aware.
+func (c *RegionChunker) NextChunk() *[]byte {
+	for _, r := range c.regions {
+		if chunk := r.NextChunk(); chunk != nil {
+			return chunk
+		}
+	}
+	return nil
+}
+
+func (c *RegionChunker) ReleaseChunk(chunk *[]byte) {
+	madviseDontNeed(c.b, *chunk)
+}
+
+// ChunkOffset locates chunk in the whole input, the padded copies are only
+// known to the region that made them
+func (c *RegionChunker) ChunkOffset(chunk []byte) int64 {
+	if len(chunk) > 0 && within(c.b, chunk) {
+		return int64(offsetIn(c.b, chunk))
+	}
+	for _, r := range c.regions {
+		if offset := r.ChunkOffset(chunk); offset >= 0 {
+			return offset + int64(offsetIn(c.b, r.b))
+		}
+	}
+	return -1
+}
+
+// Run is a noop, see AtomicChunker.Run
+func (c *RegionChunker) Run() error {
+	return nil
+}
+
+type regionWorkerChunker struct {
+	c      *RegionChunker
+	region int
+}
+
+func (w *regionWorkerChunker) NextChunk() *[]byte {
+	if chunk := w.c.regions[w.region].NextChunk(); chunk != nil {
+		return chunk
+	}
+	return w.c.NextChunk()
+}
+
+func (w *regionWorkerChunker) ReleaseChunk(chunk *[]byte) {
+	w.c.ReleaseChunk(chunk)
+}
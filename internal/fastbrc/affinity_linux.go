@@ -0,0 +1,36 @@
+package fastbrc
+
+import (
+	"fmt"
+
+	"golang.org/x/sys/unix"
+)
+
+// PinThread restricts the calling OS thread to cpus with sched_setaffinity(2).
+// The caller must have called runtime.LockOSThread.
+func PinThread(cpus []int) error {
+	var set unix.CPUSet
+	set.Zero()
+	for _, cpu := range cpus {
+		set.Set(cpu)
+	}
+	if err := unix.SchedSetaffinity(0, &set); err != nil {
+		return fmt.Errorf("sched_setaffinity %v: %w", cpus, err)
+	}
+	return nil
+}
+
+// AllowedCPUs returns the cpus the process is allowed to run on.
+func AllowedCPUs() ([]int, error) {
+	var set unix.CPUSet
+	if err := unix.SchedGetaffinity(0, &set); err != nil {
+		return nil, fmt.Errorf("sched_getaffinity: %w", err)
+	}
+	cpus := make([]int, 0, set.Count())
+	for cpu := 0; len(cpus) < set.Count(); cpu++ {
+		if set.IsSet(cpu) {
+			cpus = append(cpus, cpu)
+		}
+	}
+	return cpus, nil
+}
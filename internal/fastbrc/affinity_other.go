@@ -0,0 +1,13 @@
+//go:build !linux
+
+package fastbrc
+
+import "fmt"
+
+func PinThread(cpus []int) error {
+	return fmt.Errorf("cpu pinning is only supported on linux")
+}
+
+func AllowedCPUs() ([]int, error) {
+	return nil, fmt.Errorf("cpu affinity is only supported on linux")
+}
@@ -0,0 +1,132 @@
+package fastbrc
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"sync"
+	"testing"
+	"unsafe"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseCPUList(t *testing.T) {
+	tcs := []struct {
+		s           string
+		expected    []int
+		expectedErr bool
+	}{
+		{"", nil, false},
+		{"0\n", []int{0}, false},
+		{"0-3", []int{0, 1, 2, 3}, false},
+		{"0-1,8,10-11", []int{0, 1, 8, 10, 11}, false},
+		{"4,0-1,1", []int{0, 1, 4}, false},
+		{"3-1", nil, true},
+		{"a-b", nil, true},
+		{"1,", nil, true},
+	}
+	for _, tc := range tcs {
+		t.Run(tc.s, func(t *testing.T) {
+			cpus, err := ParseCPUList(tc.s)
+			if tc.expectedErr {
+				assert.Error(t, err)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, tc.expected, cpus)
+		})
+	}
+}
+
+func TestNUMANodes(t *testing.T) {
+	root := t.TempDir()
+	for node, cpulist := range map[string]string{"node0": "0-3,8-11\n", "node1": "4-7,12-15\n", "node2": "\n"} {
+		require.NoError(t, os.Mkdir(filepath.Join(root, node), 0o755))
+		require.NoError(t, os.WriteFile(filepath.Join(root, node, "cpulist"), []byte(cpulist), 0o644))
+	}
+
+	nodes, err := numaNodes(root)
+	assert.NoError(t, err)
+	assert.Equal(t, []NUMANode{
+		{ID: 0, CPUs: []int{0, 1, 2, 3, 8, 9, 10, 11}},
+		{ID: 1, CPUs: []int{4, 5, 6, 7, 12, 13, 14, 15}},
+	}, nodes)
+
+	_, err = numaNodes(t.TempDir())
+	assert.Error(t, err)
+}
+
+func TestNUMAPlacement(t *testing.T) {
+	nodes := []NUMANode{
+		{ID: 0, CPUs: []int{0, 1, 2, 3}},
+		{ID: 1, CPUs: []int{4, 5}},
+	}
+
+	p, err := NewNUMAPlacement(nodes, nil, 7)
+	assert.NoError(t, err)
+	assert.Equal(t, []int{5, 2}, p.RegionWorkers)
+	assert.Equal(t, []int{0, 0, 0, 0, 0, 1, 1}, p.WorkerRegion)
+	assert.Equal(t, []int{4, 5}, p.WorkerCPUs[6])
+
+	p, err = NewNUMAPlacement(nodes, []int{4, 5}, 3)
+	assert.NoError(t, err)
+	assert.Equal(t, []int{3}, p.RegionWorkers)
+	assert.Equal(t, []int{4, 5}, p.WorkerCPUs[0])
+
+	_, err = NewNUMAPlacement(nodes, []int{42}, 3)
+	assert.Error(t, err)
+}
+
+func TestPinThread(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("linux only")
+	}
+	// never unlocked: the pinned thread is discarded when the test goroutine exits
+	runtime.LockOSThread()
+
+	p, err := NewCPUPlacement([]int{0}, 1)
+	assert.NoError(t, err)
+	assert.NoError(t, p.Pin(0))
+}
+
+func TestRegionChunker(t *testing.T) {
+	b := make([]byte, 0, 1024*1024)
+	for i := range 64 * 1024 {
+		b = fmt.Appendf(b, "station%d;%d.%d\n", i%413, i%100, i%10)
+	}
+
+	weights := []int{3, 1, 0, 2}
+	chunker := NewRegionChunker(b, weights, 1000)
+	received := make([][][]byte, 6)
+	wg := sync.WaitGroup{}
+	wg.Add(len(received))
+	for i := range received {
+		go func() {
+			defer wg.Done()
+			getter := chunker.ForWorker(i % len(weights))
+			for {
+				chunk := getter.NextChunk()
+				if chunk == nil {
+					return
+				}
+				assert.Equal(t, byte('\n'), (*chunk)[len(*chunk)-1])
+				received[i] = append(received[i], *chunk)
+			}
+		}()
+	}
+	wg.Wait()
+
+	var chunks [][]byte
+	for i := range received {
+		chunks = append(chunks, received[i]...)
+	}
+	sort.Slice(chunks, func(i, j int) bool {
+		return uintptr(unsafe.Pointer(&chunks[i][0])) < uintptr(unsafe.Pointer(&chunks[j][0]))
+	})
+	assert.Equal(t, b, bytes.Join(chunks, nil))
+}
@@ -0,0 +1,226 @@
+package fastbrc
+
+import (
+	"bufio"
+	"cmp"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"unsafe"
+
+	"1brc/internal/agg"
+	"1brc/internal/format"
+)
+
+// Reasons of an Anomaly
+const (
+	AnomalyStddev = "stddev"
+	AnomalyBounds = "bounds"
+)
+
+// Bounds are the valid measurements of a station, in tenths of degrees
+type Bounds struct {
+	Min, Max int16
+}
+
+// LoadBounds reads the bounds of stations from name;min;max lines, i.e:
+// Hamburg;-30.0;40.0. Lines starting with # are ignored.
+func LoadBounds(filename string) (map[string]Bounds, error) {
+	b, err := os.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	bounds := make(map[string]Bounds)
+	for i, line := range strings.Split(string(b), "\n") {
+		if len(line) == 0 || line[0] == '#' {
+			continue
+		}
+		fields := strings.Split(line, ";")
+		if len(fields) != 3 {
+			return nil, fmt.Errorf("%s:%d: want name;min;max, got %q", filename, i+1, line)
+		}
+		var minmax [2]int16
+		for j, field := range fields[1:] {
+			v, err := strconv.ParseFloat(strings.TrimSpace(field), 64)
+			if err != nil || v < -3276.7 || v > 3276.7 {
+				return nil, fmt.Errorf("%s:%d: invalid bound %q", filename, i+1, field)
+			}
+			minmax[j] = int16(math.Round(v * 10))
+		}
+		if minmax[0] > minmax[1] {
+			return nil, fmt.Errorf("%s:%d: min %s > max %s", filename, i+1, fields[1], fields[2])
+		}
+		bounds[fields[0]] = Bounds{Min: minmax[0], Max: minmax[1]}
+	}
+	return bounds, nil
+}
+
+type Anomaly struct {
+	Station string
+	// Value in tenths of degrees
+	Value int16
+	// Offset is the position of the line in the input, -1 when the chunker
+	// doesn't implement ChunkOffsets
+	Offset int64
+	Reason string
+}
+
+// Anomalies found by a worker, or merged from several
+type Anomalies struct {
+	List []Anomaly
+	// Total counts all the anomalies found, kept in List or not
+	Total int64
+}
+
+func (a *Anomalies) add(anomaly Anomaly, limit int) {
+	a.Total++
+	if limit <= 0 || len(a.List) < limit {
+		a.List = append(a.List, anomaly)
+	}
+}
+
+// MergeAnomalies merges the anomalies of the workers ordered by offset and
+// keeps the first limit of them, limit <= 0 keeps everything
+func MergeAnomalies(parts []*Anomalies, limit int) *Anomalies {
+	merged := &Anomalies{}
+	for _, part := range parts {
+		if part == nil {
+			continue
+		}
+		merged.List = append(merged.List, part.List...)
+		merged.Total += part.Total
+	}
+	slices.SortFunc(merged.List, func(a, b Anomaly) int {
+		return cmp.Or(cmp.Compare(a.Offset, b.Offset), strings.Compare(a.Station, b.Station), cmp.Compare(a.Value, b.Value))
+	})
+	if limit > 0 && len(merged.List) > limit {
+		merged.List = merged.List[:limit]
+	}
+	return merged
+}
+
+// Write writes the anomalies as station;value;offset;reason lines
+func (a *Anomalies) Write(w io.Writer) error {
+	bw := bufio.NewWriter(w)
+	var buf []byte
+	for _, anomaly := range a.List {
+		buf = append(buf[:0], anomaly.Station...)
+		buf = append(buf, ';')
+		buf = format.AppendTenths(buf, int64(anomaly.Value))
+		buf = append(buf, ';')
+		buf = strconv.AppendInt(buf, anomaly.Offset, 10)
+		buf = append(buf, ';')
+		buf = append(buf, anomaly.Reason...)
+		buf = append(buf, '\n')
+		if _, err := bw.Write(buf); err != nil {
+			return err
+		}
+	}
+	return bw.Flush()
+}
+
+// AnomalyDetector flags the measurements outside of the Bounds of their
+// station, or more than K standard deviations away from its running mean.
+//
+// The running mean and deviation are the ones of the lines the worker has
+// seen so far, not of the whole input: with several workers, which lines are
+// flagged depends on how the chunks were dealt. Flagged measurements are
+// still aggregated and update the running mean.
+type AnomalyDetector struct {
+	// K is the number of standard deviations, 0 disables the check
+	K float64
+	// MinSamples is the number of measurements of a station seen by the
+	// worker before its deviation is checked
+	MinSamples int64
+	// Bounds by station name, in tenths of degrees
+	Bounds map[string]Bounds
+	// Limit is the number of anomalies kept by each worker, the others are
+	// only counted. 0 keeps everything.
+	Limit int
+	// Offsets locates the chunks in the input, usually the chunker itself.
+	// The getters given to the workers can be wrappers hiding it.
+	Offsets ChunkOffsets
+}
+
+// Worker is ParseWorkerHash with xxh3, also keeping a Welford accumulator
+// and the bounds of each station to report its anomalies. The checks are only
+// done for the stations kept by the filter.
+func (d *AnomalyDetector) Worker(chunker ChunkGetter, filter *Filter) ([]StationInt16, *Anomalies) {
+	stationTable := newStationTable()
+	// by slot, next to the table so StationInt16 keeps its size
+	running := make([]agg.Welford, len(stationTable))
+	bounds := make([]*Bounds, len(stationTable))
+	k2 := d.K * d.K
+
+	found := &Anomalies{}
+	var broadcastedDelim uint64 = 0x3b3b3b3b3b3b3b3b
+
+	for {
+		chunk := chunker.NextChunk()
+		if chunk == nil {
+			break
+		}
+
+		chunkOffset := int64(-1)
+		if d.Offsets != nil {
+			chunkOffset = d.Offsets.ChunkOffset(*chunk)
+		}
+
+		startpos := 0
+		chunklen := len(*chunk)
+		chunkp := unsafe.Pointer(unsafe.SliceData(*chunk))
+		for startpos < chunklen {
+			linestart := startpos
+			p := unsafe.Add(chunkp, startpos)
+			delim := indexBytePointerUnsafe8Bytes(p, 32, ';', broadcastedDelim)
+			slot := stationSlot(stationTable, hashName(p, delim), p, delim, filter)
+			station := &stationTable[slot]
+			if station.N == 0 {
+				if b, ok := d.Bounds[string(station.Name)]; ok {
+					bounds[slot] = &b
+				}
+			}
+
+			startpos += delim + 1
+			m, l := ParseTemperature(unsafe.Add(chunkp, startpos))
+			if filter == nil {
+				station.NewMeasurement(m)
+			} else {
+				station.Add(m)
+			}
+			startpos += l
+
+			if station.Excluded {
+				continue
+			}
+			reason := ""
+			if b := bounds[slot]; b != nil && (m < b.Min || m > b.Max) {
+				reason = AnomalyBounds
+			}
+			w := &running[slot]
+			if reason == "" && k2 > 0 && w.N >= d.MinSamples {
+				// squared, to save a sqrt per line
+				diff := float64(m) - w.Mean
+				if diff*diff > k2*w.Variance() {
+					reason = AnomalyStddev
+				}
+			}
+			w.Add(float64(m))
+			if reason != "" {
+				offset := int64(-1)
+				if chunkOffset >= 0 {
+					offset = chunkOffset + int64(linestart)
+				}
+				found.add(Anomaly{Station: string(station.Name), Value: m, Offset: offset, Reason: reason}, d.Limit)
+			}
+		}
+
+		chunker.ReleaseChunk(chunk)
+	}
+
+	return stationTable, found
+}
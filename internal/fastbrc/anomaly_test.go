@@ -0,0 +1,125 @@
+package fastbrc
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// noRelease serves the chunks of an in memory chunker without the madvise of
+// ReleaseChunk, which zeroes heap memory
+type noRelease struct {
+	ChunkGetter
+}
+
+func (noRelease) ReleaseChunk(*[]byte) {}
+
+func TestLoadBounds(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "bounds")
+	require.NoError(t, os.WriteFile(filename, []byte("# name;min;max\nHamburg;-30.0;40.0\nAbha; -5 ;45.5\n"), 0o644))
+	bounds, err := LoadBounds(filename)
+	require.NoError(t, err)
+	assert.Equal(t, map[string]Bounds{"Hamburg": {Min: -300, Max: 400}, "Abha": {Min: -50, Max: 455}}, bounds)
+
+	for _, content := range []string{"Hamburg;1.0\n", "Hamburg;a;1.0\n", "Hamburg;2.0;1.0\n", "Hamburg;0;99999\n"} {
+		require.NoError(t, os.WriteFile(filename, []byte(content), 0o644))
+		_, err := LoadBounds(filename)
+		assert.Error(t, err, content)
+	}
+	_, err = LoadBounds(filepath.Join(t.TempDir(), "missing"))
+	assert.Error(t, err)
+}
+
+func TestChunkOffsets(t *testing.T) {
+	data := slices.Clip(generateMeasurements(1000))
+
+	bc := NewByteChunker(data, 1000, 512)
+	require.NoError(t, bc.Run())
+	ac := NewAtomicChunker(data, 512)
+	rc := NewRegionChunker(data, []int{1, 2}, 512)
+
+	for name, c := range map[string]interface {
+		ChunkGetter
+		ChunkOffsets
+	}{"byte": bc, "atomic": ac, "region": rc} {
+		copies, total := 0, 0
+		for chunk := c.NextChunk(); chunk != nil; chunk = c.NextChunk() {
+			offset := c.ChunkOffset(*chunk)
+			require.GreaterOrEqual(t, offset, int64(0), name)
+			assert.Equal(t, data[offset:offset+int64(len(*chunk))], *chunk, name)
+			if !within(data, *chunk) {
+				copies++
+			}
+			total += len(*chunk)
+		}
+		assert.Equal(t, len(data), total, name)
+		// the input has no room for the pad, the last chunk is copied
+		assert.Positive(t, copies, name)
+		assert.Equal(t, int64(-1), c.ChunkOffset([]byte("Abha;1.0\n")), name)
+	}
+}
+
+func TestAnomalyDetector(t *testing.T) {
+	var b strings.Builder
+	for i := range 100 {
+		fmt.Fprintf(&b, "Abha;10.%d\n", i%2*2)
+		fmt.Fprintf(&b, "Oslo;%d.0\n", i%4)
+		fmt.Fprintf(&b, "Skipped;%d.0\n", i%2*50)
+		if i == 60 {
+			b.WriteString("Abha;30.0\n")
+			b.WriteString("Oslo;-7.5\n")
+		}
+	}
+	data := []byte(b.String())
+
+	d := &AnomalyDetector{
+		K:          4,
+		MinSamples: 10,
+		Bounds:     map[string]Bounds{"Oslo": {Min: -50, Max: 50}, "Skipped": {Min: 0, Max: 1}},
+	}
+	filter := &Filter{}
+	require.NoError(t, filter.Exclude("Skipped"))
+
+	c := NewAtomicChunker(data, 256)
+	d.Offsets = c
+	table, found := d.Worker(noRelease{c}, filter)
+
+	assert.Equal(t, int64(2), found.Total)
+	assert.Equal(t, []Anomaly{
+		{Station: "Abha", Value: 300, Offset: int64(bytes.Index(data, []byte("Abha;30.0"))), Reason: AnomalyStddev},
+		{Station: "Oslo", Value: -75, Offset: int64(bytes.Index(data, []byte("Oslo;-7.5"))), Reason: AnomalyBounds},
+	}, found.List)
+
+	// the aggregates are the ones of ParseWorkerHash
+	expected := ParseWorkerHash(hashName)(newSliceChunker(data, 256), filter)
+	assert.Equal(t, stationsByName(expected), stationsByName(table))
+
+	// without offsets and with a limit
+	d.Offsets = nil
+	d.Limit = 1
+	_, found = d.Worker(newSliceChunker(data, 256), filter)
+	assert.Equal(t, int64(2), found.Total)
+	assert.Equal(t, []Anomaly{{Station: "Abha", Value: 300, Offset: -1, Reason: AnomalyStddev}}, found.List)
+}
+
+func TestMergeAnomalies(t *testing.T) {
+	a := &Anomalies{List: []Anomaly{{Station: "Abha", Offset: 30}, {Station: "Abha", Offset: 10}}, Total: 5}
+	b := &Anomalies{List: []Anomaly{{Station: "Oslo", Offset: 20}}, Total: 1}
+
+	merged := MergeAnomalies([]*Anomalies{a, nil, b}, 2)
+	assert.Equal(t, int64(6), merged.Total)
+	assert.Equal(t, []Anomaly{{Station: "Abha", Offset: 10}, {Station: "Oslo", Offset: 20}}, merged.List)
+
+	var out bytes.Buffer
+	merged.List[0].Value = -15
+	merged.List[0].Reason = AnomalyStddev
+	require.NoError(t, merged.Write(&out))
+	assert.Equal(t, "Abha;-1.5;10;stddev\nOslo;0.0;20;\n", out.String())
+}
@@ -0,0 +1,424 @@
+package fastbrc
+
+import (
+	"bufio"
+	"cmp"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"sync"
+	"sync/atomic"
+	"unsafe"
+)
+
+// A .brcb file is the measurements in binary, so repeated runs over the same
+// rows skip the text parsing. All integers are little endian:
+//
+//	"BRCB", version uint32
+//	blocks, 8 bytes aligned: rows uint32, 4 zero bytes, station ids
+//	  [rows]uint16, measurements in tenths of degrees [rows]int16
+//	index: offset of each block [blocks]uint64
+//	dictionary: name of each station id, length uint16 then the bytes, ids
+//	  are given in the order of the first row of each station
+//	trailer: index offset uint64, blocks uint32, stations uint32, "BRCB"
+//
+// A block is a chunk of the text input and the blocks are in the order of
+// the input, so are the rows.
+const (
+	brcbMagic   = "BRCB"
+	brcbVersion = 1
+	// brcbTrailer is the size of the trailer
+	brcbTrailer = 20
+	// BRCBMaxStations is the number of station ids
+	BRCBMaxStations = 1 << 16
+)
+
+// ConvertStats counts what ConvertBRCB wrote
+type ConvertStats struct {
+	Rows     int64
+	Blocks   int
+	Stations int
+	// Bytes is the size of the .brcb file
+	Bytes int64
+}
+
+// brcbDictionary assigns the station ids in the order of the first row of
+// each station in the input, so the file doesn't depend on how the chunks
+// were shared by the workers. The workers give their own ids to the stations
+// they find, the writer maps them to the ids of the dictionary.
+type brcbDictionary struct {
+	names  []string
+	byName map[string]int32
+	// per worker, the id of each of its ids, -1 when not assigned yet
+	ids [][]int32
+}
+
+// remap replaces the ids of worker in ids, the station ids of a block, by
+// the ids of the dictionary. names are the stations of the worker.
+func (d *brcbDictionary) remap(worker int, names []string, ids []byte) error {
+	for len(d.ids[worker]) < len(names) {
+		d.ids[worker] = append(d.ids[worker], -1)
+	}
+	m := d.ids[worker]
+	for i := 0; i < len(ids); i += 2 {
+		local := binary.LittleEndian.Uint16(ids[i:])
+		id := m[local]
+		if id < 0 {
+			var ok bool
+			if id, ok = d.byName[names[local]]; !ok {
+				if len(d.names) == BRCBMaxStations {
+					return fmt.Errorf("more than %d stations", BRCBMaxStations)
+				}
+				id = int32(len(d.names))
+				d.byName[names[local]] = id
+				d.names = append(d.names, names[local])
+			}
+			m[local] = id
+		}
+		binary.LittleEndian.PutUint16(ids[i:], uint16(id))
+	}
+	return nil
+}
+
+// brcbSlot caches the id of a station in the table of a worker
+type brcbSlot struct {
+	name []byte
+	id   uint16
+}
+
+// brcbBlock is a block encoded by a worker, from the chunk at offset, with
+// the ids of the worker
+type brcbBlock struct {
+	offset int64
+	size   int
+	data   []byte
+	rows   int
+	err    error
+	worker int
+	// names of the ids of the worker, when the block was encoded
+	names []string
+}
+
+// ConvertBRCB encodes the chunks of chunker to w in the .brcb format. The
+// chunks must cover the input from its start, offsets locates them so the
+// blocks are written in order. nworkers encode the chunks in parallel.
+func ConvertBRCB(w io.Writer, chunker ChunkGetter, offsets ChunkOffsets, nworkers int) (ConvertStats, error) {
+	nworkers = max(nworkers, 1)
+	dict := &brcbDictionary{byName: make(map[string]int32), ids: make([][]int32, nworkers)}
+	blocks := make(chan brcbBlock, nworkers)
+	// tokens bound the blocks waiting for the ones before them, a worker
+	// takes one before a chunk and the writer gives it back
+	tokens := make(chan struct{}, 4*nworkers)
+	var failed atomic.Bool
+
+	wg := sync.WaitGroup{}
+	wg.Add(nworkers)
+	for w := range nworkers {
+		go func() {
+			defer wg.Done()
+			table := make([]brcbSlot, 2*BRCBMaxStations)
+			// the ids of the worker, only appended to so the blocks can
+			// share it
+			var names []string
+			var ids, values []byte
+			for !failed.Load() {
+				tokens <- struct{}{}
+				chunk := chunker.NextChunk()
+				if chunk == nil {
+					<-tokens
+					return
+				}
+				b := brcbBlock{offset: offsets.ChunkOffset(*chunk), size: len(*chunk), worker: w}
+				if b.offset < 0 {
+					b.err = fmt.Errorf("chunk offsets are unknown")
+				} else {
+					ids, values = ids[:0], values[:0]
+					ids, values, names, b.err = encodeBRCB(*chunk, table, names, ids, values)
+					b.names = names
+					b.rows = len(ids) / 2
+					b.data = binary.LittleEndian.AppendUint32(make([]byte, 0, 8+len(ids)+len(values)+8), uint32(b.rows))
+					b.data = append(append(append(b.data, 0, 0, 0, 0), ids...), values...)
+					b.data = append(b.data, make([]byte, (8-len(b.data)%8)%8)...)
+				}
+				chunker.ReleaseChunk(chunk)
+				if b.err != nil {
+					failed.Store(true)
+				}
+				blocks <- b
+			}
+		}()
+	}
+	go func() {
+		wg.Wait()
+		close(blocks)
+	}()
+
+	var stats ConvertStats
+	bw := bufio.NewWriterSize(w, 1024*1024)
+	var err error
+	write := func(p []byte) {
+		if err == nil {
+			_, err = bw.Write(p)
+			stats.Bytes += int64(len(p))
+		}
+	}
+	write(binary.LittleEndian.AppendUint32([]byte(brcbMagic), brcbVersion))
+
+	var index []byte
+	var next int64
+	pending := make(map[int64]brcbBlock)
+	for b := range blocks {
+		err = cmp.Or(err, b.err)
+		if err != nil {
+			// the workers stop before their next chunk, the tokens of the
+			// blocks are given back so none waits for one
+			for range len(pending) + 1 {
+				<-tokens
+			}
+			clear(pending)
+			continue
+		}
+		pending[b.offset] = b
+		for {
+			b, ok := pending[next]
+			if !ok {
+				break
+			}
+			delete(pending, next)
+			if err = dict.remap(b.worker, b.names, b.data[8:8+2*b.rows]); err != nil {
+				failed.Store(true)
+				for range len(pending) + 1 {
+					<-tokens
+				}
+				clear(pending)
+				break
+			}
+			index = binary.LittleEndian.AppendUint64(index, uint64(stats.Bytes))
+			write(b.data)
+			stats.Rows += int64(b.rows)
+			stats.Blocks++
+			next += int64(b.size)
+			<-tokens
+		}
+		if len(pending) == cap(tokens) {
+			// the chunk at next would hold a token
+			err = fmt.Errorf("chunks don't cover the input, none at offset %d", next)
+			failed.Store(true)
+			for range len(pending) {
+				<-tokens
+			}
+			clear(pending)
+		}
+	}
+	if err != nil {
+		return stats, err
+	}
+	if len(pending) > 0 {
+		return stats, fmt.Errorf("chunks don't cover the input, none at offset %d", next)
+	}
+
+	indexOffset := stats.Bytes
+	write(index)
+	var names []byte
+	for _, name := range dict.names {
+		names = binary.LittleEndian.AppendUint16(names, uint16(len(name)))
+		names = append(names, name...)
+	}
+	write(names)
+	trailer := binary.LittleEndian.AppendUint64(nil, uint64(indexOffset))
+	trailer = binary.LittleEndian.AppendUint32(trailer, uint32(stats.Blocks))
+	trailer = binary.LittleEndian.AppendUint32(trailer, uint32(len(dict.names)))
+	write(append(trailer, brcbMagic...))
+	if err == nil {
+		err = bw.Flush()
+	}
+	stats.Stations = len(dict.names)
+	return stats, err
+}
+
+// encodeBRCB appends the station ids and measurements of the lines of chunk
+// to ids and values. table caches the ids of the stations, like the tables
+// of ParseWorkerHash, names are the stations by id, new ones are appended.
+func encodeBRCB(chunk []byte, table []brcbSlot, names []string, ids, values []byte) ([]byte, []byte, []string, error) {
+	var broadcastedDelim uint64 = 0x3b3b3b3b3b3b3b3b
+	tableLen := uint64(len(table))
+
+	startpos := 0
+	chunklen := len(chunk)
+	chunkp := unsafe.Pointer(unsafe.SliceData(chunk))
+	for startpos < chunklen {
+		p := unsafe.Add(chunkp, startpos)
+		delim := indexBytePointerUnsafe8Bytes(p, 32, ';', broadcastedDelim)
+		if delim < 0 {
+			return ids, values, names, fmt.Errorf("line without a ';' in the first 32 bytes: %.40q", chunk[startpos:])
+		}
+
+		slot := hashName(p, delim) % tableLen
+		s := &table[slot]
+		for s.name != nil && !nameEqual(s.name, p, delim) {
+			slot++
+			if slot == tableLen {
+				slot = 0
+			}
+			s = &table[slot]
+		}
+		if s.name == nil {
+			if len(names) == BRCBMaxStations {
+				return ids, values, names, fmt.Errorf("more than %d stations", BRCBMaxStations)
+			}
+			name := unsafe.Slice((*byte)(p), delim)
+			// zero padded for nameEqual
+			s.name = append(make([]byte, 0, max(delim, 16)), name...)
+			s.id = uint16(len(names))
+			names = append(names, string(name))
+		}
+
+		startpos += delim + 1
+		m, l := ParseTemperature(unsafe.Add(chunkp, startpos))
+		startpos += l
+		ids = binary.LittleEndian.AppendUint16(ids, s.id)
+		values = binary.LittleEndian.AppendUint16(values, uint16(m))
+	}
+	return ids, values, names, nil
+}
+
+// BRCBChunker serves the blocks of a .brcb file, workers claim them with an
+// atomic index like AtomicChunker. See BRCBChunker.Worker.
+type BRCBChunker struct {
+	b      []byte
+	blocks [][]byte
+	next   atomic.Int64
+	// Names are the stations by id
+	Names []string
+}
+
+// NewBRCBChunker checks the .brcb file b, usually mmaped, and returns a
+// chunker of its blocks
+func NewBRCBChunker(b []byte) (*BRCBChunker, error) {
+	if len(b) < 8+brcbTrailer || string(b[:4]) != brcbMagic || string(b[len(b)-4:]) != brcbMagic {
+		return nil, fmt.Errorf("not a .brcb file")
+	}
+	if v := binary.LittleEndian.Uint32(b[4:]); v != brcbVersion {
+		return nil, fmt.Errorf("unsupported .brcb version %d", v)
+	}
+	trailer := b[len(b)-brcbTrailer:]
+	indexOffset := binary.LittleEndian.Uint64(trailer)
+	nblocks := uint64(binary.LittleEndian.Uint32(trailer[8:]))
+	nstations := int(binary.LittleEndian.Uint32(trailer[12:]))
+	dictEnd := uint64(len(b) - brcbTrailer)
+	if indexOffset < 8 || indexOffset > dictEnd || nblocks > (dictEnd-indexOffset)/8 {
+		return nil, fmt.Errorf(".brcb index out of the file")
+	}
+	if nstations > BRCBMaxStations {
+		return nil, fmt.Errorf(".brcb dictionary of %d stations, more than %d", nstations, BRCBMaxStations)
+	}
+
+	c := &BRCBChunker{b: b, blocks: make([][]byte, nblocks)}
+	for i := range c.blocks {
+		offset := binary.LittleEndian.Uint64(b[indexOffset+8*uint64(i):])
+		if offset%8 != 0 || offset < 8 || offset > indexOffset-8 {
+			return nil, fmt.Errorf(".brcb block %d out of the file", i)
+		}
+		rows := uint64(binary.LittleEndian.Uint32(b[offset:]))
+		if rows > (indexOffset-offset-8)/4 {
+			return nil, fmt.Errorf(".brcb block %d of %d rows out of the file", i, rows)
+		}
+		c.blocks[i] = b[offset : offset+8+4*rows]
+	}
+
+	dict := b[indexOffset+8*nblocks : dictEnd]
+	for range nstations {
+		if len(dict) < 2 || int(binary.LittleEndian.Uint16(dict)) > len(dict)-2 {
+			return nil, fmt.Errorf(".brcb dictionary out of the file")
+		}
+		n := int(binary.LittleEndian.Uint16(dict))
+		c.Names = append(c.Names, string(dict[2:2+n]))
+		dict = dict[2+n:]
+	}
+	return c, nil
+}
+
+// Rows returns the number of rows of the file
+func (c *BRCBChunker) Rows() int64 {
+	var rows int64
+	for _, block := range c.blocks {
+		rows += c.ChunkRows(block)
+	}
+	return rows
+}
+
+// ChunkRows returns the number of rows of a block, see Progress
+func (c *BRCBChunker) ChunkRows(chunk []byte) int64 {
+	return int64(binary.LittleEndian.Uint32(chunk))
+}
+
+func (c *BRCBChunker) NextChunk() *[]byte {
+	i := c.next.Add(1) - 1
+	if i >= int64(len(c.blocks)) {
+		return nil
+	}
+	return &c.blocks[i]
+}
+
+// ReleaseChunk calls madvise(2) with MADV_DONTNEED, see ByteChunker.ReleaseChunk
+func (c *BRCBChunker) ReleaseChunk(chunk *[]byte) {
+	madviseDontNeed(c.b, *chunk)
+}
+
+// Run is a noop, see AtomicChunker.Run
+func (c *BRCBChunker) Run() error {
+	return nil
+}
+
+// Worker aggregates the blocks of chunker, a getter of c. The table is
+// indexed by station id: the ids are uint16 so there are no bounds checks
+// and no hashing, the stations without rows are left without a name. Ids out
+// of the dictionary, from a corrupted file, are an error once all the blocks
+// are read.
+func (c *BRCBChunker) Worker(chunker ChunkGetter, filter *Filter) ([]StationInt16, error) {
+	stationTable := make([]StationInt16, BRCBMaxStations)
+	for i := range stationTable {
+		stationTable[i].Min = 32767
+		stationTable[i].Max = -32767
+	}
+	for id, name := range c.Names {
+		stationTable[id].Excluded = filter.Excluded([]byte(name))
+	}
+	table := (*[BRCBMaxStations]StationInt16)(stationTable)
+
+	for {
+		chunk := chunker.NextChunk()
+		if chunk == nil {
+			break
+		}
+
+		rows := int(binary.LittleEndian.Uint32(*chunk))
+		// the blocks are 8 bytes aligned in the file
+		p := unsafe.Pointer(unsafe.SliceData(*chunk))
+		ids := unsafe.Slice((*uint16)(unsafe.Add(p, 8)), rows)
+		values := unsafe.Slice((*int16)(unsafe.Add(p, 8+2*rows)), rows)
+		if filter == nil {
+			for i, id := range ids {
+				table[id].NewMeasurement(values[i])
+			}
+		} else {
+			for i, id := range ids {
+				table[id].Add(values[i])
+			}
+		}
+
+		chunker.ReleaseChunk(chunk)
+	}
+
+	for id := range stationTable {
+		s := &stationTable[id]
+		if s.N == 0 {
+			continue
+		}
+		if id >= len(c.Names) {
+			return nil, fmt.Errorf(".brcb station id %d out of the dictionary of %d stations", id, len(c.Names))
+		}
+		s.Name = []byte(c.Names[id])
+	}
+	return stationTable, nil
+}
@@ -0,0 +1,197 @@
+package fastbrc
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"math/rand/v2"
+	"os"
+	"path/filepath"
+	"slices"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/sys/unix"
+
+	"1brc/internal/agg"
+	"1brc/internal/format"
+)
+
+// convertBRCB converts data with 3 workers and returns the .brcb file
+func convertBRCB(t *testing.T, data []byte, chunkSize int) ([]byte, ConvertStats) {
+	c := NewAtomicChunker(data, chunkSize)
+	var b bytes.Buffer
+	stats, err := ConvertBRCB(&b, noRelease{c}, c, 3)
+	require.NoError(t, err)
+	assert.Equal(t, int64(b.Len()), stats.Bytes)
+	return b.Bytes(), stats
+}
+
+func TestBRCB(t *testing.T) {
+	data := slices.Clip(generateMeasurements(100000))
+	brcb, stats := convertBRCB(t, data, 4096)
+	assert.Equal(t, int64(100000), stats.Rows)
+	assert.Equal(t, 8, stats.Stations)
+
+	// the rows are the lines, in order
+	c, err := NewBRCBChunker(brcb)
+	require.NoError(t, err)
+	assert.Equal(t, stats.Rows, c.Rows())
+	assert.Len(t, c.blocks, stats.Blocks)
+	var text []byte
+	for chunk := c.NextChunk(); chunk != nil; chunk = c.NextChunk() {
+		rows := int(binary.LittleEndian.Uint32(*chunk))
+		for i := range rows {
+			id := binary.LittleEndian.Uint16((*chunk)[8+2*i:])
+			m := int16(binary.LittleEndian.Uint16((*chunk)[8+2*rows+2*i:]))
+			text = fmt.Appendf(text, "%s;%s\n", c.Names[id], format.Tenths(int64(m)))
+		}
+	}
+	assert.Equal(t, string(data), string(text))
+
+	// aggregated by concurrent workers from the mmaped file
+	filename := filepath.Join(t.TempDir(), "measurements.brcb")
+	require.NoError(t, os.WriteFile(filename, brcb, 0o644))
+	mmaped, err := MmapFile(filename, InputWillNeed)
+	require.NoError(t, err)
+	defer unix.Munmap(mmaped)
+	c, err = NewBRCBChunker(mmaped)
+	require.NoError(t, err)
+	tables := make([][]StationInt16, 3)
+	wg := sync.WaitGroup{}
+	for i := range tables {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			var err error
+			tables[i], err = c.Worker(c, nil)
+			assert.NoError(t, err)
+		}()
+	}
+	wg.Wait()
+	got := agg.NewResults()
+	for _, table := range tables {
+		got.MergeTable(table)
+	}
+	expected := agg.NewResults()
+	expected.MergeTable(ParseWorker(newSliceChunker(data, 4096)))
+	assert.Equal(t, expected, got)
+}
+
+func TestBRCBDeterministic(t *testing.T) {
+	data := slices.Clip(generateMeasurements(100000))
+	convert := func(nworkers int) []byte {
+		c := NewAtomicChunker(data, 1024)
+		var b bytes.Buffer
+		_, err := ConvertBRCB(&b, noRelease{c}, c, nworkers)
+		require.NoError(t, err)
+		return b.Bytes()
+	}
+	expected := convert(1)
+	for range 5 {
+		assert.Equal(t, expected, convert(8))
+	}
+	// ids in the order of the first line of each station
+	c, err := NewBRCBChunker(expected)
+	require.NoError(t, err)
+	var names []string
+	for _, line := range bytes.Split(data, []byte{'\n'}) {
+		if name, _, ok := bytes.Cut(line, []byte{';'}); ok && !slices.Contains(names, string(name)) {
+			names = append(names, string(name))
+		}
+	}
+	assert.Equal(t, names, c.Names)
+}
+
+func TestBRCBFilter(t *testing.T) {
+	data := slices.Clip(generateMeasurements(10000))
+	brcb, _ := convertBRCB(t, data, 4096)
+	c, err := NewBRCBChunker(brcb)
+	require.NoError(t, err)
+
+	filter := &Filter{}
+	require.NoError(t, filter.Exclude("prefix:A"))
+	expected := stationsByName(ParseWorkerFilter(newSliceChunker(data, 4096), filter))
+	table, err := c.Worker(noRelease{c}, filter)
+	require.NoError(t, err)
+	assert.Equal(t, expected, stationsByName(table))
+}
+
+func TestConvertBRCBErrors(t *testing.T) {
+	var b bytes.Buffer
+	// more stations than ids
+	var data []byte
+	for i := range BRCBMaxStations + 1 {
+		data = fmt.Appendf(data, "s%d;1.0\n", i)
+	}
+	c := NewAtomicChunker(slices.Clip(data), 4096)
+	_, err := ConvertBRCB(&b, noRelease{c}, c, 3)
+	assert.ErrorContains(t, err, "stations")
+
+	data = slices.Clip(generateMeasurements(10000))
+	// chunks missing from the input
+	c = NewAtomicChunker(data, 1024)
+	c.NextChunk()
+	_, err = ConvertBRCB(&b, noRelease{c}, c, 3)
+	assert.ErrorContains(t, err, "don't cover")
+
+	// getters hiding the chunker
+	_, err = ConvertBRCB(&b, newSliceChunker(data, 1024), noOffsets{}, 3)
+	assert.ErrorContains(t, err, "offsets")
+}
+
+// noOffsets doesn't know any chunk
+type noOffsets struct{}
+
+func (noOffsets) ChunkOffset([]byte) int64 { return -1 }
+
+func TestNewBRCBChunkerErrors(t *testing.T) {
+	brcb, _ := convertBRCB(t, slices.Clip(generateMeasurements(1000)), 1024)
+	_, err := NewBRCBChunker(brcb)
+	require.NoError(t, err)
+
+	for _, n := range []int{0, 8, len(brcb) / 2, len(brcb) - 1} {
+		_, err := NewBRCBChunker(brcb[:n])
+		assert.Error(t, err, "truncated to %d", n)
+	}
+	corrupt := func(offset int, v uint64) []byte {
+		b := bytes.Clone(brcb)
+		binary.LittleEndian.PutUint64(b[offset:], v)
+		return b
+	}
+	indexOffset := int(binary.LittleEndian.Uint64(brcb[len(brcb)-brcbTrailer:]))
+	for name, b := range map[string][]byte{
+		"version":      corrupt(4, 2),
+		"index offset": corrupt(len(brcb)-brcbTrailer, uint64(len(brcb))),
+		"block offset": corrupt(indexOffset, uint64(indexOffset)),
+		"unaligned":    corrupt(indexOffset, 12),
+		"rows":         corrupt(8, 1<<31),
+	} {
+		_, err := NewBRCBChunker(b)
+		assert.Error(t, err, name)
+	}
+
+	// random corruptions are errors or wrong values, never a panic
+	r := rand.New(rand.NewPCG(5, 6))
+	for range 200 {
+		b := bytes.Clone(brcb)
+		for range 5 {
+			b[r.IntN(len(b))] = byte(r.Uint32())
+		}
+		c, err := NewBRCBChunker(b)
+		if err != nil {
+			continue
+		}
+		assert.NotPanics(t, func() { c.Worker(noRelease{c}, nil) })
+	}
+
+	// ids out of the dictionary
+	b := bytes.Clone(brcb)
+	binary.LittleEndian.PutUint16(b[8+8:], 60000)
+	c, err := NewBRCBChunker(b)
+	require.NoError(t, err)
+	_, err = c.Worker(noRelease{c}, nil)
+	assert.ErrorContains(t, err, "out of the dictionary")
+}
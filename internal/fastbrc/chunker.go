@@ -5,7 +5,9 @@ import (
 	"fmt"
 	"io"
 	"log"
+	"slices"
 	"sync"
+	"sync/atomic"
 	"syscall"
 	"unsafe"
 
@@ -14,6 +16,61 @@ import (
 
 var pagesize = syscall.Getpagesize()
 
+// chunkPad is the number of bytes past the end of a chunk the workers are
+// allowed to read. ParseWorker looks for ';' 32 bytes at a time and parses
+// measurements with 8 bytes loads, the chunkers make sure these reads stay
+// inside the buffer or mapping the chunk comes from.
+const chunkPad = 32
+
+// padded returns b[start:end] when at least chunkPad bytes after end are part
+// of b (up to its cap) and a copy followed by a zeroed pad otherwise, i.e: for
+// the last chunk of a mmaped file ending right before a page boundary.
+func padded(b []byte, start, end int) []byte {
+	if end+chunkPad <= cap(b) {
+		return b[start:end]
+	}
+	chunk := make([]byte, end-start, end-start+chunkPad)
+	copy(chunk, b[start:end])
+	return chunk
+}
+
+// ChunkOffsets is implemented by the chunkers serving chunks of an input in
+// memory, it locates a chunk in the input for the side outputs that report
+// byte offsets, see AnomalyDetector.
+type ChunkOffsets interface {
+	// ChunkOffset returns the position of the chunk in the input, -1 when
+	// the chunk doesn't come from it
+	ChunkOffset(chunk []byte) int64
+}
+
+// copyOffsets remembers where the copies made by padded come from, the other
+// chunks are located in the input directly
+type copyOffsets struct {
+	m sync.Map // *byte -> int
+}
+
+// padded is padded for the chunkers implementing ChunkOffsets
+func (o *copyOffsets) padded(b []byte, start, end int) []byte {
+	chunk := padded(b, start, end)
+	if len(chunk) > 0 && !within(b, chunk) {
+		o.m.Store(unsafe.SliceData(chunk), start)
+	}
+	return chunk
+}
+
+func (o *copyOffsets) offset(b, chunk []byte) int64 {
+	if len(chunk) == 0 {
+		return -1
+	}
+	if within(b, chunk) {
+		return int64(offsetIn(b, chunk))
+	}
+	if start, ok := o.m.Load(unsafe.SliceData(chunk)); ok {
+		return int64(start.(int))
+	}
+	return -1
+}
+
 type Chunker struct {
 	r       io.Reader
 	p       sync.Pool
@@ -26,7 +83,7 @@ func NewChunker(r io.Reader, chCap, chunkSize int) *Chunker {
 		chunkCh: make(chan *[]byte, chCap),
 		p: sync.Pool{
 			New: func() any {
-				b := make([]byte, 0, chunkSize)
+				b := make([]byte, 0, chunkSize+chunkPad)
 				return &b
 			},
 		},
@@ -43,6 +100,11 @@ func (c *Chunker) ReleaseChunk(chunk *[]byte) {
 	c.p.Put(chunk)
 }
 
+// Queued returns the number of chunks waiting in the channel, see Progress
+func (c *Chunker) Queued() (n, capacity int) {
+	return len(c.chunkCh), cap(c.chunkCh)
+}
+
 func (c *Chunker) NextChunk() *[]byte {
 	return <-c.chunkCh
 }
@@ -51,10 +113,11 @@ func (c *Chunker) Run() error {
 	leftovers := make([]byte, 0, 256)
 	for {
 		chunk := c.getChunk()
-		*chunk = append(*chunk, leftovers...) // leftovers at beginning of chunk
-		currentReadStartPos := len(leftovers) // keep ref for calculations
-		leftovers = leftovers[:0]             // reset
-		*chunk = (*chunk)[:cap(*chunk)]       // extend to use all cap
+		*chunk = slices.Grow(*chunk, len(leftovers)+1+chunkPad) // room for leftovers, at least 1 byte to read and the pad
+		*chunk = append(*chunk, leftovers...)                   // leftovers at beginning of chunk
+		currentReadStartPos := len(leftovers)                   // keep ref for calculations
+		leftovers = leftovers[:0]                               // reset
+		*chunk = (*chunk)[:cap(*chunk)-chunkPad]                // extend to use all cap but the pad
 
 		n, err := c.r.Read((*chunk)[currentReadStartPos:])
 		// log.Printf("n: %d, err: %v, currentReadStartPos: %d", n, err, currentReadStartPos)
@@ -97,6 +160,15 @@ type ByteChunker struct {
 	b         []byte
 	chunkCh   chan *[]byte
 	chunkSize int
+	copies    copyOffsets
+	// ranges are the parts of b served, nil means all of it
+	ranges []Range
+}
+
+// Range is the part of an input from Start to End, both at the start of a
+// line
+type Range struct {
+	Start, End int
 }
 
 func NewByteChunker(input []byte, chCap, chunkSize int) *ByteChunker {
@@ -107,32 +179,67 @@ func NewByteChunker(input []byte, chCap, chunkSize int) *ByteChunker {
 	}
 }
 
+// NewByteChunkerRanges is a ByteChunker only serving the ranges of input, in
+// chunks of up to chunkSize bytes. See StationIndex.
+func NewByteChunkerRanges(input []byte, chCap, chunkSize int, ranges []Range) *ByteChunker {
+	c := NewByteChunker(input, chCap, chunkSize)
+	c.ranges = append([]Range{}, ranges...)
+	return c
+}
+
 // Align a pointer address to the nearest lower page boundary
 func alignToPage(ptr uintptr, pageSize int) uintptr {
 	return ptr & ^(uintptr(pageSize - 1))
 }
 
+// offsetIn returns the position of sub in b, sub must be a subslice of b
+func offsetIn(b, sub []byte) int {
+	return int(uintptr(unsafe.Pointer(unsafe.SliceData(sub))) - uintptr(unsafe.Pointer(unsafe.SliceData(b))))
+}
+
+// within reports whether sub points inside b, chunks copied by padded don't
+func within(b, sub []byte) bool {
+	start := uintptr(unsafe.Pointer(unsafe.SliceData(b)))
+	p := uintptr(unsafe.Pointer(unsafe.SliceData(sub)))
+	return p >= start && p < start+uintptr(len(b))
+}
+
 // ReleaseChunk calls madvise(2) with MADV_DONTNEED so the kernel can cleanup
 // this avoids the implicit munmap when the program exits which can take ~230ms
 // when the mmaped file is 13gb
 func (c *ByteChunker) ReleaseChunk(chunk *[]byte) {
+	madviseDontNeed(c.b, *chunk)
+}
+
+// madviseDontNeed applies MADV_DONTNEED to the pages of b covered by chunk.
+// Chunks that are not a subslice of b are padded copies and are left alone.
+func madviseDontNeed(b, chunk []byte) {
+	if len(chunk) == 0 || !within(b, chunk) {
+		return
+	}
+
 	// Figure out a page aligned slice that fits the incoming chunk
-	startPtr := uintptr(unsafe.Pointer(&(*chunk)[0]))
-	endPtr := uintptr(unsafe.Pointer(&(*chunk)[len(*chunk)-1])) + 1
+	startPtr := uintptr(unsafe.Pointer(&chunk[0]))
+	endPtr := uintptr(unsafe.Pointer(&chunk[len(chunk)-1])) + 1
 
 	alignedStart := alignToPage(startPtr, pagesize)
 	alignedEnd := alignToPage(endPtr, pagesize) + uintptr(pagesize)
 
-	// Ensure we don't go out of bounds of the original mmap slice
-	if alignedStart < uintptr(unsafe.Pointer(&c.b[0])) {
-		alignedStart = uintptr(unsafe.Pointer(&c.b[0]))
+	// Ensure we don't go out of bounds of the original mmap slice, b may not
+	// start on a page when it's a range of a mapping, madvise wants aligned
+	// addresses
+	if alignedStart < uintptr(unsafe.Pointer(&b[0])) {
+		alignedStart = alignToPage(uintptr(unsafe.Pointer(&b[0]))+uintptr(pagesize-1), pagesize)
+	}
+	if alignedEnd > uintptr(unsafe.Pointer(&b[len(b)-1]))+1 {
+		alignedEnd = uintptr(unsafe.Pointer(&b[len(b)-1])) + 1
 	}
-	if alignedEnd > uintptr(unsafe.Pointer(&c.b[len(c.b)-1]))+1 {
-		alignedEnd = uintptr(unsafe.Pointer(&c.b[len(c.b)-1])) + 1
+	if alignedEnd <= alignedStart {
+		return
 	}
 
 	// Get the aligned slice
-	alignedSlice := c.b[alignedStart-uintptr(unsafe.Pointer(&c.b[0])) : alignedEnd-uintptr(unsafe.Pointer(&c.b[0]))]
+	alignedSlice := b[alignedStart-uintptr(unsafe.Pointer(&b[0])) : alignedEnd-uintptr(unsafe.Pointer(&b[0]))]
 
 	// Apply MADV_DONTNEED
 	err := unix.Madvise(alignedSlice, unix.MADV_DONTNEED)
@@ -141,21 +248,49 @@ func (c *ByteChunker) ReleaseChunk(chunk *[]byte) {
 	}
 }
 
+// Queued returns the number of chunks waiting in the channel, see Progress
+func (c *ByteChunker) Queued() (n, capacity int) {
+	return len(c.chunkCh), cap(c.chunkCh)
+}
+
 func (c *ByteChunker) NextChunk() *[]byte {
 	return <-c.chunkCh
 }
 
+func (c *ByteChunker) ChunkOffset(chunk []byte) int64 {
+	return c.copies.offset(c.b, chunk)
+}
+
 func (c *ByteChunker) Run() error {
-	readStartPos := 0
-	for readStartPos < len(c.b) {
-		chunk := c.b[readStartPos:min(readStartPos+c.chunkSize, len(c.b))]
+	ranges := c.ranges
+	if ranges == nil {
+		ranges = []Range{{0, len(c.b)}}
+	}
+	for _, r := range ranges {
+		if r.Start < 0 || r.End > len(c.b) || r.Start > r.End {
+			return fmt.Errorf("range %d-%d out of the input", r.Start, r.End)
+		}
+		if err := c.run(r); err != nil {
+			return err
+		}
+	}
+	close(c.chunkCh)
+	return nil
+}
+
+// run sends the chunks of the range r
+func (c *ByteChunker) run(r Range) error {
+	readStartPos := r.Start
+	for readStartPos < r.End {
+		chunk := c.b[readStartPos:min(readStartPos+c.chunkSize, r.End)]
 		lastnl := bytes.LastIndexByte(chunk, '\n')
 		if lastnl == -1 {
 			return fmt.Errorf("missing \\n in chunk")
 		}
 
-		chunk = chunk[:lastnl+1]   // include \n
-		readStartPos += len(chunk) // start next read after \n
+		end := readStartPos + lastnl + 1 // include \n
+		chunk = c.copies.padded(c.b, readStartPos, end)
+		readStartPos = end // start next read after \n
 
 		c.chunkCh <- &chunk
 		// log.Printf("chunk:\n%s", chunk)
@@ -163,6 +298,75 @@ func (c *ByteChunker) Run() error {
 		// log.Printf("readStartPos: %d", readStartPos)
 		// log.Printf("lenb: %d", len(c.b))
 	}
-	close(c.chunkCh)
+	return nil
+}
+
+// AtomicChunker hands out chunks of b without a producer goroutine or channel.
+// Workers claim the next chunkSize region with an atomic add on a shared
+// offset and then move the region boundaries to the next line start on their
+// own, so no line is split or handed out twice.
+type AtomicChunker struct {
+	b         []byte
+	offset    atomic.Int64
+	chunkSize int
+	copies    copyOffsets
+}
+
+func NewAtomicChunker(input []byte, chunkSize int) *AtomicChunker {
+	return &AtomicChunker{
+		b:         input,
+		chunkSize: chunkSize,
+	}
+}
+
+// nextLineStart returns the position of the first line starting at or after
+// pos.
+func (c *AtomicChunker) nextLineStart(pos int) int {
+	if pos <= 0 {
+		return 0
+	}
+	if pos >= len(c.b) {
+		return len(c.b)
+	}
+	nl := bytes.IndexByte(c.b[pos-1:], '\n')
+	if nl == -1 {
+		return len(c.b)
+	}
+	return pos + nl
+}
+
+func (c *AtomicChunker) NextChunk() *[]byte {
+	for {
+		end := int(c.offset.Add(int64(c.chunkSize)))
+		start := end - c.chunkSize
+		if start >= len(c.b) {
+			return nil
+		}
+
+		start = c.nextLineStart(start)
+		end = c.nextLineStart(end)
+		if start == end {
+			// a single line spans the whole region, it belongs to the
+			// worker that claimed the region where it starts
+			continue
+		}
+
+		chunk := c.copies.padded(c.b, start, end)
+		return &chunk
+	}
+}
+
+func (c *AtomicChunker) ChunkOffset(chunk []byte) int64 {
+	return c.copies.offset(c.b, chunk)
+}
+
+// ReleaseChunk calls madvise(2) with MADV_DONTNEED, see ByteChunker.ReleaseChunk
+func (c *AtomicChunker) ReleaseChunk(chunk *[]byte) {
+	madviseDontNeed(c.b, *chunk)
+}
+
+// Run is a noop, workers find their chunks themselves.
+// It only exists so AtomicChunker can be used where a ByteChunker is.
+func (c *AtomicChunker) Run() error {
 	return nil
 }
@@ -5,13 +5,18 @@ import (
 	"bytes"
 	"fmt"
 	"os"
+	"path/filepath"
 	"runtime"
+	"runtime/debug"
+	"sort"
 	"sync"
 	"syscall"
 	"testing"
+	"unsafe"
 
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
+	"golang.org/x/sys/unix"
 )
 
 func TestChunkerMano(t *testing.T) {
@@ -72,6 +77,67 @@ func TestByteChunkerMano(t *testing.T) {
 	assert.Equal(t, b, b2)
 }
 
+func TestAtomicChunkerMano(t *testing.T) {
+	b := make([]byte, 0, 64*1024+128)
+	line := []byte("aaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaa patate\n")
+	for range 1024*4 + 128 {
+		b = append(b, line...)
+	}
+
+	// chunk sizes smaller than, equal to and larger than a line
+	for _, chunkSize := range []int{7, 64, 255, 4096} {
+		t.Run(fmt.Sprintf("chunksize%d", chunkSize), func(t *testing.T) {
+			chunker := NewAtomicChunker(b, chunkSize)
+			b2 := make([]byte, 0, 64*1024+128)
+			for {
+				chunk := chunker.NextChunk()
+				if chunk == nil {
+					break
+				}
+				require.Equalf(t, byte('\n'), (*chunk)[len(*chunk)-1], "chunk: %v", *chunk)
+				b2 = append(b2, *chunk...)
+			}
+			assert.Equal(t, b, b2)
+		})
+	}
+}
+
+func TestAtomicChunkerConcurrent(t *testing.T) {
+	b := make([]byte, 0, 1024*1024)
+	for i := range 64 * 1024 {
+		b = fmt.Appendf(b, "station%d;%d.%d\n", i%413, i%100, i%10)
+	}
+
+	chunker := NewAtomicChunker(b, 1000)
+	nworkers := 8
+	received := make([][][]byte, nworkers)
+	wg := sync.WaitGroup{}
+	wg.Add(nworkers)
+	for i := range nworkers {
+		go func() {
+			defer wg.Done()
+			for {
+				chunk := chunker.NextChunk()
+				if chunk == nil {
+					return
+				}
+				received[i] = append(received[i], *chunk)
+			}
+		}()
+	}
+	wg.Wait()
+
+	// reassemble the chunks in file order, they must cover b exactly once
+	var chunks [][]byte
+	for i := range received {
+		chunks = append(chunks, received[i]...)
+	}
+	sort.Slice(chunks, func(i, j int) bool {
+		return uintptr(unsafe.Pointer(&chunks[i][0])) < uintptr(unsafe.Pointer(&chunks[j][0]))
+	})
+	assert.Equal(t, b, bytes.Join(chunks, nil))
+}
+
 func benchmarkMmapByteChunker1b(b *testing.B, nworkers, chCap, chunkSize int) {
 	b.ReportAllocs()
 	filename := "../../data/1b.txt"
@@ -183,3 +249,151 @@ func BenchmarkChunker1b(b *testing.B) {
 		}
 	}
 }
+
+type runnableChunker interface {
+	Run() error
+	ChunkGetter
+}
+
+func benchmarkMmapChunkGetter1b(b *testing.B, nworkers int, newChunker func([]byte) runnableChunker) {
+	b.ReportAllocs()
+	filename := "../../data/1b.txt"
+	for range b.N {
+		f, err := os.Open(filename)
+		if err != nil {
+			b.Fatalf("open: %s", err)
+		}
+		defer f.Close()
+
+		fi, err := f.Stat()
+		assert.NoError(b, err)
+
+		data, err := syscall.Mmap(int(f.Fd()), 0, int(fi.Size()), syscall.PROT_READ, syscall.MAP_SHARED)
+		assert.NoError(b, err)
+
+		chunker := newChunker(data)
+		wg := sync.WaitGroup{}
+		wg.Add(nworkers + 1)
+		go func() {
+			defer wg.Done()
+			assert.NoError(b, chunker.Run())
+		}()
+
+		for range nworkers {
+			go func() {
+				defer wg.Done()
+				ParseWorker(chunker)
+			}()
+		}
+		wg.Wait()
+		assert.NoError(b, syscall.Munmap(data))
+	}
+}
+
+// BenchmarkChunkDistribution1b compares the channel based ByteChunker with the
+// work stealing AtomicChunker, both feeding ParseWorker.
+func BenchmarkChunkDistribution1b(b *testing.B) {
+	chunkSize := 2048 * 1024
+	for _, nworkers := range []int{8, 16, 24} {
+		b.Run(fmt.Sprintf("ByteChunker-nworker%02d", nworkers), func(b *testing.B) {
+			benchmarkMmapChunkGetter1b(b, nworkers, func(data []byte) runnableChunker {
+				return NewByteChunker(data, nworkers, chunkSize)
+			})
+		})
+		b.Run(fmt.Sprintf("AtomicChunker-nworker%02d", nworkers), func(b *testing.B) {
+			benchmarkMmapChunkGetter1b(b, nworkers, func(data []byte) runnableChunker {
+				return NewAtomicChunker(data, chunkSize)
+			})
+		})
+	}
+}
+
+// measurementsOfSize returns valid measurements exactly size bytes long
+func measurementsOfSize(size int) []byte {
+	lines := bytes.SplitAfter(generateMeasurements(size/4), []byte("\n"))
+	b := make([]byte, 0, size)
+	for _, line := range lines {
+		if size-len(b)-len(line) < 8 {
+			break
+		}
+		b = append(b, line...)
+	}
+	// stretch the name of the last line to fill what's left
+	name := bytes.Repeat([]byte("z"), size-len(b)-len(";1.2\n"))
+	b = append(b, name...)
+	return append(b, ";1.2\n"...)
+}
+
+// mmapWithGuard maps filename followed by PROT_NONE pages, any read past the
+// last page of the file faults.
+func mmapWithGuard(t *testing.T, filename string) []byte {
+	f, err := os.Open(filename)
+	require.NoError(t, err)
+	defer f.Close()
+	fi, err := f.Stat()
+	require.NoError(t, err)
+
+	size := int(fi.Size())
+	mapped := (size + pagesize - 1) &^ (pagesize - 1)
+	guard, err := unix.Mmap(-1, 0, mapped+pagesize, unix.PROT_NONE, unix.MAP_PRIVATE|unix.MAP_ANON)
+	require.NoError(t, err)
+	t.Cleanup(func() { unix.Munmap(guard) })
+
+	_, err = unix.MmapPtr(int(f.Fd()), 0, unsafe.Pointer(&guard[0]), uintptr(size), unix.PROT_READ, unix.MAP_SHARED|unix.MAP_FIXED)
+	require.NoError(t, err)
+	return guard[:size:size]
+}
+
+func TestChunkersTailPad(t *testing.T) {
+	workers := map[string]func(ChunkGetter) []StationInt16{
+		"IndexByte8Bytes": ParseWorker,
+		"Masks64": func(g ChunkGetter) []StationInt16 {
+			table, err := ParseWorkerMasks(g)
+			require.NoError(t, err)
+			return table
+		},
+	}
+	for _, k := range []int{1, 2, 16} {
+		for _, delta := range []int{-1, 0, 1} {
+			size := pagesize*k + delta
+			input := measurementsOfSize(size)
+			require.Len(t, input, size)
+			filename := filepath.Join(t.TempDir(), "measurements.txt")
+			require.NoError(t, os.WriteFile(filename, input, 0o644))
+			data := mmapWithGuard(t, filename)
+
+			// a copy with plenty of room after it is always safe to read past
+			expected := ParseWorker(newSliceChunker(append(make([]byte, 0, size+pagesize), input...), 512))
+
+			for _, chunkSize := range []int{512, size} {
+				chunkers := map[string]func() runnableChunker{
+					"ByteChunker":   func() runnableChunker { return NewByteChunker(data, 4, chunkSize) },
+					"AtomicChunker": func() runnableChunker { return NewAtomicChunker(data, chunkSize) },
+					"RegionChunker": func() runnableChunker { return NewRegionChunker(data, []int{1, 1}, chunkSize) },
+					"Chunker": func() runnableChunker {
+						return NewChunker(bytes.NewReader(input[:size:size]), 4, chunkSize)
+					},
+				}
+				for chunkerName, newChunker := range chunkers {
+					for workerName, worker := range workers {
+						t.Run(fmt.Sprintf("size%d/chunk%d/%s/%s", size, chunkSize, chunkerName, workerName), func(t *testing.T) {
+							// a read past the mapping panics instead of crashing the test binary
+							defer debug.SetPanicOnFault(debug.SetPanicOnFault(true))
+							defer func() {
+								if r := recover(); r != nil {
+									t.Fatalf("read past the input: %v", r)
+								}
+							}()
+
+							chunker := newChunker()
+							go func() {
+								assert.NoError(t, chunker.Run())
+							}()
+							assert.Equal(t, expected, worker(chunker))
+						})
+					}
+				}
+			}
+		}
+	}
+}
@@ -0,0 +1,408 @@
+package fastbrc
+
+import (
+	"bytes"
+	"fmt"
+	"math"
+	"math/bits"
+	"runtime"
+	"slices"
+	"sync"
+	"sync/atomic"
+	"unsafe"
+)
+
+// Dedup modes, see LineDedup and DedupChunker
+const (
+	DedupNone   = "none"
+	DedupLines  = "lines"
+	DedupChunks = "chunks"
+)
+
+var DedupModes = []string{DedupNone, DedupLines, DedupChunks}
+
+// DedupStats counts what was skipped as duplicates
+type DedupStats struct {
+	Lines int64
+	Bytes int64
+	// Copies is the number of ranges skipped as copies, see DedupChunker
+	Copies int64
+}
+
+// gear maps bytes to random values for the rolling hash of DedupChunker
+var gear = func() (g [256]uint64) {
+	// splitmix64
+	x := uint64(0x1b2c)
+	for i := range g {
+		x += 0x9e3779b97f4a7c15
+		z := x
+		z = (z ^ (z >> 30)) * 0xbf58476d1ce4e5b9
+		z = (z ^ (z >> 27)) * 0x94d049bb133111eb
+		g[i] = z ^ (z >> 31)
+	}
+	return g
+}()
+
+// DedupChunker serves its input in blocks of about blockSize bytes, without
+// the ranges of lines that are a copy of lines before them.
+//
+// Retries upstream append copies of what was already written at any offset,
+// so blocks can't be cut at fixed positions: a block ends at the first '\n'
+// after a position where the gear hash of the 64 bytes before it has its top
+// bits zeroed, and no other such position in the blockSize/4 bytes before.
+// The cuts only depend on the content around them, a copy of a region is cut
+// like the region itself save for its first and last blocks, and they are
+// found in parallel by Run.
+//
+// A block equal to a block before it is a part of a copy, the copy is then
+// extended line by line on both sides as long as it matches the lines around
+// the block it's equal to, so the whole copy is skipped and nothing else.
+// Copies of less than about 2 blocks are not found.
+type DedupChunker struct {
+	b         []byte
+	blockSize int
+	ready     chan struct{}
+	// ends of the blocks, the last one is len(b)
+	ends []int
+	// start and end of the parts of the blocks served, without the copies
+	chunks  [][2]int
+	next    atomic.Int64
+	copies  copyOffsets
+	skipped DedupStats
+}
+
+func NewDedupChunker(input []byte, blockSize int) *DedupChunker {
+	return &DedupChunker{
+		b:         input,
+		blockSize: max(blockSize, 256),
+		ready:     make(chan struct{}),
+	}
+}
+
+// Run finds the ends of the blocks and hashes them with GOMAXPROCS
+// goroutines, then finds the copies, workers wait for it.
+func (c *DedupChunker) Run() error {
+	defer close(c.ready)
+
+	n := runtime.GOMAXPROCS(0)
+	sectionSize := max(len(c.b)/n, 1)
+	cuts := make([][]int, n)
+	var wg sync.WaitGroup
+	for i := range n {
+		start, end := min(i*sectionSize, len(c.b)), min((i+1)*sectionSize, len(c.b))
+		if i == n-1 {
+			end = len(c.b)
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			cuts[i] = c.cutsIn(start, end)
+		}()
+	}
+	wg.Wait()
+
+	ends := slices.Compact(slices.Concat(cuts...))
+	if len(ends) == 0 || ends[len(ends)-1] != len(c.b) {
+		ends = append(ends, len(c.b))
+	}
+	c.ends = ends
+
+	hashes := make([]uint64, len(ends))
+	for w := range n {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := w; i < len(ends); i += n {
+				start, end := c.block(i)
+				hashes[i] = hashBytes(c.b[start:end])
+			}
+		}()
+	}
+	wg.Wait()
+
+	c.chunks = subtractRanges(c.blockRanges(), c.findCopies(hashes))
+	return nil
+}
+
+// block returns the start and end of block i
+func (c *DedupChunker) block(i int) (int, int) {
+	if i == 0 {
+		return 0, c.ends[0]
+	}
+	return c.ends[i-1], c.ends[i]
+}
+
+func (c *DedupChunker) blockRanges() [][2]int {
+	blocks := make([][2]int, 0, len(c.ends))
+	for i := range c.ends {
+		if start, end := c.block(i); start < end {
+			blocks = append(blocks, [2]int{start, end})
+		}
+	}
+	return blocks
+}
+
+// findCopies returns the ranges of lines that are a copy of lines before
+// them, in order and not overlapping, from the hashes of the blocks
+func (c *DedupChunker) findCopies(hashes []uint64) [][2]int {
+	// first block of each hash
+	seen := make(map[uint64]int, len(hashes))
+	var copies [][2]int
+	for i, h := range hashes {
+		start, end := c.block(i)
+		if n := len(copies); n > 0 && start >= copies[n-1][0] && end <= copies[n-1][1] {
+			// in the copy found last
+			continue
+		}
+		first, ok := seen[h]
+		if !ok {
+			seen[h] = i
+			continue
+		}
+		origStart, origEnd := c.block(first)
+		if !bytes.Equal(c.b[origStart:origEnd], c.b[start:end]) {
+			continue
+		}
+
+		// extend the copy to the lines around it matching the original
+		for origStart > 0 {
+			line := c.b[bytes.LastIndexByte(c.b[:origStart-1], '\n')+1 : origStart]
+			if !bytes.Equal(c.b[start-len(line):start], line) {
+				break
+			}
+			origStart -= len(line)
+			start -= len(line)
+		}
+		for end < len(c.b) {
+			nl := bytes.IndexByte(c.b[end:], '\n')
+			if nl == -1 {
+				break
+			}
+			line := c.b[end : end+nl+1]
+			if !bytes.Equal(c.b[origEnd:min(origEnd+len(line), len(c.b))], line) {
+				break
+			}
+			origEnd += len(line)
+			end += len(line)
+		}
+
+		// merge with the copies it overlaps
+		for n := len(copies); n > 0 && copies[n-1][1] > start; n-- {
+			start = min(start, copies[n-1][0])
+			end = max(end, copies[n-1][1])
+			copies = copies[:n-1]
+		}
+		copies = append(copies, [2]int{start, end})
+	}
+
+	for _, r := range copies {
+		c.skipped.Lines += int64(bytes.Count(c.b[r[0]:r[1]], []byte{'\n'}))
+		c.skipped.Bytes += int64(r[1] - r[0])
+	}
+	c.skipped.Copies = int64(len(copies))
+	return copies
+}
+
+// subtractRanges returns the parts of ranges not in sub, both in order and
+// not overlapping
+func subtractRanges(ranges, sub [][2]int) [][2]int {
+	var parts [][2]int
+	for _, r := range ranges {
+		for len(sub) > 0 && sub[0][1] <= r[0] {
+			sub = sub[1:]
+		}
+		start := r[0]
+		for _, s := range sub {
+			if s[0] >= r[1] {
+				break
+			}
+			if s[0] > start {
+				parts = append(parts, [2]int{start, s[0]})
+			}
+			start = max(start, s[1])
+		}
+		if start < r[1] {
+			parts = append(parts, [2]int{start, r[1]})
+		}
+	}
+	return parts
+}
+
+// cutsIn returns the ends of the blocks cut in b[start:end]. The hashes and
+// the previous cut candidates are recomputed from before start, so sections
+// are cut the same as if b was scanned from the beginning.
+func (c *DedupChunker) cutsIn(start, end int) []int {
+	// hits are spaced by blockSize/2 on average
+	shift := uint(64 - (bits.Len(uint(c.blockSize/2)) - 1))
+	minGap := c.blockSize / 4
+
+	var cuts []int
+	var h uint64
+	lastHit := math.MinInt / 2
+	for i := max(start-minGap-64, 0); i < end; i++ {
+		h = h<<1 + gear[c.b[i]]
+		if h>>shift != 0 {
+			continue
+		}
+		if i >= start && i-lastHit > minGap {
+			nl := bytes.IndexByte(c.b[i:], '\n')
+			if nl == -1 {
+				break
+			}
+			if cut := i + nl + 1; len(cuts) == 0 || cuts[len(cuts)-1] != cut {
+				cuts = append(cuts, cut)
+			}
+		}
+		lastHit = i
+	}
+	return cuts
+}
+
+func (c *DedupChunker) NextChunk() *[]byte {
+	<-c.ready
+	i := int(c.next.Add(1)) - 1
+	if i >= len(c.chunks) {
+		return nil
+	}
+	chunk := c.copies.padded(c.b, c.chunks[i][0], c.chunks[i][1])
+	return &chunk
+}
+
+// Skipped returns what was skipped, once Run returned
+func (c *DedupChunker) Skipped() DedupStats {
+	return c.skipped
+}
+
+func (c *DedupChunker) ChunkOffset(chunk []byte) int64 {
+	return c.copies.offset(c.b, chunk)
+}
+
+// ReleaseChunk calls madvise(2) with MADV_DONTNEED, see ByteChunker.ReleaseChunk.
+func (c *DedupChunker) ReleaseChunk(chunk *[]byte) {
+	madviseDontNeed(c.b, *chunk)
+}
+
+// LineDedup drops the lines equal to one of the Window lines before them in
+// Input. It can't tell a retried line from a real reading of a station
+// repeating the previous value, those are dropped too.
+//
+// Chunk boundaries don't matter: at the start of a chunk the worker reads the
+// lines before it from Input, located with Offsets.
+type LineDedup struct {
+	Window  int
+	Input   []byte
+	Offsets ChunkOffsets
+}
+
+// lineWindow holds the last lines seen, by their hash
+type lineWindow struct {
+	lines  [][]byte
+	hashes []uint64
+	// index of the last line of each hash in the window
+	last map[uint64]int
+	n    int
+}
+
+func newLineWindow(size int) *lineWindow {
+	return &lineWindow{
+		lines:  make([][]byte, size),
+		hashes: make([]uint64, size),
+		last:   make(map[uint64]int, size),
+	}
+}
+
+func (w *lineWindow) reset() {
+	clear(w.last)
+	w.n = 0
+}
+
+// add adds line to the window and reports whether it was already in it
+func (w *lineWindow) add(line []byte) bool {
+	h := hashBytes(line)
+	i, ok := w.last[h]
+	dup := ok && bytes.Equal(w.lines[i%len(w.lines)], line)
+
+	j := w.n % len(w.lines)
+	if w.n >= len(w.lines) && w.last[w.hashes[j]] == w.n-len(w.lines) {
+		// the oldest line leaves the window
+		delete(w.last, w.hashes[j])
+	}
+	w.lines[j], w.hashes[j] = line, h
+	w.last[h] = w.n
+	w.n++
+	return dup
+}
+
+// linesBefore returns the start of the Window-th line before offset
+func (d *LineDedup) linesBefore(offset int) int {
+	start := offset
+	for range d.Window {
+		if start == 0 {
+			break
+		}
+		start = bytes.LastIndexByte(d.Input[:start-1], '\n') + 1
+	}
+	return start
+}
+
+// Worker is ParseWorkerHash with xxh3 skipping the duplicate lines, it returns
+// the number of lines skipped.
+func (d *LineDedup) Worker(chunker ChunkGetter, filter *Filter) ([]StationInt16, int64, error) {
+	if d.Window <= 0 {
+		return nil, 0, fmt.Errorf("dedup window must be positive")
+	}
+	stationTable := newStationTable()
+	window := newLineWindow(d.Window)
+	var skipped int64
+
+	var broadcastedDelim uint64 = 0x3b3b3b3b3b3b3b3b
+
+	for {
+		chunk := chunker.NextChunk()
+		if chunk == nil {
+			break
+		}
+
+		chunkOffset := int64(-1)
+		if d.Offsets != nil {
+			chunkOffset = d.Offsets.ChunkOffset(*chunk)
+		}
+		if chunkOffset < 0 {
+			return nil, 0, fmt.Errorf("dedup: chunk not found in the input")
+		}
+		input := d.Input[chunkOffset:]
+
+		window.reset()
+		for pos := d.linesBefore(int(chunkOffset)); pos < int(chunkOffset); {
+			nl := bytes.IndexByte(d.Input[pos:], '\n')
+			window.add(d.Input[pos : pos+nl+1])
+			pos += nl + 1
+		}
+
+		startpos := 0
+		chunklen := len(*chunk)
+		chunkp := unsafe.Pointer(unsafe.SliceData(*chunk))
+		for startpos < chunklen {
+			p := unsafe.Add(chunkp, startpos)
+			delim := indexBytePointerUnsafe8Bytes(p, 32, ';', broadcastedDelim)
+			m, l := ParseTemperature(unsafe.Add(p, delim+1))
+			linestart := startpos
+			startpos += delim + 1 + l
+			if window.add(input[linestart:startpos]) {
+				skipped++
+				continue
+			}
+
+			station := &stationTable[stationSlot(stationTable, hashName(p, delim), p, delim, filter)]
+			if filter == nil {
+				station.NewMeasurement(m)
+			} else {
+				station.Add(m)
+			}
+		}
+
+		chunker.ReleaseChunk(chunk)
+	}
+
+	return stationTable, skipped, nil
+}
@@ -0,0 +1,122 @@
+package fastbrc
+
+import (
+	"bytes"
+	"fmt"
+	"math/rand/v2"
+	"slices"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDedupChunkerCuts(t *testing.T) {
+	data := generateMeasurements(100000)
+	c := NewDedupChunker(data, 16*1024)
+	require.NoError(t, c.Run())
+
+	// cut in parallel like in one pass
+	expected := append(c.cutsIn(0, len(data)), len(data))
+	assert.Equal(t, expected, c.ends)
+	assert.Greater(t, len(c.ends), len(data)/(64*1024))
+	assert.Less(t, len(c.ends), len(data)/(4*1024))
+	start := 0
+	for _, end := range c.ends {
+		assert.Greater(t, end, start)
+		assert.Equal(t, byte('\n'), data[end-1])
+		start = end
+	}
+}
+
+func TestDedupChunker(t *testing.T) {
+	data := generateMeasurements(100000)
+	lines := bytes.SplitAfter(data, []byte{'\n'})
+	// retries append copies of ranges of lines
+	copies := [][]byte{bytes.Join(lines[:50000], nil), bytes.Join(lines[70000:95000], nil), bytes.Join(lines[20000:40000], nil)}
+	input := slices.Concat(data, copies[0], copies[1], lines[42], copies[2])
+	expected := stationsByName(ParseWorker(newSliceChunker(slices.Concat(data, lines[42]), 4096)))
+
+	for _, blockSize := range []int{4096, 16 * 1024, 64 * 1024} {
+		c := NewDedupChunker(input, blockSize)
+		require.NoError(t, c.Run())
+		assert.Equal(t, expected, stationsByName(ParseWorker(noRelease{c})), "block size %d", blockSize)
+		assert.Equal(t, DedupStats{
+			Lines:  int64(50000 + 25000 + 20000),
+			Bytes:  int64(len(copies[0]) + len(copies[1]) + len(copies[2])),
+			Copies: 3,
+		}, c.Skipped(), "block size %d", blockSize)
+	}
+
+	// nothing is skipped without copies
+	c := NewDedupChunker(data, 16*1024)
+	require.NoError(t, c.Run())
+	assert.Equal(t, stationsByName(ParseWorker(newSliceChunker(data, 4096))), stationsByName(ParseWorker(noRelease{c})))
+	assert.Equal(t, DedupStats{}, c.Skipped())
+}
+
+func TestSubtractRanges(t *testing.T) {
+	ranges := [][2]int{{0, 10}, {10, 20}, {20, 30}, {30, 40}}
+	assert.Equal(t, ranges, subtractRanges(ranges, nil))
+	assert.Equal(t, [][2]int{{0, 5}, {8, 10}, {10, 12}, {35, 40}}, subtractRanges(ranges, [][2]int{{5, 8}, {12, 35}}))
+	assert.Empty(t, subtractRanges(ranges, [][2]int{{0, 40}}))
+}
+
+func TestLineDedup(t *testing.T) {
+	r := rand.New(rand.NewPCG(3, 4))
+	var lines [][]byte
+	for range 5000 {
+		lines = append(lines, fmt.Appendf(nil, "%s;%d.%d\n", []string{"Abha", "Oslo", "Las Palmas de Gran Canaria"}[r.IntN(3)], r.IntN(30), r.IntN(10)))
+	}
+	data := slices.Clip(bytes.Join(lines, nil))
+
+	const window = 50
+	var expected [][]byte
+	for i, line := range lines {
+		dup := false
+		for _, prev := range lines[max(i-window, 0):i] {
+			dup = dup || bytes.Equal(prev, line)
+		}
+		if !dup {
+			expected = append(expected, line)
+		}
+	}
+	want := stationsByName(ParseWorker(newSliceChunker(bytes.Join(expected, nil), 4096)))
+	require.Less(t, len(expected), len(lines))
+
+	// small chunks so windows span several of them
+	c := NewAtomicChunker(data, 300)
+	d := &LineDedup{Window: window, Input: data, Offsets: c}
+	tables := make([][]StationInt16, 3)
+	skipped := make([]int64, 3)
+	var wg sync.WaitGroup
+	for i := range tables {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			var err error
+			tables[i], skipped[i], err = d.Worker(noRelease{c}, nil)
+			assert.NoError(t, err)
+		}()
+	}
+	wg.Wait()
+
+	got := make(map[string]StationInt16)
+	var total int64
+	for i, table := range tables {
+		total += skipped[i]
+		for name, s := range stationsByName(table) {
+			if g, ok := got[name]; ok {
+				g.Merge(&s)
+				s = g
+			}
+			got[name] = s
+		}
+	}
+	assert.Equal(t, int64(len(lines)-len(expected)), total)
+	assert.Equal(t, want, got)
+
+	_, _, err := (&LineDedup{Window: window, Input: data}).Worker(newSliceChunker(data, 300), nil)
+	assert.Error(t, err)
+}
@@ -0,0 +1,141 @@
+package fastbrc
+
+import (
+	"fmt"
+	"os"
+	"regexp"
+	"strings"
+)
+
+// Filter decides which stations are aggregated. It is evaluated once per
+// station, the first time a worker sees it, the result is cached in the
+// station table so excluded stations only cost the hash lookup.
+// A nil Filter keeps everything.
+type Filter struct {
+	// Normalize, when set, is applied to the names before they are matched,
+	// and to the names of the rules added after it is set, i.e names.NFC.
+	// Regexps match the normalized names as they are written.
+	Normalize func(name string) string
+	include   []func(name string) bool
+	exclude   []func(name string) bool
+}
+
+// Include adds a rule, when there is at least one, only the stations matching
+// an include rule are kept. See parseRule for the syntax.
+func (f *Filter) Include(rule string) error {
+	match, err := parseRule(rule, f.Normalize)
+	if err != nil {
+		return err
+	}
+	f.include = append(f.include, match)
+	return nil
+}
+
+// IncludeFunc is Include with a predicate on the names instead of a rule, it
+// sees the names normalized
+func (f *Filter) IncludeFunc(match func(name string) bool) {
+	f.include = append(f.include, match)
+}
+
+// Exclude adds a rule, stations matching an exclude rule are dropped.
+func (f *Filter) Exclude(rule string) error {
+	match, err := parseRule(rule, f.Normalize)
+	if err != nil {
+		return err
+	}
+	f.exclude = append(f.exclude, match)
+	return nil
+}
+
+// ExcludeFunc is Exclude with a predicate on the names instead of a rule, it
+// sees the names normalized
+func (f *Filter) ExcludeFunc(match func(name string) bool) {
+	f.exclude = append(f.exclude, match)
+}
+
+// Excluded reports whether the lines of station name are dropped
+func (f *Filter) Excluded(name []byte) bool {
+	if f == nil {
+		return false
+	}
+	s := string(name)
+	if f.Normalize != nil {
+		s = f.Normalize(s)
+	}
+	included := len(f.include) == 0
+	for _, match := range f.include {
+		if match(s) {
+			included = true
+			break
+		}
+	}
+	if !included {
+		return true
+	}
+	for _, match := range f.exclude {
+		if match(s) {
+			return true
+		}
+	}
+	return false
+}
+
+// parseRule parses a filter rule:
+//
+//	@file        the stations listed in file, same format as LoadStationSet
+//	re:regexp    the stations matching regexp
+//	prefix:p     the stations starting with p
+//	name         that station
+//
+// The names of the rule are normalized with normalize, unless it is nil.
+func parseRule(rule string, normalize func(string) string) (func(name string) bool, error) {
+	if normalize == nil {
+		normalize = func(name string) string { return name }
+	}
+	switch {
+	case strings.HasPrefix(rule, "@"):
+		names, err := readStationList(rule[1:])
+		if err != nil {
+			return nil, err
+		}
+		set := make(map[string]bool, len(names))
+		for _, name := range names {
+			set[normalize(name)] = true
+		}
+		return func(name string) bool { return set[name] }, nil
+	case strings.HasPrefix(rule, "re:"):
+		re, err := regexp.Compile(rule[len("re:"):])
+		if err != nil {
+			return nil, err
+		}
+		return re.MatchString, nil
+	case strings.HasPrefix(rule, "prefix:"):
+		prefix := normalize(rule[len("prefix:"):])
+		return func(name string) bool { return strings.HasPrefix(name, prefix) }, nil
+	case rule == "":
+		return nil, fmt.Errorf("empty filter rule")
+	default:
+		rule = normalize(rule)
+		return func(name string) bool { return name == rule }, nil
+	}
+}
+
+// readStationList reads a list of stations, one per line. Lines starting with
+// # are ignored and whatever follows a ';' too, so the weather_stations.csv of
+// the generator can be used as is.
+func readStationList(filename string) ([]string, error) {
+	b, err := os.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	var names []string
+	for _, line := range strings.Split(string(b), "\n") {
+		line = strings.TrimSuffix(line, "\r")
+		if len(line) == 0 || line[0] == '#' {
+			continue
+		}
+		name, _, _ := strings.Cut(line, ";")
+		names = append(names, name)
+	}
+	return names, nil
+}
@@ -0,0 +1,122 @@
+package fastbrc
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"1brc/internal/names"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFilter(t *testing.T) {
+	list := filepath.Join(t.TempDir(), "stations.txt")
+	require.NoError(t, os.WriteFile(list, []byte("# comment\nAbha;18.0\nYo\n"), 0o644))
+	crlf := filepath.Join(t.TempDir(), "stations-crlf.txt")
+	require.NoError(t, os.WriteFile(crlf, []byte("# comment\r\nAbha;18.0\r\nYo\r\n"), 0o644))
+
+	tests := []struct {
+		include, exclude []string
+		kept             []string
+	}{
+		{kept: []string{"A", "Abha", "Abéché", "Yo", "Zürich"}},
+		{include: []string{"Abha", "Zürich"}, kept: []string{"Abha", "Zürich"}},
+		{include: []string{"@" + list}, kept: []string{"Abha", "Yo"}},
+		{include: []string{"@" + crlf}, kept: []string{"Abha", "Yo"}},
+		{include: []string{"prefix:Ab"}, exclude: []string{"re:^Ab.ch"}, kept: []string{"Abha"}},
+		{exclude: []string{"re:^[AY]", "prefix:Z"}, kept: []string{}},
+		{exclude: []string{"A"}, kept: []string{"Abha", "Abéché", "Yo", "Zürich"}},
+	}
+	for _, tt := range tests {
+		f := &Filter{}
+		for _, rule := range tt.include {
+			require.NoError(t, f.Include(rule))
+		}
+		for _, rule := range tt.exclude {
+			require.NoError(t, f.Exclude(rule))
+		}
+		kept := []string{}
+		for _, name := range []string{"A", "Abha", "Abéché", "Yo", "Zürich"} {
+			if !f.Excluded([]byte(name)) {
+				kept = append(kept, name)
+			}
+		}
+		assert.Equal(t, tt.kept, kept, "include %v exclude %v", tt.include, tt.exclude)
+	}
+
+	var f *Filter
+	assert.False(t, f.Excluded([]byte("Abha")))
+
+	f = &Filter{}
+	f.IncludeFunc(func(name string) bool { return len(name) == 2 })
+	require.NoError(t, f.Include("Abha"))
+	assert.False(t, f.Excluded([]byte("Yo")))
+	assert.False(t, f.Excluded([]byte("Abha")))
+	assert.True(t, f.Excluded([]byte("A")))
+
+	f = &Filter{}
+	assert.Error(t, f.Include("re:("))
+	assert.Error(t, f.Exclude(""))
+	assert.Error(t, f.Include("@/nonexistent"))
+}
+
+func TestFilterNormalize(t *testing.T) {
+	nfd := "Abe\u0301che\u0301"
+	list := filepath.Join(t.TempDir(), "stations.txt")
+	require.NoError(t, os.WriteFile(list, []byte(nfd+"\n"), 0o644))
+	for _, rule := range []string{"Abéché", nfd, "@" + list, "prefix:Abe\u0301"} {
+		f := &Filter{Normalize: names.NFC}
+		require.NoError(t, f.Include(rule))
+		assert.False(t, f.Excluded([]byte("Abéché")), rule)
+		assert.False(t, f.Excluded([]byte(nfd)), rule)
+		assert.True(t, f.Excluded([]byte("Abha")), rule)
+	}
+	f := &Filter{}
+	require.NoError(t, f.Include("Abéché"))
+	assert.True(t, f.Excluded([]byte(nfd)))
+}
+
+func TestWorkersFilter(t *testing.T) {
+	b := generateMeasurements(100000)
+	all := stationsByName(ParseWorker(newSliceChunker(b, 4096)))
+	f := &Filter{}
+	require.NoError(t, f.Exclude("prefix:A"))
+
+	workers := map[string]func(ChunkGetter, *Filter) []StationInt16{
+		"IndexByte8Bytes": ParseWorkerFilter,
+		"Masks64": func(g ChunkGetter, f *Filter) []StationInt16 {
+			table, err := ParseWorkerMasksFilter(g, f)
+			require.NoError(t, err)
+			return table
+		},
+		"Perfect": func(g ChunkGetter, f *Filter) []StationInt16 {
+			set, err := NewStationSet([]string{"Abha", "Zürich"})
+			require.NoError(t, err)
+			table, err := set.ParseWorker(g, UnknownDynamic, f)
+			require.NoError(t, err)
+			return table
+		},
+	}
+	for _, h := range Hashers {
+		if h.Supported {
+			workers["Hash-"+h.Name] = h.Worker
+		}
+	}
+	for name, worker := range workers {
+		t.Run(name, func(t *testing.T) {
+			actual := stationsByName(worker(newSliceChunker(b, 4096), f))
+			require.Len(t, actual, len(all))
+			for name, station := range actual {
+				expected := all[name]
+				if name[0] == 'A' {
+					// only the lines are counted
+					expected = StationInt16{Min: 32767, Max: -32767, N: expected.N, Excluded: true, Name: expected.Name}
+				}
+				station.Unknown = false
+				assert.Equal(t, expected, station, name)
+			}
+		})
+	}
+}
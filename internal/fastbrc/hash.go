@@ -0,0 +1,155 @@
+package fastbrc
+
+import (
+	"bytes"
+	"fmt"
+	"slices"
+	"unsafe"
+)
+
+// Hasher is a hash function for the station names along with the parse
+// worker using it, so hashes can be compared and picked per platform without
+// editing the worker.
+type Hasher struct {
+	Name string
+	// Hash returns the hash of the l bytes at p, l < 32.
+	// Hashes can read up to 32 bytes at p, chunkPad makes it safe.
+	Hash func(p unsafe.Pointer, l int) uint64
+	// Supported is false when the cpu lacks a feature needed by Hash
+	Supported bool
+	// Worker parses chunks using Hash, dropping the lines of the stations
+	// excluded by the filter
+	Worker func(ChunkGetter, *Filter) []StationInt16
+}
+
+// Hashers are all the hashes known on this platform, supported or not, by
+// order of preference for SelectHasher("auto"). The ones using a cpu feature
+// come first, so auto picks them when the cpu has it, the inlined xxh3 is the
+// fallback on every platform.
+var Hashers = slices.Concat(
+	platformHashers,
+	// ParseWorker has xxh3 inlined and doesn't check for collisions
+	[]Hasher{{Name: "xxh3", Hash: hashName, Supported: true, Worker: ParseWorkerFilter}},
+	[]Hasher{{Name: "fnv1a", Hash: hashFNV1a, Supported: true, Worker: ParseWorkerHash(hashFNV1a)}},
+)
+
+// SelectHasher returns the hasher called name, "auto" selects the first
+// hasher supported by the cpu.
+func SelectHasher(name string) (Hasher, error) {
+	return selectHasher(Hashers, name)
+}
+
+func selectHasher(hashers []Hasher, name string) (Hasher, error) {
+	for _, h := range hashers {
+		if (name == "auto" || name == h.Name) && h.Supported {
+			return h, nil
+		}
+		if name == h.Name {
+			return Hasher{}, fmt.Errorf("hash %s not supported by this cpu", name)
+		}
+	}
+	return Hasher{}, fmt.Errorf("unknown hash: %s", name)
+}
+
+// hashFNV1a is byteHashBCE for hashers
+func hashFNV1a(p unsafe.Pointer, l int) uint64 {
+	return uint64(byteHashBCE(unsafe.Slice((*byte)(p), l)))
+}
+
+// nameEqual reports whether the l bytes at p are name. name must have a cap
+// of at least 16 and be zero padded, names of up to 16 bytes are compared with
+// 2 masked 8 bytes loads.
+func nameEqual(name []byte, p unsafe.Pointer, l int) bool {
+	if len(name) != l {
+		return false
+	}
+	if l > 16 {
+		return bytes.Equal(name, unsafe.Slice((*byte)(p), l))
+	}
+	namep := unsafe.Pointer(unsafe.SliceData(name))
+	mask0 := ^(^uint64(0) << (8 * l))           // all ones when l >= 8
+	mask1 := ^(^uint64(0) << (8 * max(l-8, 0))) // zero when l <= 8
+	return *(*uint64)(p)&mask0 == *(*uint64)(namep) &&
+		*(*uint64)(unsafe.Add(p, 8))&mask1 == *(*uint64)(unsafe.Add(namep, 8))
+}
+
+// newStationTable returns the empty table of a worker
+func newStationTable() []StationInt16 {
+	table := make([]StationInt16, 65537)
+	for i := range table {
+		table[i].Min = 32767
+		table[i].Max = -32767
+	}
+	return table
+}
+
+// probeSlot returns the slot of table for the l bytes at p, hashed to h. Slots
+// are probed linearly until the name matches or an empty slot is found, where
+// the name is inserted.
+func probeSlot(table []StationInt16, h uint64, p unsafe.Pointer, l int) uint64 {
+	slot := h % uint64(len(table))
+	station := &table[slot]
+	for station.N != 0 && !nameEqual(station.Name, p, l) {
+		slot++
+		if slot == uint64(len(table)) {
+			slot = 0
+		}
+		station = &table[slot]
+	}
+	if station.N == 0 {
+		// zero padded for nameEqual
+		station.Name = append(make([]byte, 0, max(l, 16)), unsafe.Slice((*byte)(p), l)...)
+	}
+	return slot
+}
+
+// stationSlot is probeSlot for the workers with a filter, new stations are
+// marked excluded by it
+func stationSlot(table []StationInt16, h uint64, p unsafe.Pointer, l int, filter *Filter) uint64 {
+	slot := probeSlot(table, h, p, l)
+	if station := &table[slot]; station.N == 0 {
+		station.Excluded = filter.Excluded(station.Name)
+	}
+	return slot
+}
+
+// ParseWorkerHash returns a ParseWorker calling hash for the station names.
+// The hash isn't inlined, so collisions are cheap to handle compared to the
+// call: slots are probed linearly until the name matches or an empty slot is
+// found.
+func ParseWorkerHash(hash func(p unsafe.Pointer, l int) uint64) func(ChunkGetter, *Filter) []StationInt16 {
+	return func(chunker ChunkGetter, filter *Filter) []StationInt16 {
+		stationTable := newStationTable()
+
+		var broadcastedDelim uint64 = 0x3b3b3b3b3b3b3b3b
+
+		for {
+			chunk := chunker.NextChunk()
+			if chunk == nil {
+				break
+			}
+
+			startpos := 0
+			chunklen := len(*chunk)
+			chunkp := unsafe.Pointer(unsafe.SliceData(*chunk))
+			for startpos < chunklen {
+				p := unsafe.Add(chunkp, startpos)
+				delim := indexBytePointerUnsafe8Bytes(p, 32, ';', broadcastedDelim)
+				station := &stationTable[stationSlot(stationTable, hash(p, delim), p, delim, filter)]
+
+				startpos += delim + 1
+				m, l := ParseTemperature(unsafe.Add(chunkp, startpos))
+				if filter == nil {
+					station.NewMeasurement(m)
+				} else {
+					station.Add(m)
+				}
+				startpos += l
+			}
+
+			chunker.ReleaseChunk(chunk)
+		}
+
+		return stationTable
+	}
+}
@@ -0,0 +1,17 @@
+package fastbrc
+
+import (
+	"unsafe"
+
+	"golang.org/x/sys/cpu"
+)
+
+var platformHashers = []Hasher{
+	{Name: "aes", Hash: aesHash, Supported: cpu.X86.HasAES, Worker: ParseWorkerHash(aesHash)},
+}
+
+// aesHash hashes the l bytes at p, l <= 32, with 3 rounds of AESENC.
+// It always loads 32 bytes and masks out the ones after l.
+//
+//go:noescape
+func aesHash(p unsafe.Pointer, l int) uint64
@@ -0,0 +1,49 @@
+#include "textflag.h"
+
+// func aesHash(p unsafe.Pointer, l int) uint64
+TEXT ·aesHash(SB), NOSPLIT, $0-24
+	MOVQ p+0(FP), SI
+	MOVQ l+8(FP), CX
+
+	// aesMasks+32-l starts with l 0xff bytes followed by zeroes
+	LEAQ aesMasks<>+32(SB), DX
+	SUBQ CX, DX
+
+	MOVOU (SI), X0
+	MOVOU 16(SI), X1
+	MOVOU (DX), X2
+	MOVOU 16(DX), X3
+	PAND  X2, X0
+	PAND  X3, X1
+
+	MOVOU aesKeys<>+0(SB), X4
+	MOVOU aesKeys<>+16(SB), X5
+
+	// seed with the length so names padded with zeroes don't collide
+	MOVQ   CX, X2
+	PXOR   X2, X0
+	AESENC X4, X0
+	PXOR   X1, X0
+	AESENC X5, X0
+	AESENC X4, X0
+
+	MOVQ X0, AX
+	MOVQ AX, ret+16(FP)
+	RET
+
+DATA aesMasks<>+0(SB)/8, $0xffffffffffffffff
+DATA aesMasks<>+8(SB)/8, $0xffffffffffffffff
+DATA aesMasks<>+16(SB)/8, $0xffffffffffffffff
+DATA aesMasks<>+24(SB)/8, $0xffffffffffffffff
+DATA aesMasks<>+32(SB)/8, $0
+DATA aesMasks<>+40(SB)/8, $0
+DATA aesMasks<>+48(SB)/8, $0
+DATA aesMasks<>+56(SB)/8, $0
+GLOBL aesMasks<>(SB), RODATA|NOPTR, $64
+
+// first digits of pi
+DATA aesKeys<>+0(SB)/8, $0x243f6a8885a308d3
+DATA aesKeys<>+8(SB)/8, $0x13198a2e03707344
+DATA aesKeys<>+16(SB)/8, $0xa4093822299f31d0
+DATA aesKeys<>+24(SB)/8, $0x082efa98ec4e6c89
+GLOBL aesKeys<>(SB), RODATA|NOPTR, $32
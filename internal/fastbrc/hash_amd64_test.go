@@ -0,0 +1,19 @@
+package fastbrc
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/sys/cpu"
+)
+
+func TestSelectHasherAES(t *testing.T) {
+	h, err := SelectHasher("auto")
+	require.NoError(t, err)
+	if cpu.X86.HasAES {
+		assert.Equal(t, "aes", h.Name)
+	} else {
+		assert.Equal(t, "xxh3", h.Name)
+	}
+}
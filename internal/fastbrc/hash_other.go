@@ -0,0 +1,5 @@
+//go:build !amd64
+
+package fastbrc
+
+var platformHashers []Hasher
@@ -0,0 +1,155 @@
+package fastbrc
+
+import (
+	"bytes"
+	"testing"
+	"unsafe"
+
+	"1brc/internal/agg"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// stationsByName indexes the non empty slots of a station table
+func stationsByName(table []StationInt16) map[string]StationInt16 {
+	stations := make(map[string]StationInt16)
+	for _, s := range table {
+		if s.N > 0 {
+			stations[string(s.Name)] = s
+		}
+	}
+	return stations
+}
+
+func TestHashers(t *testing.T) {
+	b := generateMeasurements(100000)
+	expected := stationsByName(ParseWorker(newSliceChunker(b, 4096)))
+	for _, h := range Hashers {
+		t.Run(h.Name, func(t *testing.T) {
+			if !h.Supported {
+				t.Skip("not supported by this cpu")
+			}
+			assert.Equal(t, expected, stationsByName(h.Worker(newSliceChunker(b, 4096), nil)))
+		})
+	}
+}
+
+func TestHasherSeesOnlyTheName(t *testing.T) {
+	name := []byte("Las Palmas de Gran Canaria;12.3\n")
+	for _, h := range Hashers {
+		if !h.Supported {
+			continue
+		}
+		for l := range 27 {
+			// same name, different bytes after it
+			b1 := make([]byte, 64)
+			b2 := bytes.Repeat([]byte{0xff}, 64)
+			copy(b1, name[:l])
+			copy(b2, name[:l])
+			assert.Equal(t, h.Hash(unsafe.Pointer(&b1[0]), l), h.Hash(unsafe.Pointer(&b2[0]), l), "%s len %d", h.Name, l)
+			// a zero byte isn't the same as no byte
+			assert.NotEqual(t, h.Hash(unsafe.Pointer(&b1[0]), l), h.Hash(unsafe.Pointer(&b1[0]), l+1), "%s len %d", h.Name, l)
+		}
+	}
+}
+
+func TestSelectHasher(t *testing.T) {
+	h, err := SelectHasher("auto")
+	require.NoError(t, err)
+	assert.True(t, h.Supported)
+
+	h, err = SelectHasher("fnv1a")
+	require.NoError(t, err)
+	assert.Equal(t, "fnv1a", h.Name)
+
+	_, err = SelectHasher("md5")
+	assert.Error(t, err)
+
+	// auto skips the hashes the cpu doesn't support
+	hashers := []Hasher{{Name: "aes"}, {Name: "xxh3", Supported: true}}
+	h, err = selectHasher(hashers, "auto")
+	require.NoError(t, err)
+	assert.Equal(t, "xxh3", h.Name)
+	hashers[0].Supported = true
+	h, err = selectHasher(hashers, "auto")
+	require.NoError(t, err)
+	assert.Equal(t, "aes", h.Name)
+	_, err = selectHasher(hashers[1:], "aes")
+	assert.Error(t, err)
+}
+
+func BenchmarkHashers(b *testing.B) {
+	data := generateMeasurements(1000000)
+	for _, h := range Hashers {
+		if !h.Supported {
+			continue
+		}
+		b.Run(h.Name, func(b *testing.B) {
+			b.SetBytes(int64(len(data)))
+			for range b.N {
+				h.Worker(newSliceChunker(data, 256*1024), nil)
+			}
+		})
+	}
+}
+
+func TestParseWorkerHashCollisions(t *testing.T) {
+	b := generateMeasurements(10000)
+	expected := stationsByName(ParseWorker(newSliceChunker(b, 4096)))
+	// every name lands in the same slot
+	worker := ParseWorkerHash(func(p unsafe.Pointer, l int) uint64 { return 65536 })
+	assert.Equal(t, expected, stationsByName(worker(newSliceChunker(b, 4096), nil)))
+}
+
+func TestParseWorkerSquares(t *testing.T) {
+	b := generateMeasurements(10000)
+	filter := &Filter{}
+	require.NoError(t, filter.Exclude("Abha"))
+	table, squares := ParseWorkerSquares(newSliceChunker(b, 4096), filter)
+	assert.Equal(t, stationsByName(ParseWorkerHash(hashName)(newSliceChunker(b, 4096), filter)), stationsByName(table))
+
+	expected := make(map[string]int64)
+	for _, line := range bytes.Split(b[:len(b)-1], []byte{'\n'}) {
+		name, value, _ := bytes.Cut(line, []byte{';'})
+		m := int64(ParseFixedPoint16UnsafePtr(unsafe.Pointer(&value[0]), len(value)))
+		expected[string(name)] += m * m
+	}
+	got := make(map[string]int64)
+	for i, s := range table {
+		if s.N > 0 {
+			got[string(s.Name)] = squares[i]
+		}
+	}
+	assert.Equal(t, expected, got)
+}
+
+func TestParseWorkerHistogram(t *testing.T) {
+	b := generateMeasurements(10000)
+	filter := &Filter{}
+	require.NoError(t, filter.Exclude("Abha"))
+	table, histograms := ParseWorkerHistogram(hashFNV1a)(newSliceChunker(b, 4096), filter)
+	assert.Equal(t, stationsByName(ParseWorkerHash(hashName)(newSliceChunker(b, 4096), filter)), stationsByName(table))
+
+	expected := make(map[string][]uint64)
+	for _, line := range bytes.Split(b[:len(b)-1], []byte{'\n'}) {
+		name, value, _ := bytes.Cut(line, []byte{';'})
+		if string(name) == "Abha" {
+			continue
+		}
+		if expected[string(name)] == nil {
+			expected[string(name)] = make([]uint64, agg.HistogramBins)
+		}
+		m := int(ParseFixedPoint16UnsafePtr(unsafe.Pointer(&value[0]), len(value)))
+		expected[string(name)][m+agg.HistogramOffset]++
+	}
+	got := make(map[string][]uint64)
+	for i, s := range table {
+		if s.N > 0 && !s.Excluded {
+			got[string(s.Name)] = histograms[i]
+		} else {
+			assert.Nil(t, histograms[i])
+		}
+	}
+	assert.Equal(t, expected, got)
+}
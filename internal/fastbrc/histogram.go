@@ -0,0 +1,57 @@
+package fastbrc
+
+import (
+	"unsafe"
+
+	"1brc/internal/agg"
+)
+
+// ParseWorkerHistogram returns ParseWorkerHash also counting the measurements
+// of each value per station, see agg.PartialStation.Histogram. histograms is
+// indexed like the table, nil for the empty slots and the excluded stations.
+// A histogram takes 16KB, for every station of every worker.
+func ParseWorkerHistogram(hash func(p unsafe.Pointer, l int) uint64) func(ChunkGetter, *Filter) (table []StationInt16, histograms [][]uint64) {
+	return func(chunker ChunkGetter, filter *Filter) ([]StationInt16, [][]uint64) {
+		stationTable := newStationTable()
+		histograms := make([][]uint64, len(stationTable))
+
+		var broadcastedDelim uint64 = 0x3b3b3b3b3b3b3b3b
+
+		for {
+			chunk := chunker.NextChunk()
+			if chunk == nil {
+				break
+			}
+
+			startpos := 0
+			chunklen := len(*chunk)
+			chunkp := unsafe.Pointer(unsafe.SliceData(*chunk))
+			for startpos < chunklen {
+				p := unsafe.Add(chunkp, startpos)
+				delim := indexBytePointerUnsafe8Bytes(p, 32, ';', broadcastedDelim)
+				slot := stationSlot(stationTable, hash(p, delim), p, delim, filter)
+				station := &stationTable[slot]
+				if station.N == 0 && !station.Excluded {
+					histograms[slot] = make([]uint64, agg.HistogramBins)
+				}
+
+				startpos += delim + 1
+				m, l := ParseTemperature(unsafe.Add(chunkp, startpos))
+				if filter == nil {
+					station.NewMeasurement(m)
+				} else {
+					station.Add(m)
+				}
+				// values out of -99.9..99.9 are only missing from the histogram
+				if h := histograms[slot]; h != nil && uint(int(m)+agg.HistogramOffset) < agg.HistogramBins {
+					h[int(m)+agg.HistogramOffset]++
+				}
+				startpos += l
+			}
+
+			chunker.ReleaseChunk(chunk)
+		}
+
+		return stationTable, histograms
+	}
+}
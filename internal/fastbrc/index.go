@@ -0,0 +1,416 @@
+package fastbrc
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"maps"
+	"math/bits"
+	"os"
+	"slices"
+	"sync"
+	"sync/atomic"
+)
+
+// StationIndex maps the stations of an input to the chunks they occur in, so
+// the lines of a few stations are found without parsing the whole input. It
+// is saved next to the input, see IndexPath.
+//
+// On data in random order every station is in every chunk, the input is best
+// sorted first, see SortByStation.
+type StationIndex struct {
+	// Size and ModTime (in unix nanoseconds) of the indexed input, an index
+	// not matching its input is stale
+	Size    int64
+	ModTime int64
+	// Ends are the ends of the chunks, each starts where the one before ends
+	Ends []int64
+	// Chunks are the chunks of each station, as a bitset
+	Chunks map[string][]uint64
+}
+
+const (
+	indexMagic   = "BRCI"
+	indexVersion = 1
+)
+
+var ErrIndexFormat = errors.New("not a station index")
+
+// IndexPath is where the index of the input file path is saved
+func IndexPath(path string) string {
+	return path + ".idx"
+}
+
+// cutChunks returns the ends of the chunks of about chunkSize bytes of
+// input, cut after a '\n'
+func cutChunks(input []byte, chunkSize int) []int64 {
+	var ends []int64
+	for start := 0; start < len(input); {
+		end := min(start+max(chunkSize, 1), len(input))
+		if nl := bytes.LastIndexByte(input[start:end], '\n'); nl >= 0 {
+			end = start + nl + 1
+		} else if nl := bytes.IndexByte(input[end:], '\n'); nl >= 0 {
+			// a line longer than chunkSize
+			end += nl + 1
+		} else {
+			end = len(input)
+		}
+		ends = append(ends, int64(end))
+		start = end
+	}
+	return ends
+}
+
+// forEachLine calls fn with each line of chunk and its station name
+func forEachLine(chunk []byte, fn func(name, line []byte)) error {
+	for len(chunk) > 0 {
+		nl := bytes.IndexByte(chunk, '\n')
+		if nl < 0 {
+			return fmt.Errorf("last line without a '\\n': %.40q", chunk)
+		}
+		semi := bytes.IndexByte(chunk[:nl], ';')
+		if semi < 0 {
+			return fmt.Errorf("line without a ';': %.40q", chunk[:nl])
+		}
+		fn(chunk[:semi], chunk[:nl+1])
+		chunk = chunk[nl+1:]
+	}
+	return nil
+}
+
+// parallelChunks calls fn for each chunk of ends from nworkers goroutines,
+// it returns the first error
+func parallelChunks(input []byte, ends []int64, nworkers int, fn func(worker, i int, chunk []byte) error) error {
+	var next atomic.Int64
+	errs := make([]error, max(nworkers, 1))
+	wg := sync.WaitGroup{}
+	for w := range errs {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				i := int(next.Add(1) - 1)
+				if i >= len(ends) || errs[w] != nil {
+					return
+				}
+				var start int64
+				if i > 0 {
+					start = ends[i-1]
+				}
+				errs[w] = fn(w, i, input[start:ends[i]])
+			}
+		}()
+	}
+	wg.Wait()
+	return errors.Join(errs...)
+}
+
+// BuildIndex indexes the chunks of about chunkSize bytes of input with
+// nworkers goroutines
+func BuildIndex(input []byte, chunkSize, nworkers int) (*StationIndex, error) {
+	ix := &StationIndex{
+		Size:   int64(len(input)),
+		Ends:   cutChunks(input, chunkSize),
+		Chunks: make(map[string][]uint64),
+	}
+	words := (len(ix.Ends) + 63) / 64
+	chunks := make([]map[string][]uint64, max(nworkers, 1))
+	for i := range chunks {
+		chunks[i] = make(map[string][]uint64)
+	}
+	err := parallelChunks(input, ix.Ends, nworkers, func(w, i int, chunk []byte) error {
+		return forEachLine(chunk, func(name, _ []byte) {
+			set, ok := chunks[w][string(name)]
+			if !ok {
+				set = make([]uint64, words)
+				chunks[w][string(name)] = set
+			}
+			set[i/64] |= 1 << (i % 64)
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	for _, worker := range chunks {
+		for name, set := range worker {
+			merged, ok := ix.Chunks[name]
+			if !ok {
+				ix.Chunks[name] = set
+				continue
+			}
+			for i := range merged {
+				merged[i] |= set[i]
+			}
+		}
+	}
+	return ix, nil
+}
+
+// Stale reports whether the index doesn't match the input file fi
+func (ix *StationIndex) Stale(fi os.FileInfo) bool {
+	return fi.Size() != ix.Size || fi.ModTime().UnixNano() != ix.ModTime
+}
+
+// chunkRange returns the range of chunk i
+func (ix *StationIndex) chunkRange(i int) Range {
+	r := Range{End: int(ix.Ends[i])}
+	if i > 0 {
+		r.Start = int(ix.Ends[i-1])
+	}
+	return r
+}
+
+// Ranges returns the ranges of the chunks of names, in order. Consecutive
+// chunks are in the same range.
+func (ix *StationIndex) Ranges(names []string) []Range {
+	set := make([]uint64, (len(ix.Ends)+63)/64)
+	for _, name := range names {
+		for i, word := range ix.Chunks[name] {
+			set[i] |= word
+		}
+	}
+	var ranges []Range
+	for i, word := range set {
+		for word != 0 {
+			chunk := 64*i + bits.TrailingZeros64(word)
+			word &= word - 1
+			r := ix.chunkRange(chunk)
+			if n := len(ranges); n > 0 && ranges[n-1].End == r.Start {
+				ranges[n-1].End = r.End
+			} else {
+				ranges = append(ranges, r)
+			}
+		}
+	}
+	return ranges
+}
+
+// runs returns the runs of consecutive chunks of a bitset as first chunk
+// and length pairs
+func runs(set []uint64) [][2]int {
+	var runs [][2]int
+	for i, word := range set {
+		for word != 0 {
+			chunk := 64*i + bits.TrailingZeros64(word)
+			word &= word - 1
+			if n := len(runs); n > 0 && runs[n-1][0]+runs[n-1][1] == chunk {
+				runs[n-1][1]++
+			} else {
+				runs = append(runs, [2]int{chunk, 1})
+			}
+		}
+	}
+	return runs
+}
+
+// MarshalIndex encodes ix with varints, the chunks of a station as runs of
+// consecutive chunks: an index of random data, where stations are in every
+// chunk, or of sorted data, where they are in a few, is small.
+func MarshalIndex(ix *StationIndex) []byte {
+	b := []byte(indexMagic)
+	b = binary.AppendUvarint(b, indexVersion)
+	b = binary.AppendUvarint(b, uint64(ix.Size))
+	b = binary.AppendVarint(b, ix.ModTime)
+	b = binary.AppendUvarint(b, uint64(len(ix.Ends)))
+	var prev int64
+	for _, end := range ix.Ends {
+		b = binary.AppendUvarint(b, uint64(end-prev))
+		prev = end
+	}
+
+	names := slices.Sorted(maps.Keys(ix.Chunks))
+	b = binary.AppendUvarint(b, uint64(len(names)))
+	for _, name := range names {
+		b = binary.AppendUvarint(b, uint64(len(name)))
+		b = append(b, name...)
+		runs := runs(ix.Chunks[name])
+		b = binary.AppendUvarint(b, uint64(len(runs)))
+		next := 0
+		for _, r := range runs {
+			b = binary.AppendUvarint(b, uint64(r[0]-next))
+			b = binary.AppendUvarint(b, uint64(r[1]))
+			next = r[0] + r[1]
+		}
+	}
+	return b
+}
+
+// indexReader reads varints, keeping the first error
+type indexReader struct {
+	b   []byte
+	err error
+}
+
+func (r *indexReader) fail(format string, args ...any) {
+	if r.err == nil {
+		r.err = fmt.Errorf("%w: "+format, append([]any{ErrIndexFormat}, args...)...)
+	}
+}
+
+func (r *indexReader) uvarint() uint64 {
+	if r.err != nil {
+		return 0
+	}
+	v, n := binary.Uvarint(r.b)
+	if n <= 0 {
+		r.fail("truncated or invalid varint")
+		return 0
+	}
+	r.b = r.b[n:]
+	return v
+}
+
+func (r *indexReader) varint() int64 {
+	if r.err != nil {
+		return 0
+	}
+	v, n := binary.Varint(r.b)
+	if n <= 0 {
+		r.fail("truncated or invalid varint")
+		return 0
+	}
+	r.b = r.b[n:]
+	return v
+}
+
+// count reads a count of items taking at least a byte each
+func (r *indexReader) count() int {
+	n := r.uvarint()
+	if n > uint64(len(r.b)) {
+		r.fail("count %d larger than the index", n)
+		return 0
+	}
+	return int(n)
+}
+
+// UnmarshalIndex decodes an index encoded by MarshalIndex
+func UnmarshalIndex(b []byte) (*StationIndex, error) {
+	if !bytes.HasPrefix(b, []byte(indexMagic)) {
+		return nil, ErrIndexFormat
+	}
+	r := &indexReader{b: b[len(indexMagic):]}
+	if version := r.uvarint(); r.err == nil && version != indexVersion {
+		return nil, fmt.Errorf("unsupported index version %d, want %d", version, indexVersion)
+	}
+	ix := &StationIndex{Size: int64(r.uvarint()), ModTime: r.varint(), Chunks: make(map[string][]uint64)}
+	ix.Ends = make([]int64, r.count())
+	var end uint64
+	for i := range ix.Ends {
+		end += r.uvarint()
+		ix.Ends[i] = int64(end)
+	}
+	if end > uint64(ix.Size) || (r.err == nil && end != uint64(ix.Size)) {
+		r.fail("chunks end at %d, not at the end of the input", end)
+	}
+
+	nstations := r.count()
+	for range nstations {
+		n := r.count()
+		if r.err != nil {
+			break
+		}
+		name := string(r.b[:n])
+		r.b = r.b[n:]
+		set := make([]uint64, (len(ix.Ends)+63)/64)
+		next := uint64(0)
+		for range r.count() {
+			first := next + r.uvarint()
+			length := r.uvarint()
+			if first > uint64(len(ix.Ends)) || length > uint64(len(ix.Ends))-first {
+				r.fail("chunks of %s out of the index", name)
+				break
+			}
+			for i := first; i < first+length; i++ {
+				set[i/64] |= 1 << (i % 64)
+			}
+			next = first + length
+		}
+		ix.Chunks[name] = set
+	}
+	if r.err == nil && len(r.b) > 0 {
+		r.fail("%d trailing bytes", len(r.b))
+	}
+	if r.err != nil {
+		return nil, r.err
+	}
+	return ix, nil
+}
+
+// SortByStation writes the lines of input to w grouped by station: the
+// stations in byte order and the lines of a station in the input order.
+//
+// It's a counting sort in sections of about sectionSize bytes, from nworkers
+// goroutines: the first pass finds the stations and counts their bytes in
+// each section, which gives where the lines of a station in a section go once
+// the stations are numbered in order, and the second one groups the lines of
+// each section by station id and writes them there.
+func SortByStation(w io.WriterAt, input []byte, sectionSize, nworkers int) error {
+	ends := cutChunks(input, sectionSize)
+	// the stations of each section, in the order they occur, and their sizes
+	sectionNames := make([][]string, len(ends))
+	sizes := make([][]int64, len(ends))
+	err := parallelChunks(input, ends, nworkers, func(_, i int, section []byte) error {
+		local := make(map[string]int)
+		return forEachLine(section, func(name, line []byte) {
+			j, ok := local[string(name)]
+			if !ok {
+				j = len(sectionNames[i])
+				local[string(name)] = j
+				sectionNames[i] = append(sectionNames[i], string(name))
+				sizes[i] = append(sizes[i], 0)
+			}
+			sizes[i][j] += int64(len(line))
+		})
+	})
+	if err != nil {
+		return err
+	}
+
+	names := slices.Concat(sectionNames...)
+	slices.Sort(names)
+	names = slices.Compact(names)
+	// the id of a station is its rank
+	ids := make(map[string]int, len(names))
+	for id, name := range names {
+		ids[name] = id
+	}
+	// offsets of the lines of each station in the output, per section, by id
+	offsets := make([][]int64, len(ends))
+	for i := range offsets {
+		offsets[i] = make([]int64, len(names))
+		for j, name := range sectionNames[i] {
+			offsets[i][ids[name]] = sizes[i][j]
+		}
+	}
+	var pos int64
+	for id := range names {
+		for _, section := range offsets {
+			size := section[id]
+			section[id] = pos
+			pos += size
+		}
+	}
+
+	return parallelChunks(input, ends, nworkers, func(_, i int, section []byte) error {
+		groups := make([][]byte, len(names))
+		err := forEachLine(section, func(name, line []byte) {
+			id := ids[string(name)]
+			groups[id] = append(groups[id], line...)
+		})
+		if err != nil {
+			return err
+		}
+		for id, lines := range groups {
+			if len(lines) == 0 {
+				continue
+			}
+			if _, err := w.WriteAt(lines, offsets[i][id]); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
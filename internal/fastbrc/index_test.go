@@ -0,0 +1,108 @@
+package fastbrc
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestBuildIndex(t *testing.T) {
+	data := generateMeasurements(10000)
+	ix, err := BuildIndex(data, 1024, 3)
+	require.NoError(t, err)
+	assert.Equal(t, int64(len(data)), ix.Size)
+	assert.Len(t, ix.Chunks, 8)
+
+	start := int64(0)
+	for i, end := range ix.Ends {
+		assert.Equal(t, byte('\n'), data[end-1])
+		assert.LessOrEqual(t, end-start, int64(1024))
+		in := make(map[string]bool)
+		for _, line := range strings.Split(strings.TrimSuffix(string(data[start:end]), "\n"), "\n") {
+			in[strings.Split(line, ";")[0]] = true
+		}
+		for name, set := range ix.Chunks {
+			assert.Equal(t, in[name], set[i/64]&(1<<(i%64)) != 0, "%s in chunk %d", name, i)
+		}
+		start = end
+	}
+	assert.Equal(t, int64(len(data)), start)
+
+	b := MarshalIndex(ix)
+	got, err := UnmarshalIndex(b)
+	require.NoError(t, err)
+	assert.Equal(t, ix, got)
+	for _, n := range []int{0, 3, 10, len(b) / 2, len(b) - 1} {
+		_, err := UnmarshalIndex(b[:n])
+		assert.ErrorIs(t, err, ErrIndexFormat, "truncated to %d", n)
+	}
+	_, err = UnmarshalIndex(append(b, 0))
+	assert.ErrorIs(t, err, ErrIndexFormat)
+
+	_, err = BuildIndex([]byte("Abha;1.0\nAbha 2.0\n"), 1024, 1)
+	assert.Error(t, err)
+}
+
+func TestSortByStation(t *testing.T) {
+	data := slices.Clip(generateMeasurements(20000))
+	filename := filepath.Join(t.TempDir(), "sorted.txt")
+	f, err := os.Create(filename)
+	require.NoError(t, err)
+	require.NoError(t, SortByStation(f, data, 4096, 3))
+	require.NoError(t, f.Close())
+	sorted, err := os.ReadFile(filename)
+	require.NoError(t, err)
+
+	lines := strings.Split(strings.TrimSuffix(string(data), "\n"), "\n")
+	slices.SortStableFunc(lines, func(a, b string) int {
+		return strings.Compare(a[:strings.IndexByte(a, ';')], b[:strings.IndexByte(b, ';')])
+	})
+	assert.Equal(t, strings.Join(lines, "\n")+"\n", string(sorted))
+
+	// the stations of a sorted input are in a few chunks
+	ix, err := BuildIndex(sorted, 4096, 3)
+	require.NoError(t, err)
+	ranges := ix.Ranges([]string{"Abha", "Zürich"})
+	require.Len(t, ranges, 2)
+	size := 0
+	for _, r := range ranges {
+		size += r.End - r.Start
+	}
+	assert.Less(t, size, len(sorted)/4+4*4096)
+	assert.Empty(t, ix.Ranges([]string{"Hamburg"}))
+
+	c := NewByteChunkerRanges(sorted, 100, 1024, ranges)
+	require.NoError(t, c.Run())
+	filter := &Filter{}
+	require.NoError(t, filter.Include("Abha"))
+	require.NoError(t, filter.Include("Zürich"))
+	all := stationsByName(ParseWorker(newSliceChunker(data, 4096)))
+	got := stationsByName(ParseWorkerFilter(noRelease{c}, filter))
+	assert.Equal(t, all["Abha"], got["Abha"])
+	assert.Equal(t, all["Zürich"], got["Zürich"])
+}
+
+func TestByteChunkerRanges(t *testing.T) {
+	data := slices.Clip(generateMeasurements(1000))
+	ends := cutChunks(data, 100)
+	ranges := []Range{{0, int(ends[2])}, {int(ends[5]), int(ends[6])}, {int(ends[len(ends)-3]), len(data)}}
+	c := NewByteChunkerRanges(data, 1000, 64, ranges)
+	require.NoError(t, c.Run())
+	var got, want []byte
+	for chunk := c.NextChunk(); chunk != nil; chunk = c.NextChunk() {
+		assert.LessOrEqual(t, len(*chunk), 64)
+		got = append(got, *chunk...)
+	}
+	for _, r := range ranges {
+		want = append(want, data[r.Start:r.End]...)
+	}
+	assert.True(t, bytes.Equal(want, got))
+
+	assert.Error(t, NewByteChunkerRanges(data, 1000, 64, []Range{{0, len(data) + 1}}).Run())
+}
@@ -0,0 +1,176 @@
+package fastbrc
+
+import (
+	"fmt"
+	"os"
+	"sync/atomic"
+
+	"golang.org/x/sys/unix"
+)
+
+// Input strategies, they control how the input file gets into memory.
+const (
+	// mmap + MADV_WILLNEED|MADV_SEQUENTIAL, the historical default
+	InputWillNeed = "willneed"
+	// mmap with MAP_POPULATE, the whole file is faulted in before parsing starts
+	InputPopulate = "populate"
+	// mmap + MADV_HUGEPAGE
+	InputHugePage = "hugepage"
+	// mmap + MADV_SEQUENTIAL and a goroutine touching pages ahead of the workers
+	InputReadahead = "readahead"
+	// pread(2) into reused buffers, no mmap
+	InputPread = "pread"
+)
+
+var InputStrategies = []string{InputWillNeed, InputPopulate, InputHugePage, InputReadahead, InputPread}
+
+// MmapFile maps filename read only and applies the mmap related part of
+// strategy. InputPread isn't an mmap strategy and is rejected.
+func MmapFile(filename string, strategy string) ([]byte, error) {
+	f, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	fi, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+
+	size := fi.Size()
+	if size <= 0 {
+		return nil, fmt.Errorf("mmap: file %q too small", filename)
+	}
+	if size != int64(int(size)) {
+		return nil, fmt.Errorf("mmap: file %q is too large", filename)
+	}
+
+	flags := unix.MAP_SHARED
+	var advices []int
+	switch strategy {
+	case InputWillNeed:
+		advices = []int{unix.MADV_WILLNEED | unix.MADV_SEQUENTIAL}
+	case InputPopulate:
+		if populateFlag == 0 {
+			return nil, fmt.Errorf("mmap: %s not supported on this platform", strategy)
+		}
+		flags |= populateFlag
+	case InputHugePage:
+		if hugePageAdvice == 0 {
+			return nil, fmt.Errorf("mmap: %s not supported on this platform", strategy)
+		}
+		advices = []int{hugePageAdvice, unix.MADV_SEQUENTIAL}
+	case InputReadahead:
+		advices = []int{unix.MADV_SEQUENTIAL}
+	default:
+		return nil, fmt.Errorf("mmap: unsupported input strategy %q", strategy)
+	}
+
+	data, err := unix.Mmap(int(f.Fd()), 0, int(size), unix.PROT_READ, flags)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, advice := range advices {
+		if err := unix.Madvise(data, advice); err != nil {
+			unix.Munmap(data)
+			return nil, fmt.Errorf("madvise: %w", err)
+		}
+	}
+
+	return data, nil
+}
+
+// Readahead touches the pages of b in order from a background goroutine, so
+// the page faults happen there instead of in the workers. It stays at most
+// window bytes ahead of the furthest chunk handed out to the workers.
+type Readahead struct {
+	b        []byte
+	window   int
+	consumed atomic.Int64
+	wake     chan struct{}
+	done     chan struct{}
+	sink     atomic.Uint32
+}
+
+func NewReadahead(b []byte, window int) *Readahead {
+	return &Readahead{
+		b:      b,
+		window: window,
+		wake:   make(chan struct{}, 1),
+		done:   make(chan struct{}),
+	}
+}
+
+// Run touches pages until the whole input was read or Stop is called.
+func (r *Readahead) Run() {
+	var sum byte
+	touched := 0
+	for touched < len(r.b) {
+		limit := min(int(r.consumed.Load())+r.window, len(r.b))
+		for ; touched < limit; touched += pagesize {
+			sum += r.b[touched]
+		}
+		if touched >= len(r.b) {
+			break
+		}
+
+		select {
+		case <-r.wake:
+		case <-r.done:
+			touched = len(r.b)
+		}
+	}
+	// keep the compiler from optimizing the reads away
+	r.sink.Store(uint32(sum))
+}
+
+// Stop makes Run return early. It must be called at most once.
+func (r *Readahead) Stop() {
+	close(r.done)
+}
+
+// observe records that the workers reached the end of chunk. Padded copies of
+// the last chunk are ignored, there is nothing left to read ahead by then.
+func (r *Readahead) observe(chunk []byte) {
+	if len(chunk) == 0 || !within(r.b, chunk) {
+		return
+	}
+	end := int64(offsetIn(r.b, chunk) + len(chunk))
+	for {
+		cur := r.consumed.Load()
+		if end <= cur {
+			return
+		}
+		if r.consumed.CompareAndSwap(cur, end) {
+			break
+		}
+	}
+	select {
+	case r.wake <- struct{}{}:
+	default:
+	}
+}
+
+// Wrap returns a ChunkGetter that reports the progress of g to the readahead
+// goroutine. g must serve chunks of the slice given to NewReadahead.
+func (r *Readahead) Wrap(g ChunkGetter) ChunkGetter {
+	return &readaheadGetter{g: g, r: r}
+}
+
+type readaheadGetter struct {
+	g ChunkGetter
+	r *Readahead
+}
+
+func (rg *readaheadGetter) NextChunk() *[]byte {
+	chunk := rg.g.NextChunk()
+	if chunk != nil {
+		rg.r.observe(*chunk)
+	}
+	return chunk
+}
+
+func (rg *readaheadGetter) ReleaseChunk(chunk *[]byte) {
+	rg.g.ReleaseChunk(chunk)
+}
@@ -0,0 +1,23 @@
+package fastbrc
+
+import (
+	"os"
+
+	"golang.org/x/sys/unix"
+)
+
+const (
+	populateFlag   = unix.MAP_POPULATE
+	hugePageAdvice = unix.MADV_HUGEPAGE
+)
+
+// EvictPageCache asks the kernel to drop the cached pages of filename, to
+// benchmark cold cache runs without dropping every cache on the host.
+func EvictPageCache(filename string) error {
+	f, err := os.Open(filename)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	return unix.Fadvise(int(f.Fd()), 0, 0, unix.FADV_DONTNEED)
+}
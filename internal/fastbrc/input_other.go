@@ -0,0 +1,14 @@
+//go:build !linux
+
+package fastbrc
+
+import "fmt"
+
+const (
+	populateFlag   = 0
+	hugePageAdvice = 0
+)
+
+func EvictPageCache(filename string) error {
+	return fmt.Errorf("page cache eviction is only supported on linux")
+}
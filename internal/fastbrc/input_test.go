@@ -0,0 +1,67 @@
+package fastbrc
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/sys/unix"
+)
+
+func TestMmapFile(t *testing.T) {
+	b := make([]byte, 0, 1024*1024)
+	for i := range 64 * 1024 {
+		b = fmt.Appendf(b, "station%d;%d.%d\n", i%413, i%100, i%10)
+	}
+	filename := filepath.Join(t.TempDir(), "measurements.txt")
+	require.NoError(t, os.WriteFile(filename, b, 0o644))
+
+	for _, strategy := range InputStrategies {
+		t.Run(strategy, func(t *testing.T) {
+			data, err := MmapFile(filename, strategy)
+			if strategy == InputPread {
+				assert.Error(t, err)
+				return
+			}
+			if runtime.GOOS != "linux" && (strategy == InputPopulate || strategy == InputHugePage) {
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, b, data)
+			assert.NoError(t, unix.Munmap(data))
+		})
+	}
+}
+
+func TestReadahead(t *testing.T) {
+	b := make([]byte, 0, 1024*1024)
+	for i := range 64 * 1024 {
+		b = fmt.Appendf(b, "station%d;%d.%d\n", i%413, i%100, i%10)
+	}
+
+	ra := NewReadahead(b, 4*pagesize)
+	done := make(chan struct{})
+	go func() {
+		ra.Run()
+		close(done)
+	}()
+
+	getter := ra.Wrap(NewAtomicChunker(b, 1000))
+	received := 0
+	for {
+		chunk := getter.NextChunk()
+		if chunk == nil {
+			break
+		}
+		received += len(*chunk)
+	}
+	assert.Equal(t, len(b), received)
+	assert.Equal(t, int64(len(b)), ra.consumed.Load())
+	// every page got touched, Run returns on its own
+	<-done
+}
@@ -130,23 +130,43 @@ func ParseFixedPoint16UnsafePtr(bp unsafe.Pointer, length int) int16 {
 	return value
 }
 
+// ParseTemperature parses the measurement at p, formatted as -?\d{1,2}\.\d\n,
+// without branches from a single unaligned 8 bytes load.
+// It returns the value and the length of the measurement including its \n.
+// 8 bytes are read from p, the caller has to make sure they are readable.
+//
+// '.' is the only byte of a valid measurement with bit 4 clear, besides '-',
+// which can only be the first one. The position of the dot gives the shift
+// aligning the digits to the same position whatever the length, then a
+// single multiply by 100, 10 and 1 at the right offsets adds them up.
+// Adapted from Quan Anh Mai's (merykitty) entry to the original challenge.
+func ParseTemperature(p unsafe.Pointer) (int16, int) {
+	word := *(*uint64)(p) // little endian, like the rest of the worker
+	dot := bits.TrailingZeros64(^word & 0x10101000)
+	signed := int64(^word<<59) >> 63 // -1 if '-', 0 otherwise
+	digits := ((word &^ uint64(signed&0xff)) << (28 - dot)) & 0x0f000f0f00
+	abs := int64(((digits * 0x640a0001) >> 32) & 0x3ff)
+	return int16((abs ^ signed) - signed), dot>>3 + 3
+}
+
 type ChunkGetter interface {
 	NextChunk() *[]byte
 	ReleaseChunk(*[]byte)
 }
 
 func ParseWorker(chunker ChunkGetter) []StationInt16 {
-	stationTable := make([]StationInt16, 65537)
+	return ParseWorkerFilter(chunker, nil)
+}
+
+// ParseWorkerFilter is ParseWorker dropping the lines of the stations excluded
+// by filter.
+func ParseWorkerFilter(chunker ChunkGetter, filter *Filter) []StationInt16 {
+	stationTable := newStationTable()
 	stationTablePtr := unsafe.Pointer(unsafe.SliceData(stationTable))
 	stationTableLen := uint64(len(stationTable))
 	stationSize := unsafe.Sizeof(StationInt16{})
-	for i := range stationTable {
-		stationTable[i].Min = 32767
-		stationTable[i].Max = -32767
-	}
 
 	var broadcastedDelim uint64 = 0x3b3b3b3b3b3b3b3b
-	var broadcastedNl uint64 = 0x0a0a0a0a0a0a0a0a
 
 	for {
 		chunk := chunker.NextChunk()
@@ -157,7 +177,7 @@ func ParseWorker(chunker ChunkGetter) []StationInt16 {
 		startpos := 0
 		chunklen := len(*chunk)
 		chunkp := unsafe.Pointer(unsafe.SliceData(*chunk))
-		var delim, nl int
+		var delim int
 		for startpos < chunklen {
 
 			// XXX this will access memory past the chunk if the data is invalid.
@@ -228,6 +248,7 @@ func ParseWorker(chunker ChunkGetter) []StationInt16 {
 			station := (*StationInt16)(unsafe.Add(stationTablePtr, (h%stationTableLen)*uint64(stationSize)))
 			if station.N == 0 {
 				station.Name = bytes.Clone(unsafe.Slice((*byte)(unsafe.Add(chunkp, startpos)), delim))
+				station.Excluded = filter.Excluded(station.Name)
 			}
 			// enable to check if there are collisions :-)
 			//if !bytes.Equal(station.Name, (*chunk)[startpos:startpos+delim]) {
@@ -239,16 +260,14 @@ func ParseWorker(chunker ChunkGetter) []StationInt16 {
 
 			startpos += delim + 1
 
-			// XXX this will access memory past the chunk if the data is invalid.
-			nl = indexBytePointerUnsafe8Bytes(unsafe.Add(chunkp, startpos), 8, '\n', broadcastedNl)
-			//if nl < 0 {
-			//	log.Fatal("garbage input, '\\n' not found")
-			//}
+			m, l := ParseTemperature(unsafe.Add(chunkp, startpos))
 
-			m := ParseFixedPoint16UnsafePtr(unsafe.Add(chunkp, startpos), nl)
-
-			station.NewMeasurement(m)
-			startpos += nl + 1
+			if filter == nil {
+				station.NewMeasurement(m)
+			} else {
+				station.Add(m)
+			}
+			startpos += l
 		}
 
 		chunker.ReleaseChunk(chunk)
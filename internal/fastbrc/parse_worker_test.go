@@ -0,0 +1,71 @@
+package fastbrc
+
+import (
+	"fmt"
+	"math/rand/v2"
+	"testing"
+	"unsafe"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseTemperature(t *testing.T) {
+	// whatever follows the \n must not matter
+	trailers := []string{"", "Abha;12", "\x00\x00", "\xff\xff\xff", "-.-.-."}
+
+	for v := -999; v <= 999; v++ {
+		s := fmt.Sprintf("%d.%d\n", v/10, max(v, -v)%10)
+		if v > -10 && v < 0 {
+			s = "-" + s
+		}
+		for _, trailer := range trailers {
+			// exactly 8 bytes, nothing past the buffer is read
+			buf := make([]byte, 8)
+			copy(buf, s+trailer)
+
+			m, l := ParseTemperature(unsafe.Pointer(&buf[0]))
+			assert.Equalf(t, int16(v), m, "input %q", buf)
+			assert.Equalf(t, len(s), l, "input %q", buf)
+			assert.Equalf(t, ParseFixedPoint16UnsafePtr(unsafe.Pointer(&buf[0]), len(s)-1), m, "input %q", buf)
+		}
+	}
+}
+
+func BenchmarkParseTemperature(b *testing.B) {
+	inputs := make([][8]byte, 0, 1999)
+	for v := -999; v <= 999; v++ {
+		var buf [8]byte
+		copy(buf[:], fmt.Sprintf("%.1f\n", float64(v)/10))
+		inputs = append(inputs, buf)
+	}
+	// sorted inputs make the branches of the loop based parser predictable
+	rand.New(rand.NewPCG(5, 6)).Shuffle(len(inputs), func(i, j int) {
+		inputs[i], inputs[j] = inputs[j], inputs[i]
+	})
+
+	b.Run("ParseTemperature", func(b *testing.B) {
+		var sink int16
+		for i := range b.N {
+			m, l := ParseTemperature(unsafe.Pointer(&inputs[i%len(inputs)]))
+			sink += m + int16(l)
+		}
+		_ = sink
+	})
+	b.Run("IndexByte8Bytes+ParseFixedPoint16UnsafePtr", func(b *testing.B) {
+		var sink int16
+		for i := range b.N {
+			p := unsafe.Pointer(&inputs[i%len(inputs)])
+			nl := indexBytePointerUnsafe8Bytes(p, 8, '\n', 0x0a0a0a0a0a0a0a0a)
+			sink += ParseFixedPoint16UnsafePtr(p, nl) + int16(nl)
+		}
+		_ = sink
+	})
+}
+
+func BenchmarkParseWorker(b *testing.B) {
+	data := generateMeasurements(1000000)
+	b.SetBytes(int64(len(data)))
+	for range b.N {
+		ParseWorker(newSliceChunker(data, 256*1024))
+	}
+}
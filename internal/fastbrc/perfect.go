@@ -0,0 +1,273 @@
+package fastbrc
+
+import (
+	"cmp"
+	"fmt"
+	"math/bits"
+	"slices"
+	"unsafe"
+)
+
+// What to do with the stations missing from a StationSet
+const (
+	// count them in a regular hash table next to the perfect one
+	UnknownDynamic = "dynamic"
+	// fail the parsing
+	UnknownError = "error"
+	// drop their lines
+	UnknownSkip = "skip"
+	// drop their lines, but keep track of them to report how many there are
+	UnknownCount = "count"
+)
+
+var UnknownModes = []string{UnknownDynamic, UnknownError, UnknownSkip, UnknownCount}
+
+// StationSet is a minimal perfect hash of a known list of stations, built
+// with CHD (compress, hash and displace): names are spread in buckets, then
+// starting with the largest bucket, a seed placing all the names of the bucket
+// in free slots is searched. Buckets with a single name store the free slot
+// directly.
+// Every known name gets its own slot in [0, Len()), so the workers index a
+// dense table and only compare the name once to catch unknown stations.
+type StationSet struct {
+	// names by slot, zero padded for nameEqual
+	names [][]byte
+	// per bucket: seed of the slot hash when >= 0, ^slot otherwise
+	seeds []int32
+	// normalizes the names missing from the set before they're unknown, the
+	// names of the set are normalized, see LoadStationSet
+	normalize func(name string) string
+}
+
+// λ, average number of names per bucket
+const stationSetBucketSize = 4
+
+// NewStationSet builds the perfect hash of names, duplicates are ignored.
+func NewStationSet(names []string) (*StationSet, error) {
+	names = slices.Clone(names)
+	slices.Sort(names)
+	names = slices.Compact(names)
+	if len(names) == 0 {
+		return nil, fmt.Errorf("empty station list")
+	}
+
+	hashes := make([]uint64, len(names))
+	for i, name := range names {
+		if len(name) == 0 || len(name) >= 32 {
+			return nil, fmt.Errorf("station %q: names must be 1 to 31 bytes long", name)
+		}
+		hashes[i] = hashName(unsafe.Pointer(unsafe.StringData(name)), len(name))
+	}
+
+	sorted := slices.Clone(hashes)
+	slices.Sort(sorted)
+	for i := 1; i < len(sorted); i++ {
+		if sorted[i] == sorted[i-1] {
+			return nil, fmt.Errorf("2 stations have the same hash %#x", sorted[i])
+		}
+	}
+
+	s := &StationSet{
+		names: make([][]byte, len(names)),
+		seeds: make([]int32, (len(names)+stationSetBucketSize-1)/stationSetBucketSize),
+	}
+	buckets := make([][]int, len(s.seeds))
+	for i, h := range hashes {
+		b := reduce(h, uint64(len(s.seeds)))
+		buckets[b] = append(buckets[b], i)
+	}
+	order := make([]int, len(buckets))
+	for i := range order {
+		order[i] = i
+	}
+	slices.SortStableFunc(order, func(a, b int) int { return cmp.Compare(len(buckets[b]), len(buckets[a])) })
+
+	taken := make([]bool, len(names))
+	slots := make([]uint64, 0, 16)
+	free := 0 // singletons take free slots in order
+	for _, b := range order {
+		bucket := buckets[b]
+		switch len(bucket) {
+		case 0:
+			continue
+		case 1:
+			for taken[free] {
+				free++
+			}
+			taken[free] = true
+			s.names[free] = padName(names[bucket[0]])
+			s.seeds[b] = ^int32(free)
+			continue
+		}
+
+		found := false
+		for seed := range int32(1 << 24) {
+			slots = slots[:0]
+			for _, i := range bucket {
+				slot := s.seeded(hashes[i], seed)
+				if taken[slot] || slices.Contains(slots, slot) {
+					break
+				}
+				slots = append(slots, slot)
+			}
+			if len(slots) < len(bucket) {
+				continue
+			}
+			for j, slot := range slots {
+				taken[slot] = true
+				s.names[slot] = padName(names[bucket[j]])
+			}
+			s.seeds[b] = seed
+			found = true
+			break
+		}
+		if !found {
+			return nil, fmt.Errorf("no seed found for a bucket of %d stations", len(bucket))
+		}
+	}
+	return s, nil
+}
+
+// LoadStationSet builds the StationSet of the stations listed in filename,
+// see readStationList for the format. When normalize isn't nil, i.e
+// names.NFC, the names are normalized with it and the input names that are
+// written differently are matched once normalized too.
+func LoadStationSet(filename string, normalize func(name string) string) (*StationSet, error) {
+	names, err := readStationList(filename)
+	if err != nil {
+		return nil, err
+	}
+	if normalize != nil {
+		for i := range names {
+			names[i] = normalize(names[i])
+		}
+	}
+	s, err := NewStationSet(names)
+	if err != nil {
+		return nil, err
+	}
+	s.normalize = normalize
+	return s, nil
+}
+
+func padName(name string) []byte {
+	return append(make([]byte, 0, max(len(name), 16)), name...)
+}
+
+// reduce maps x to [0, n) with a multiply instead of a modulo
+func reduce(x, n uint64) uint64 {
+	hi, _ := bits.Mul64(x, n)
+	return hi
+}
+
+// seeded is the slot of hash h in a bucket displaced by seed
+func (s *StationSet) seeded(h uint64, seed int32) uint64 {
+	// murmur3 finalizer, the bucket was picked from the high bits of h
+	x := h ^ uint64(seed)*0x9e3779b97f4a7c15
+	x ^= x >> 33
+	x *= 0xff51afd7ed558ccd
+	x ^= x >> 33
+	return reduce(x, uint64(len(s.names)))
+}
+
+// slot returns the slot of hash h, only meaningful for known names
+func (s *StationSet) slot(h uint64) uint64 {
+	seed := s.seeds[reduce(h, uint64(len(s.seeds)))]
+	if seed < 0 {
+		return uint64(^seed)
+	}
+	return s.seeded(h, seed)
+}
+
+// Len is the number of stations in the set
+func (s *StationSet) Len() int {
+	return len(s.names)
+}
+
+// Lookup returns the slot of name, -1 if it isn't part of the set
+func (s *StationSet) Lookup(name []byte) int {
+	if len(name) == 0 || len(name) >= 32 {
+		return -1
+	}
+	// hashName and nameEqual read past name
+	padded := padName(string(name))
+	p := unsafe.Pointer(unsafe.SliceData(padded))
+	slot := s.slot(hashName(p, len(name)))
+	if !nameEqual(s.names[slot], p, len(name)) {
+		return -1
+	}
+	return int(slot)
+}
+
+// ParseWorker is ParseWorkerHash indexing a dense table with the perfect hash
+// of the stations. Names missing from the set are handled as set by unknown,
+// see UnknownModes, and are flagged Unknown in the returned table.
+func (s *StationSet) ParseWorker(chunker ChunkGetter, unknown string, filter *Filter) ([]StationInt16, error) {
+	stationTable := make([]StationInt16, len(s.names))
+	for i := range stationTable {
+		stationTable[i].Min = 32767
+		stationTable[i].Max = -32767
+	}
+	// unknown stations, only allocated when one shows up
+	var dynamicTable []StationInt16
+	// whether stations can be excluded, see StationInt16.Add
+	excluding := filter != nil || unknown != UnknownDynamic
+
+	var broadcastedDelim uint64 = 0x3b3b3b3b3b3b3b3b
+
+	for {
+		chunk := chunker.NextChunk()
+		if chunk == nil {
+			break
+		}
+
+		startpos := 0
+		chunklen := len(*chunk)
+		chunkp := unsafe.Pointer(unsafe.SliceData(*chunk))
+		for startpos < chunklen {
+			p := unsafe.Add(chunkp, startpos)
+			delim := indexBytePointerUnsafe8Bytes(p, 32, ';', broadcastedDelim)
+			h := hashName(p, delim)
+			slot := s.slot(h)
+
+			station := &stationTable[slot]
+			if !nameEqual(s.names[slot], p, delim) {
+				if unknown == UnknownError && s.normalize == nil {
+					chunker.ReleaseChunk(chunk)
+					return nil, fmt.Errorf("unknown station %q", unsafe.Slice((*byte)(p), delim))
+				}
+				if dynamicTable == nil {
+					dynamicTable = newStationTable()
+				}
+				station = &dynamicTable[probeSlot(dynamicTable, h, p, delim)]
+				if station.N == 0 {
+					// a known station written differently, merged with it
+					// once normalized
+					known := s.normalize != nil && s.Lookup([]byte(s.normalize(string(station.Name)))) >= 0
+					if !known && unknown == UnknownError {
+						chunker.ReleaseChunk(chunk)
+						return nil, fmt.Errorf("unknown station %q", station.Name)
+					}
+					station.Unknown = !known
+					station.Excluded = !known && unknown != UnknownDynamic || filter.Excluded(station.Name)
+				}
+			} else if station.N == 0 {
+				station.Name = s.names[slot]
+				station.Excluded = filter.Excluded(station.Name)
+			}
+
+			startpos += delim + 1
+			m, l := ParseTemperature(unsafe.Add(chunkp, startpos))
+			if excluding {
+				station.Add(m)
+			} else {
+				station.NewMeasurement(m)
+			}
+			startpos += l
+		}
+
+		chunker.ReleaseChunk(chunk)
+	}
+
+	return append(stationTable, dynamicTable...), nil
+}
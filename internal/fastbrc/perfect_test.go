@@ -0,0 +1,175 @@
+package fastbrc
+
+import (
+	"fmt"
+	"maps"
+	"math/rand/v2"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+
+	"1brc/internal/names"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func randomStations(n int) []string {
+	r := rand.New(rand.NewPCG(3, 4))
+	const letters = "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ -'."
+	seen := make(map[string]bool, n)
+	names := make([]string, 0, n)
+	for len(names) < n {
+		b := make([]byte, 1+r.IntN(31))
+		for i := range b {
+			b[i] = letters[r.IntN(len(letters))]
+		}
+		if !seen[string(b)] {
+			seen[string(b)] = true
+			names = append(names, string(b))
+		}
+	}
+	return names
+}
+
+func TestStationSet(t *testing.T) {
+	names := randomStations(40000)
+	t0 := time.Now()
+	set, err := NewStationSet(names)
+	require.NoError(t, err)
+	t.Logf("built in %s", time.Since(t0))
+	require.Equal(t, len(names), set.Len())
+
+	slots := make([]bool, set.Len())
+	for _, name := range names {
+		slot := set.Lookup([]byte(name))
+		require.GreaterOrEqual(t, slot, 0, name)
+		assert.False(t, slots[slot], "slot %d taken twice", slot)
+		slots[slot] = true
+	}
+
+	for _, name := range randomStations(41000)[40000:] {
+		if !slices.Contains(names, name) {
+			assert.Equal(t, -1, set.Lookup([]byte(name)), name)
+		}
+	}
+	assert.Equal(t, -1, set.Lookup([]byte(names[0]+"x")))
+	assert.Equal(t, -1, set.Lookup(nil))
+}
+
+func TestNewStationSetErrors(t *testing.T) {
+	_, err := NewStationSet(nil)
+	assert.Error(t, err)
+	_, err = NewStationSet([]string{"Las Palmas de Gran Canaria, Spain"})
+	assert.Error(t, err)
+
+	// duplicates are fine
+	set, err := NewStationSet([]string{"Abha", "Abha", "Zürich"})
+	require.NoError(t, err)
+	assert.Equal(t, 2, set.Len())
+}
+
+func TestLoadStationSet(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "weather_stations.csv")
+	require.NoError(t, os.WriteFile(filename, []byte("# Adapted from https://simplemaps.com/data/world-cities\nAbha;18.0\nAbidjan;26.0\n\nZürich\n"), 0o644))
+	set, err := LoadStationSet(filename, nil)
+	require.NoError(t, err)
+	assert.Equal(t, 3, set.Len())
+	for _, name := range []string{"Abha", "Abidjan", "Zürich"} {
+		assert.NotEqual(t, -1, set.Lookup([]byte(name)), name)
+	}
+}
+
+func TestStationSetNormalize(t *testing.T) {
+	nfd := "Abe\u0301che\u0301"
+	filename := filepath.Join(t.TempDir(), "stations.txt")
+	require.NoError(t, os.WriteFile(filename, []byte("Abha\n"+nfd+"\n"), 0o644))
+	b := []byte("Abha;1.0\n" + nfd + ";2.0\nAbéché;3.0\nYo;4.0\n")
+
+	set, err := LoadStationSet(filename, names.NFC)
+	require.NoError(t, err)
+	assert.NotEqual(t, -1, set.Lookup([]byte("Abéché")))
+	table, err := set.ParseWorker(newSliceChunker(b, 4096), UnknownSkip, nil)
+	require.NoError(t, err)
+	stations := stationsByName(table)
+	assert.Equal(t, int32(1), stations["Abéché"].N)
+	assert.False(t, stations["Abéché"].Excluded)
+	// merged with Abéché once normalized
+	assert.Equal(t, int32(1), stations[nfd].N)
+	assert.False(t, stations[nfd].Unknown)
+	assert.False(t, stations[nfd].Excluded)
+	assert.True(t, stations["Yo"].Unknown)
+	assert.True(t, stations["Yo"].Excluded)
+
+	_, err = set.ParseWorker(newSliceChunker(b[:len(b)-len("Yo;4.0\n")], 4096), UnknownError, nil)
+	assert.NoError(t, err)
+	_, err = set.ParseWorker(newSliceChunker(b, 4096), UnknownError, nil)
+	assert.Error(t, err)
+
+	// without normalize, the name is unknown
+	set, err = LoadStationSet(filename, nil)
+	require.NoError(t, err)
+	_, err = set.ParseWorker(newSliceChunker(b, 4096), UnknownError, nil)
+	assert.Error(t, err)
+}
+
+func TestStationSetParseWorker(t *testing.T) {
+	b := generateMeasurements(100000)
+	expected := stationsByName(ParseWorker(newSliceChunker(b, 4096)))
+	names := slices.Sorted(maps.Keys(expected))
+
+	set, err := NewStationSet(names)
+	require.NoError(t, err)
+	for _, unknown := range UnknownModes {
+		table, err := set.ParseWorker(newSliceChunker(b, 4096), unknown, nil)
+		require.NoError(t, err)
+		assert.Equal(t, expected, stationsByName(table), unknown, nil)
+	}
+
+	// names[0] is missing
+	set, err = NewStationSet(names[1:])
+	require.NoError(t, err)
+	missing := expected[names[0]]
+	missing.Unknown = true
+	expected[names[0]] = missing
+	table, err := set.ParseWorker(newSliceChunker(b, 4096), UnknownDynamic, nil)
+	require.NoError(t, err)
+	assert.Equal(t, expected, stationsByName(table))
+
+	for _, unknown := range []string{UnknownSkip, UnknownCount} {
+		table, err := set.ParseWorker(newSliceChunker(b, 4096), unknown, nil)
+		require.NoError(t, err)
+		actual := stationsByName(table)
+		assert.Equal(t, StationInt16{Min: 32767, Max: -32767, N: missing.N, Excluded: true, Unknown: true, Name: []byte(names[0])}, actual[names[0]], unknown)
+		delete(actual, names[0])
+		assert.Equal(t, len(expected)-1, len(actual))
+		for name, station := range actual {
+			assert.Equal(t, expected[name], station, name)
+		}
+	}
+
+	_, err = set.ParseWorker(newSliceChunker(b, 4096), UnknownError, nil)
+	assert.EqualError(t, err, fmt.Sprintf("unknown station %q", names[0]))
+}
+
+func BenchmarkStationSetParseWorker(b *testing.B) {
+	data := generateMeasurements(1000000)
+	names := slices.Sorted(maps.Keys(stationsByName(ParseWorker(newSliceChunker(data, 256*1024)))))
+	set, err := NewStationSet(append(names, randomStations(40000)...))
+	require.NoError(b, err)
+
+	b.Run("xxh3", func(b *testing.B) {
+		b.SetBytes(int64(len(data)))
+		for range b.N {
+			ParseWorker(newSliceChunker(data, 256*1024))
+		}
+	})
+	b.Run("perfect", func(b *testing.B) {
+		b.SetBytes(int64(len(data)))
+		for range b.N {
+			set.ParseWorker(newSliceChunker(data, 256*1024), UnknownError, nil)
+		}
+	})
+}
@@ -0,0 +1,157 @@
+package fastbrc
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"sync/atomic"
+	"time"
+)
+
+// Progress counts the work of the workers, updated once per chunk when they
+// release it. It feeds the progress logs and the metrics endpoint.
+type Progress struct {
+	total   int64
+	start   time.Time
+	bytes   atomic.Int64
+	lines   atomic.Int64
+	workers []workerProgress
+	queued  Queued
+	rows    ChunkRows
+}
+
+// workerProgress is padded to its own cache line, workers update it
+// concurrently
+type workerProgress struct {
+	chunks atomic.Int64
+	_      [56]byte
+}
+
+// Queued is implemented by the chunkers handing out chunks through a
+// channel, it returns how many chunks wait in it
+type Queued interface {
+	Queued() (n, capacity int)
+}
+
+// ChunkRows is implemented by the chunkers of binary chunks, it returns the
+// number of rows of a chunk they served
+type ChunkRows interface {
+	ChunkRows(chunk []byte) int64
+}
+
+// NewProgress tracks nworkers processing total bytes. chunker is used for
+// the channel occupancy when it implements Queued, and for the rows of the
+// chunks when it implements ChunkRows.
+func NewProgress(total int64, nworkers int, chunker any) *Progress {
+	p := &Progress{
+		total:   total,
+		start:   time.Now(),
+		workers: make([]workerProgress, nworkers),
+	}
+	p.queued, _ = chunker.(Queued)
+	p.rows, _ = chunker.(ChunkRows)
+	return p
+}
+
+// Wrap returns a ChunkGetter counting the chunks of worker released to g.
+// Lines are counted again with bytes.Count on the chunk, hot in the cache,
+// unless the chunker counts the rows.
+func (p *Progress) Wrap(g ChunkGetter, worker int) ChunkGetter {
+	return &progressGetter{g: g, p: p, w: &p.workers[worker]}
+}
+
+type progressGetter struct {
+	g ChunkGetter
+	p *Progress
+	w *workerProgress
+}
+
+func (pg *progressGetter) NextChunk() *[]byte {
+	return pg.g.NextChunk()
+}
+
+func (pg *progressGetter) ReleaseChunk(chunk *[]byte) {
+	// before releasing, the chunk may be reused
+	pg.p.bytes.Add(int64(len(*chunk)))
+	if pg.p.rows != nil {
+		pg.p.lines.Add(pg.p.rows.ChunkRows(*chunk))
+	} else {
+		pg.p.lines.Add(int64(bytes.Count(*chunk, []byte{'\n'})))
+	}
+	pg.w.chunks.Add(1)
+	pg.g.ReleaseChunk(chunk)
+}
+
+// ProgressSnapshot is the state of a Progress at some point
+type ProgressSnapshot struct {
+	Total   int64
+	Bytes   int64
+	Lines   int64
+	Elapsed time.Duration
+	// Chunks processed by each worker
+	Chunks []int64
+	// Queued chunks and capacity of the channel of the chunker, -1 when
+	// it has none
+	Queued, QueueCapacity int
+}
+
+func (p *Progress) Snapshot() ProgressSnapshot {
+	s := ProgressSnapshot{
+		Total:         p.total,
+		Bytes:         p.bytes.Load(),
+		Lines:         p.lines.Load(),
+		Elapsed:       time.Since(p.start),
+		Chunks:        make([]int64, len(p.workers)),
+		Queued:        -1,
+		QueueCapacity: -1,
+	}
+	for i := range p.workers {
+		s.Chunks[i] = p.workers[i].chunks.Load()
+	}
+	if p.queued != nil {
+		s.Queued, s.QueueCapacity = p.queued.Queued()
+	}
+	return s
+}
+
+// LinesPerSecond is the average since the start
+func (s ProgressSnapshot) LinesPerSecond() float64 {
+	return float64(s.Lines) / s.Elapsed.Seconds()
+}
+
+// ETA extrapolates the time left from the average throughput, 0 when
+// nothing was processed yet
+func (s ProgressSnapshot) ETA() time.Duration {
+	if s.Bytes == 0 || s.Bytes >= s.Total {
+		return 0
+	}
+	return time.Duration(float64(s.Elapsed) * float64(s.Total-s.Bytes) / float64(s.Bytes))
+}
+
+// WriteMetrics writes s in the prometheus text format
+func (s ProgressSnapshot) WriteMetrics(w io.Writer) error {
+	var b []byte
+	metric := func(name, typ, help string) {
+		b = fmt.Appendf(b, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, typ)
+	}
+	metric("fastbrc_input_bytes", "gauge", "Size of the input.")
+	b = fmt.Appendf(b, "fastbrc_input_bytes %d\n", s.Total)
+	metric("fastbrc_processed_bytes_total", "counter", "Bytes of the input parsed.")
+	b = fmt.Appendf(b, "fastbrc_processed_bytes_total %d\n", s.Bytes)
+	metric("fastbrc_processed_lines_total", "counter", "Lines of the input parsed.")
+	b = fmt.Appendf(b, "fastbrc_processed_lines_total %d\n", s.Lines)
+	metric("fastbrc_elapsed_seconds", "gauge", "Time since the start of the parsing.")
+	b = fmt.Appendf(b, "fastbrc_elapsed_seconds %g\n", s.Elapsed.Seconds())
+	metric("fastbrc_worker_chunks_total", "counter", "Chunks parsed by each worker.")
+	for i, n := range s.Chunks {
+		b = fmt.Appendf(b, "fastbrc_worker_chunks_total{worker=\"%d\"} %d\n", i, n)
+	}
+	if s.QueueCapacity >= 0 {
+		metric("fastbrc_chunk_channel_length", "gauge", "Chunks waiting for a worker in the channel of the chunker.")
+		b = fmt.Appendf(b, "fastbrc_chunk_channel_length %d\n", s.Queued)
+		metric("fastbrc_chunk_channel_capacity", "gauge", "Capacity of the channel of the chunker.")
+		b = fmt.Appendf(b, "fastbrc_chunk_channel_capacity %d\n", s.QueueCapacity)
+	}
+	_, err := w.Write(b)
+	return err
+}
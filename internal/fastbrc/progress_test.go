@@ -0,0 +1,89 @@
+package fastbrc
+
+import (
+	"bytes"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// lockedChunker serves the chunks of a sliceChunker to several workers
+type lockedChunker struct {
+	mu sync.Mutex
+	c  *sliceChunker
+}
+
+func (c *lockedChunker) NextChunk() *[]byte {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.c.NextChunk()
+}
+
+func (c *lockedChunker) ReleaseChunk(*[]byte) {}
+
+func (c *lockedChunker) Queued() (int, int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return len(c.c.chunks), 4
+}
+
+func TestProgress(t *testing.T) {
+	b := generateMeasurements(100000)
+	chunker := &lockedChunker{c: newSliceChunker(b, 4096)}
+	nchunks := len(chunker.c.chunks)
+	p := NewProgress(int64(len(b)), 3, chunker)
+
+	var wg sync.WaitGroup
+	for i := range 3 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			ParseWorker(p.Wrap(chunker, i))
+		}()
+	}
+	wg.Wait()
+
+	s := p.Snapshot()
+	assert.Equal(t, int64(len(b)), s.Bytes)
+	assert.Equal(t, int64(100000), s.Lines)
+	var chunks int64
+	for _, n := range s.Chunks {
+		chunks += n
+	}
+	assert.Equal(t, int64(nchunks), chunks)
+	assert.Equal(t, 4, s.QueueCapacity)
+	assert.Equal(t, time.Duration(0), s.ETA())
+
+	var out bytes.Buffer
+	require.NoError(t, s.WriteMetrics(&out))
+	assert.Contains(t, out.String(), "\nfastbrc_processed_lines_total 100000\n")
+	assert.Contains(t, out.String(), "\nfastbrc_worker_chunks_total{worker=\"2\"} ")
+	for _, line := range strings.Split(strings.TrimSpace(out.String()), "\n") {
+		if !strings.HasPrefix(line, "#") {
+			assert.Len(t, strings.Fields(line), 2, line)
+		}
+	}
+}
+
+func TestProgressBRCB(t *testing.T) {
+	data := slices.Clip(generateMeasurements(10000))
+	b, _ := convertBRCB(t, data, 4096)
+	c, err := NewBRCBChunker(b)
+	require.NoError(t, err)
+	p := NewProgress(int64(len(b)), 1, c)
+	_, err = c.Worker(p.Wrap(noRelease{c}, 0), nil)
+	require.NoError(t, err)
+	assert.Equal(t, int64(10000), p.Snapshot().Lines)
+}
+
+func TestProgressETA(t *testing.T) {
+	s := ProgressSnapshot{Total: 1000, Bytes: 250, Elapsed: time.Second}
+	assert.Equal(t, 3*time.Second, s.ETA())
+	s.Bytes = 0
+	assert.Equal(t, time.Duration(0), s.ETA())
+}
@@ -0,0 +1,145 @@
+package fastbrc
+
+import (
+	"cmp"
+	"fmt"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// Fields of the merged stations a Query can filter and sort on
+var QueryFields = []string{"name", "mean", "min", "max", "count", "range"}
+
+// Query selects and orders the merged stations before they are printed.
+// The zero value keeps every station sorted by name.
+type Query struct {
+	// Where conditions must all match
+	Where []Condition
+	// SortBy is one of QueryFields, empty means name
+	SortBy string
+	// Desc reverses the order, ties are broken by ascending name
+	Desc bool
+	// Top keeps the first Top stations after sorting, 0 keeps all of them
+	Top int
+	// NameKey returns the key the names are sorted by in byte order, nil
+	// means the name itself
+	NameKey func(name string) string
+}
+
+// Condition compares a numeric field of a station to a value, i.e: max>45.0
+type Condition struct {
+	Field string
+	Op    string
+	Value float64
+}
+
+var conditionRe = regexp.MustCompile(`^\s*(mean|min|max|count|range)\s*(<=|>=|==|!=|<|>|=)\s*(-?[0-9]+(?:\.[0-9]*)?)\s*$`)
+
+// ParseCondition parses field op value, op is one of < <= > >= = == !=
+func ParseCondition(s string) (Condition, error) {
+	m := conditionRe.FindStringSubmatch(s)
+	if m == nil {
+		return Condition{}, fmt.Errorf("invalid condition %q, want <mean|min|max|count|range><op><number>", s)
+	}
+	v, err := strconv.ParseFloat(m[3], 64)
+	if err != nil {
+		return Condition{}, fmt.Errorf("invalid condition %q: %w", s, err)
+	}
+	return Condition{Field: m[1], Op: m[2], Value: v}, nil
+}
+
+// ParseSortBy parses field[:asc|:desc]. Without direction, names sort
+// ascending and the numeric fields descending, so the top stations come
+// first.
+func ParseSortBy(s string) (field string, desc bool, err error) {
+	field, dir, hasDir := strings.Cut(s, ":")
+	if !slices.Contains(QueryFields, field) {
+		return "", false, fmt.Errorf("invalid sort field %q, want one of %s", field, strings.Join(QueryFields, ", "))
+	}
+	desc = field != "name"
+	if hasDir {
+		switch dir {
+		case "asc":
+			desc = false
+		case "desc":
+			desc = true
+		default:
+			return "", false, fmt.Errorf("invalid sort direction %q, want asc or desc", dir)
+		}
+	}
+	return field, desc, nil
+}
+
+func (c Condition) Match(s *StationInt16) bool {
+	v := s.Value(c.Field)
+	switch c.Op {
+	case "<":
+		return v < c.Value
+	case "<=":
+		return v <= c.Value
+	case ">":
+		return v > c.Value
+	case ">=":
+		return v >= c.Value
+	case "=", "==":
+		return v == c.Value
+	case "!=":
+		return v != c.Value
+	}
+	panic("unknown operator " + c.Op)
+}
+
+// Apply returns the names of the stations selected by q, in order
+func (q *Query) Apply(stations map[string]*StationInt16) []string {
+	names := make([]string, 0, len(stations))
+	for name, s := range stations {
+		if !q.match(s) {
+			continue
+		}
+		names = append(names, name)
+	}
+
+	field := q.SortBy
+	if field == "" {
+		field = "name"
+	}
+	compareNames := strings.Compare
+	if q.NameKey != nil {
+		keys := make(map[string]string, len(names))
+		for _, name := range names {
+			keys[name] = q.NameKey(name)
+		}
+		compareNames = func(a, b string) int { return strings.Compare(keys[a], keys[b]) }
+	}
+	slices.SortFunc(names, func(a, b string) int {
+		var c int
+		if field == "name" {
+			c = compareNames(a, b)
+		} else {
+			c = cmp.Compare(stations[a].Value(field), stations[b].Value(field))
+		}
+		if q.Desc {
+			c = -c
+		}
+		if c == 0 {
+			c = compareNames(a, b)
+		}
+		return c
+	})
+
+	if q.Top > 0 && len(names) > q.Top {
+		names = names[:q.Top]
+	}
+	return names
+}
+
+func (q *Query) match(s *StationInt16) bool {
+	for _, c := range q.Where {
+		if !c.Match(s) {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,86 @@
+package fastbrc
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseCondition(t *testing.T) {
+	c, err := ParseCondition("max>45.0")
+	require.NoError(t, err)
+	assert.Equal(t, Condition{Field: "max", Op: ">", Value: 45}, c)
+
+	c, err = ParseCondition(" mean <= -3.5 ")
+	require.NoError(t, err)
+	assert.Equal(t, Condition{Field: "mean", Op: "<=", Value: -3.5}, c)
+
+	for _, s := range []string{"", "max", "max>", "name=Abha", "max~3", "max>4..5"} {
+		_, err := ParseCondition(s)
+		assert.Error(t, err, s)
+	}
+}
+
+func TestParseSortBy(t *testing.T) {
+	tests := []struct {
+		s     string
+		field string
+		desc  bool
+	}{
+		{"name", "name", false},
+		{"name:desc", "name", true},
+		{"mean", "mean", true},
+		{"mean:asc", "mean", false},
+		{"range", "range", true},
+	}
+	for _, tt := range tests {
+		field, desc, err := ParseSortBy(tt.s)
+		require.NoError(t, err, tt.s)
+		assert.Equal(t, tt.field, field, tt.s)
+		assert.Equal(t, tt.desc, desc, tt.s)
+	}
+	for _, s := range []string{"", "total", "mean:up"} {
+		_, _, err := ParseSortBy(s)
+		assert.Error(t, err, s)
+	}
+}
+
+func TestQuery(t *testing.T) {
+	stations := map[string]*StationInt16{
+		"Abha":    {Min: -50, Max: 460, Total: 1800, N: 100},
+		"Dakar":   {Min: 120, Max: 400, Total: 2400, N: 100},
+		"Oslo":    {Min: -250, Max: 300, Total: 570, N: 100},
+		"Yakutsk": {Min: -600, Max: 320, Total: -880, N: 10},
+		"Zürich":  {Min: -100, Max: 340, Total: 4800, N: 200},
+	}
+
+	zurichFirst := func(name string) string {
+		if name == "Zürich" {
+			return ""
+		}
+		return name
+	}
+
+	tests := []struct {
+		name     string
+		q        Query
+		expected []string
+	}{
+		{"all", Query{}, []string{"Abha", "Dakar", "Oslo", "Yakutsk", "Zürich"}},
+		{"hottest mean", Query{SortBy: "mean", Desc: true, Top: 3}, []string{"Dakar", "Zürich", "Abha"}},
+		{"coldest min", Query{SortBy: "min", Top: 1}, []string{"Yakutsk"}},
+		{"max above 45.0", Query{Where: []Condition{{"max", ">", 45}}}, []string{"Abha"}},
+		{"widest range", Query{SortBy: "range", Desc: true, Top: 3}, []string{"Yakutsk", "Oslo", "Abha"}},
+		// Dakar and Zürich have the same mean
+		{"ties by name", Query{SortBy: "mean", Desc: true, Where: []Condition{{"mean", "==", 2.4}}}, []string{"Dakar", "Zürich"}},
+		{"count", Query{SortBy: "count", Where: []Condition{{"count", ">=", 100}, {"min", "<", 0}}}, []string{"Abha", "Oslo", "Zürich"}},
+		{"name desc", Query{SortBy: "name", Desc: true, Top: 2}, []string{"Zürich", "Yakutsk"}},
+		{"name key", Query{NameKey: zurichFirst, Top: 2}, []string{"Zürich", "Abha"}},
+		{"ties by name key", Query{SortBy: "mean", NameKey: zurichFirst, Where: []Condition{{"mean", "==", 2.4}}}, []string{"Zürich", "Dakar"}},
+		{"nothing", Query{Where: []Condition{{"max", ">", 99.9}}}, []string{}},
+	}
+	for _, tt := range tests {
+		assert.Equal(t, tt.expected, tt.q.Apply(stations), tt.name)
+	}
+}
@@ -0,0 +1,153 @@
+package fastbrc
+
+import (
+	"bytes"
+	"fmt"
+	"math/bits"
+	"unsafe"
+)
+
+// masks of a 64 bytes block: bit i is set when byte i is a ';' or a '\n'
+
+// byteMask returns a mask with the high bit of every byte of x equal to 0
+// set. Unlike the cheaper test used by indexBytePointerUnsafe8Bytes, there
+// are no false positives after the first match, every bit can be used.
+func byteMask(x uint64) uint64 {
+	t := (x & 0x7f7f7f7f7f7f7f7f) + 0x7f7f7f7f7f7f7f7f
+	return ^(t | x | 0x7f7f7f7f7f7f7f7f)
+}
+
+// gatherMask packs the high bit of each byte of m into the low 8 bits
+func gatherMask(m uint64) uint64 {
+	return ((m >> 7) * 0x0102040810204080) >> 56
+}
+
+// scanBlockSWAR is the portable version of scanBlock, 8 bytes at a time.
+func scanBlockSWAR(p unsafe.Pointer) (semi, nl uint64) {
+	const broadcastedDelim uint64 = 0x3b3b3b3b3b3b3b3b
+	const broadcastedNl uint64 = 0x0a0a0a0a0a0a0a0a
+	for i := 0; i < 64; i += 8 {
+		w := *(*uint64)(unsafe.Add(p, i))
+		semi |= gatherMask(byteMask(w^broadcastedDelim)) << i
+		nl |= gatherMask(byteMask(w^broadcastedNl)) << i
+	}
+	return semi, nl
+}
+
+// scanChunkBlock returns the masks of the block starting at base. The last
+// block of a chunk is copied in tmp so nothing past the chunk is read.
+func scanChunkBlock(chunkp unsafe.Pointer, chunklen, base int, tmp *[64]byte) (semi, nl uint64) {
+	if base+64 <= chunklen {
+		return scanBlock(unsafe.Add(chunkp, base))
+	}
+	*tmp = [64]byte{}
+	copy(tmp[:], unsafe.Slice((*byte)(unsafe.Add(chunkp, base)), chunklen-base))
+	return scanBlock(unsafe.Pointer(tmp))
+}
+
+// LineScanMasks walks the lines of chunk with the block masks and returns the
+// number of lines. It exists to compare the scanning strategies in isolation,
+// an unterminated last line isn't counted.
+func LineScanMasks(chunk []byte) int {
+	var tmp [64]byte
+	chunkp := unsafe.Pointer(unsafe.SliceData(chunk))
+	chunklen := len(chunk)
+	base := 0
+	semi, nl := scanChunkBlock(chunkp, chunklen, base, &tmp)
+	lines := 0
+	for linestart := 0; linestart < chunklen; lines++ {
+		for semi == 0 {
+			base += 64
+			if base >= chunklen {
+				return lines
+			}
+			semi, nl = scanChunkBlock(chunkp, chunklen, base, &tmp)
+		}
+		semi &= semi - 1
+
+		for nl == 0 {
+			base += 64
+			if base >= chunklen {
+				return lines
+			}
+			semi, nl = scanChunkBlock(chunkp, chunklen, base, &tmp)
+		}
+		linestart = base + bits.TrailingZeros64(nl) + 1
+		nl &= nl - 1
+	}
+	return lines
+}
+
+// ParseWorkerMasks is ParseWorker with the line splitting done from the
+// masks of 64 bytes blocks: the chunk is scanned once for both delimiters
+// instead of running 2 searches per line. Every line of a chunk must end with
+// a '\n', it fails otherwise.
+func ParseWorkerMasks(chunker ChunkGetter) ([]StationInt16, error) {
+	return ParseWorkerMasksFilter(chunker, nil)
+}
+
+// ParseWorkerMasksFilter is ParseWorkerMasks dropping the lines of the
+// stations excluded by filter.
+func ParseWorkerMasksFilter(chunker ChunkGetter, filter *Filter) ([]StationInt16, error) {
+	stationTable := newStationTable()
+	stationTablePtr := unsafe.Pointer(unsafe.SliceData(stationTable))
+	stationTableLen := uint64(len(stationTable))
+	stationSize := unsafe.Sizeof(StationInt16{})
+
+	var tmp [64]byte
+	for {
+		chunk := chunker.NextChunk()
+		if chunk == nil {
+			break
+		}
+
+		chunklen := len(*chunk)
+		chunkp := unsafe.Pointer(unsafe.SliceData(*chunk))
+		base := 0
+		semi, nl := scanChunkBlock(chunkp, chunklen, base, &tmp)
+		for linestart := 0; linestart < chunklen; {
+			for semi == 0 {
+				base += 64
+				if base >= chunklen {
+					chunker.ReleaseChunk(chunk)
+					return nil, fmt.Errorf("line without a ';' at the end of the chunk: %.40q", (*chunk)[linestart:])
+				}
+				semi, nl = scanChunkBlock(chunkp, chunklen, base, &tmp)
+			}
+			delim := base + bits.TrailingZeros64(semi) - linestart
+			semi &= semi - 1
+
+			for nl == 0 {
+				base += 64
+				if base >= chunklen {
+					chunker.ReleaseChunk(chunk)
+					return nil, fmt.Errorf("last line of the chunk without a '\\n': %.40q", (*chunk)[linestart:])
+				}
+				semi, nl = scanChunkBlock(chunkp, chunklen, base, &tmp)
+			}
+			nlpos := base + bits.TrailingZeros64(nl)
+			nl &= nl - 1
+
+			p := unsafe.Add(chunkp, linestart)
+			h := hashName(p, delim)
+			station := (*StationInt16)(unsafe.Add(stationTablePtr, (h%stationTableLen)*uint64(stationSize)))
+			if station.N == 0 {
+				station.Name = bytes.Clone(unsafe.Slice((*byte)(p), delim))
+				station.Excluded = filter.Excluded(station.Name)
+			}
+
+			valuestart := linestart + delim + 1
+			m := ParseFixedPoint16UnsafePtr(unsafe.Add(chunkp, valuestart), nlpos-valuestart)
+			if filter == nil {
+				station.NewMeasurement(m)
+			} else {
+				station.Add(m)
+			}
+			linestart = nlpos + 1
+		}
+
+		chunker.ReleaseChunk(chunk)
+	}
+
+	return stationTable, nil
+}
@@ -0,0 +1,20 @@
+package fastbrc
+
+import (
+	"unsafe"
+
+	"golang.org/x/sys/cpu"
+)
+
+var useAVX2 = cpu.X86.HasAVX2
+
+//go:noescape
+func scanBlockAVX2(p unsafe.Pointer) (semi, nl uint64)
+
+// scanBlock returns the ';' and '\n' masks of the 64 bytes at p
+func scanBlock(p unsafe.Pointer) (semi, nl uint64) {
+	if useAVX2 {
+		return scanBlockAVX2(p)
+	}
+	return scanBlockSWAR(p)
+}
@@ -0,0 +1,34 @@
+#include "textflag.h"
+
+// func scanBlockAVX2(p unsafe.Pointer) (semi, nl uint64)
+TEXT ·scanBlockAVX2(SB), NOSPLIT, $0-24
+	MOVQ p+0(FP), SI
+
+	MOVQ $0x3b, AX
+	VMOVQ AX, X0
+	VPBROADCASTB X0, Y0
+	MOVQ $0x0a, AX
+	VMOVQ AX, X1
+	VPBROADCASTB X1, Y1
+
+	VMOVDQU (SI), Y2
+	VMOVDQU 32(SI), Y3
+
+	VPCMPEQB Y0, Y2, Y4
+	VPCMPEQB Y0, Y3, Y5
+	VPMOVMSKB Y4, AX
+	VPMOVMSKB Y5, BX
+	SHLQ $32, BX
+	ORQ BX, AX
+	MOVQ AX, semi+8(FP)
+
+	VPCMPEQB Y1, Y2, Y4
+	VPCMPEQB Y1, Y3, Y5
+	VPMOVMSKB Y4, AX
+	VPMOVMSKB Y5, BX
+	SHLQ $32, BX
+	ORQ BX, AX
+	MOVQ AX, nl+16(FP)
+
+	VZEROUPPER
+	RET
@@ -0,0 +1,36 @@
+package fastbrc
+
+import (
+	"testing"
+	"unsafe"
+)
+
+func BenchmarkScanBlock(b *testing.B) {
+	data := generateMeasurements(64 * 1024)
+	data = data[:len(data)&^63]
+	p := unsafe.Pointer(&data[0])
+
+	hasAVX2 := useAVX2
+	defer func() { useAVX2 = hasAVX2 }()
+	for _, avx2 := range []bool{false, true} {
+		if avx2 && !hasAVX2 {
+			continue
+		}
+		name := "SWAR"
+		if avx2 {
+			name = "AVX2"
+		}
+		b.Run(name, func(b *testing.B) {
+			useAVX2 = avx2
+			b.SetBytes(int64(len(data)))
+			var sink uint64
+			for range b.N {
+				for i := 0; i < len(data); i += 64 {
+					semi, nl := scanBlock(unsafe.Add(p, i))
+					sink += semi ^ nl
+				}
+			}
+			_ = sink
+		})
+	}
+}
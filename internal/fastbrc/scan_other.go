@@ -0,0 +1,10 @@
+//go:build !amd64
+
+package fastbrc
+
+import "unsafe"
+
+// scanBlock returns the ';' and '\n' masks of the 64 bytes at p
+func scanBlock(p unsafe.Pointer) (semi, nl uint64) {
+	return scanBlockSWAR(p)
+}
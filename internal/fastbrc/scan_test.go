@@ -0,0 +1,154 @@
+package fastbrc
+
+import (
+	"bytes"
+	"fmt"
+	"math/rand/v2"
+	"testing"
+	"unsafe"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/zeebo/xxh3"
+)
+
+// sliceChunker serves pre-split chunks, without any madvise on release
+type sliceChunker struct {
+	chunks [][]byte
+}
+
+func newSliceChunker(b []byte, chunkSize int) *sliceChunker {
+	c := &sliceChunker{}
+	ac := NewAtomicChunker(b, chunkSize)
+	for chunk := ac.NextChunk(); chunk != nil; chunk = ac.NextChunk() {
+		c.chunks = append(c.chunks, *chunk)
+	}
+	return c
+}
+
+func (c *sliceChunker) NextChunk() *[]byte {
+	if len(c.chunks) == 0 {
+		return nil
+	}
+	chunk := c.chunks[0]
+	c.chunks = c.chunks[1:]
+	return &chunk
+}
+
+func (c *sliceChunker) ReleaseChunk(*[]byte) {}
+
+func generateMeasurements(nlines int) []byte {
+	r := rand.New(rand.NewPCG(1, 2))
+	names := []string{"Abha", "Abéché", "Addis Ababa", "A", "St. John's", "Las Palmas de Gran Canaria", "Zürich", "Yo"}
+	b := make([]byte, 0, nlines*16)
+	for range nlines {
+		v := r.IntN(1999) - 999
+		sign := ""
+		if v < 0 {
+			sign = "-"
+			v = -v
+		}
+		b = fmt.Appendf(b, "%s;%s%d.%d\n", names[r.IntN(len(names))], sign, v/10, v%10)
+	}
+	return b
+}
+
+func naiveMasks(block []byte) (semi, nl uint64) {
+	for i, c := range block {
+		if c == ';' {
+			semi |= 1 << i
+		}
+		if c == '\n' {
+			nl |= 1 << i
+		}
+	}
+	return semi, nl
+}
+
+func TestScanBlock(t *testing.T) {
+	r := rand.New(rand.NewPCG(3, 4))
+	alphabet := []byte(";\n:\x0b\x00\xffa9")
+	for range 10000 {
+		var block [64]byte
+		for i := range block {
+			block[i] = alphabet[r.IntN(len(alphabet))]
+		}
+		expectedSemi, expectedNl := naiveMasks(block[:])
+
+		semi, nl := scanBlockSWAR(unsafe.Pointer(&block))
+		assert.Equal(t, expectedSemi, semi, "swar ;")
+		assert.Equal(t, expectedNl, nl, "swar \\n")
+
+		semi, nl = scanBlock(unsafe.Pointer(&block))
+		assert.Equal(t, expectedSemi, semi, "scanBlock ;")
+		assert.Equal(t, expectedNl, nl, "scanBlock \\n")
+	}
+}
+
+func TestLineScanMasks(t *testing.T) {
+	b := generateMeasurements(10000)
+	for _, l := range []int{0, 1, 63, 64, 65, 1000} {
+		chunk := b[:bytes.LastIndexByte(b[:l+1], '\n')+1]
+		assert.Equal(t, bytes.Count(chunk, []byte{'\n'}), LineScanMasks(chunk), "len %d", len(chunk))
+	}
+}
+
+func TestHashName(t *testing.T) {
+	b := []byte("abcdefghijklmnopqrstuvwxyzABCDEF")
+	for l := range 32 {
+		assert.Equal(t, xxh3.Hash(b[:l]), hashName(unsafe.Pointer(&b[0]), l), "len %d", l)
+	}
+}
+
+func TestHashBytes(t *testing.T) {
+	r := rand.New(rand.NewPCG(1, 2))
+	b := make([]byte, 3000)
+	for i := range b {
+		b[i] = byte(r.Uint32())
+	}
+	for l := range len(b) {
+		assert.Equal(t, xxh3.Hash(b[:l]), hashBytes(b[:l]), "len %d", l)
+	}
+	// a dedup block
+	block := bytes.Repeat(b, 400)
+	assert.Equal(t, xxh3.Hash(block), hashBytes(block))
+}
+
+func TestParseWorkerMasks(t *testing.T) {
+	b := generateMeasurements(100000)
+	expected := ParseWorker(newSliceChunker(b, 4096))
+	actual, err := ParseWorkerMasks(newSliceChunker(b, 4096))
+	require.NoError(t, err)
+	assert.Equal(t, expected, actual)
+}
+
+func TestParseWorkerMasksUnterminated(t *testing.T) {
+	b := generateMeasurements(1000)
+	for _, l := range []int{1, 2, 64, 100} {
+		// AtomicChunker leaves the last line as it is in the input
+		_, err := ParseWorkerMasks(newSliceChunker(b[:len(b)-l], 4096))
+		assert.Error(t, err, "cut %d", l)
+	}
+	_, err := ParseWorkerMasks(newSliceChunker([]byte("Abha;1.0\nZürich\n"), 4096))
+	assert.Error(t, err)
+	assert.Equal(t, 1, LineScanMasks([]byte("Abha;1.0\nZürich;2")))
+}
+
+func BenchmarkParseWorkerScan(b *testing.B) {
+	data := generateMeasurements(1000000)
+	workers := map[string]func(ChunkGetter) []StationInt16{
+		"IndexByte8Bytes": ParseWorker,
+		"Masks64": func(g ChunkGetter) []StationInt16 {
+			table, _ := ParseWorkerMasks(g)
+			return table
+		},
+	}
+	for name, worker := range workers {
+		b.Run(name, func(b *testing.B) {
+			b.SetBytes(int64(len(data)))
+			for range b.N {
+				worker(newSliceChunker(data, 256*1024))
+			}
+		})
+	}
+}
@@ -0,0 +1,45 @@
+package fastbrc
+
+import "unsafe"
+
+// ParseWorkerSquares is ParseWorkerHash with xxh3 also summing the squares of
+// the measurements of each station, for their standard deviation. squares is
+// indexed like the table. The sums are exact, in hundredths of degrees
+// squared, so they don't depend on how chunks were dealt.
+func ParseWorkerSquares(chunker ChunkGetter, filter *Filter) (table []StationInt16, squares []int64) {
+	stationTable := newStationTable()
+	squares = make([]int64, len(stationTable))
+
+	var broadcastedDelim uint64 = 0x3b3b3b3b3b3b3b3b
+
+	for {
+		chunk := chunker.NextChunk()
+		if chunk == nil {
+			break
+		}
+
+		startpos := 0
+		chunklen := len(*chunk)
+		chunkp := unsafe.Pointer(unsafe.SliceData(*chunk))
+		for startpos < chunklen {
+			p := unsafe.Add(chunkp, startpos)
+			delim := indexBytePointerUnsafe8Bytes(p, 32, ';', broadcastedDelim)
+			slot := stationSlot(stationTable, hashName(p, delim), p, delim, filter)
+			station := &stationTable[slot]
+
+			startpos += delim + 1
+			m, l := ParseTemperature(unsafe.Add(chunkp, startpos))
+			if filter == nil {
+				station.NewMeasurement(m)
+			} else {
+				station.Add(m)
+			}
+			squares[slot] += int64(m) * int64(m)
+			startpos += l
+		}
+
+		chunker.ReleaseChunk(chunk)
+	}
+
+	return stationTable, squares
+}
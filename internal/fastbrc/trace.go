@@ -0,0 +1,38 @@
+package fastbrc
+
+import (
+	"context"
+	"runtime/trace"
+)
+
+// TraceChunks returns a ChunkGetter recording the wait for each chunk of g
+// and its parsing in runtime/trace regions, to see in go tool trace whether
+// the workers starve. The parsing region ends when the chunk is released,
+// the getter must be used by a single worker.
+func TraceChunks(ctx context.Context, g ChunkGetter) ChunkGetter {
+	return &traceGetter{ctx: ctx, g: g}
+}
+
+type traceGetter struct {
+	ctx   context.Context
+	g     ChunkGetter
+	parse *trace.Region
+}
+
+func (tg *traceGetter) NextChunk() *[]byte {
+	wait := trace.StartRegion(tg.ctx, "wait chunk")
+	chunk := tg.g.NextChunk()
+	wait.End()
+	if chunk != nil {
+		tg.parse = trace.StartRegion(tg.ctx, "parse chunk")
+	}
+	return chunk
+}
+
+func (tg *traceGetter) ReleaseChunk(chunk *[]byte) {
+	if tg.parse != nil {
+		tg.parse.End()
+		tg.parse = nil
+	}
+	tg.g.ReleaseChunk(chunk)
+}
@@ -0,0 +1,22 @@
+package fastbrc
+
+import (
+	"context"
+	"io"
+	"runtime/trace"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTraceChunks(t *testing.T) {
+	b := generateMeasurements(10000)
+	expected := ParseWorker(newSliceChunker(b, 4096))
+
+	require.NoError(t, trace.Start(io.Discard))
+	defer trace.Stop()
+	ctx, task := trace.NewTask(context.Background(), "test")
+	defer task.End()
+	assert.Equal(t, expected, ParseWorker(TraceChunks(ctx, newSliceChunker(b, 4096))))
+}
@@ -0,0 +1,420 @@
+package fastbrc
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"sync/atomic"
+	"unsafe"
+
+	"golang.org/x/sys/unix"
+)
+
+// Minimal io_uring(7) bindings, only what is needed to keep reads in flight.
+// See include/uapi/linux/io_uring.h for the layouts.
+
+const (
+	ioringOffSqRing = 0
+	ioringOffCqRing = 0x8000000
+	ioringOffSqes   = 0x10000000
+
+	ioringFeatSingleMmap = 1 << 0
+	ioringEnterGetevents = 1 << 0
+	ioringOpRead         = 22
+
+	// reads must be aligned for O_DIRECT, 4k works on every device we care about
+	uringAlign = 4096
+)
+
+type ioSqringOffsets struct {
+	head        uint32
+	tail        uint32
+	ringMask    uint32
+	ringEntries uint32
+	flags       uint32
+	dropped     uint32
+	array       uint32
+	resv1       uint32
+	userAddr    uint64
+}
+
+type ioCqringOffsets struct {
+	head        uint32
+	tail        uint32
+	ringMask    uint32
+	ringEntries uint32
+	overflow    uint32
+	cqes        uint32
+	flags       uint32
+	resv1       uint32
+	userAddr    uint64
+}
+
+type ioUringParams struct {
+	sqEntries    uint32
+	cqEntries    uint32
+	flags        uint32
+	sqThreadCPU  uint32
+	sqThreadIdle uint32
+	features     uint32
+	wqFd         uint32
+	resv         [3]uint32
+	sqOff        ioSqringOffsets
+	cqOff        ioCqringOffsets
+}
+
+type ioUringSqe struct {
+	opcode      uint8
+	flags       uint8
+	ioprio      uint16
+	fd          int32
+	off         uint64
+	addr        uint64
+	len         uint32
+	rwFlags     uint32
+	userData    uint64
+	bufIndex    uint16
+	personality uint16
+	spliceFdIn  int32
+	addr3       uint64
+	_           uint64
+}
+
+type ioUringCqe struct {
+	userData uint64
+	res      int32
+	flags    uint32
+}
+
+type uring struct {
+	fd      int
+	sqRing  []byte
+	cqRing  []byte
+	sqesMem []byte
+
+	sqHead  *uint32
+	sqTail  *uint32
+	sqMask  uint32
+	sqArray unsafe.Pointer
+	sqes    unsafe.Pointer
+
+	cqHead *uint32
+	cqTail *uint32
+	cqMask uint32
+	cqes   unsafe.Pointer
+
+	pending uint32 // sqes queued but not submitted yet
+}
+
+func newUring(entries uint32) (*uring, error) {
+	var p ioUringParams
+	fd, _, errno := unix.Syscall(unix.SYS_IO_URING_SETUP, uintptr(entries), uintptr(unsafe.Pointer(&p)), 0)
+	if errno != 0 {
+		return nil, fmt.Errorf("io_uring_setup: %w", errno)
+	}
+
+	r := &uring{fd: int(fd)}
+	sqSize := int(p.sqOff.array + p.sqEntries*4)
+	cqSize := int(p.cqOff.cqes + p.cqEntries*uint32(unsafe.Sizeof(ioUringCqe{})))
+	singleMmap := p.features&ioringFeatSingleMmap != 0
+	if singleMmap {
+		sqSize = max(sqSize, cqSize)
+	}
+
+	var err error
+	r.sqRing, err = unix.Mmap(r.fd, ioringOffSqRing, sqSize, unix.PROT_READ|unix.PROT_WRITE, unix.MAP_SHARED|unix.MAP_POPULATE)
+	if err != nil {
+		r.close()
+		return nil, fmt.Errorf("mmap sq ring: %w", err)
+	}
+	if singleMmap {
+		r.cqRing = r.sqRing
+	} else {
+		r.cqRing, err = unix.Mmap(r.fd, ioringOffCqRing, cqSize, unix.PROT_READ|unix.PROT_WRITE, unix.MAP_SHARED|unix.MAP_POPULATE)
+		if err != nil {
+			r.close()
+			return nil, fmt.Errorf("mmap cq ring: %w", err)
+		}
+	}
+	r.sqesMem, err = unix.Mmap(r.fd, ioringOffSqes, int(p.sqEntries)*int(unsafe.Sizeof(ioUringSqe{})), unix.PROT_READ|unix.PROT_WRITE, unix.MAP_SHARED|unix.MAP_POPULATE)
+	if err != nil {
+		r.close()
+		return nil, fmt.Errorf("mmap sqes: %w", err)
+	}
+
+	sq := unsafe.Pointer(&r.sqRing[0])
+	r.sqHead = (*uint32)(unsafe.Add(sq, p.sqOff.head))
+	r.sqTail = (*uint32)(unsafe.Add(sq, p.sqOff.tail))
+	r.sqMask = *(*uint32)(unsafe.Add(sq, p.sqOff.ringMask))
+	r.sqArray = unsafe.Add(sq, p.sqOff.array)
+	r.sqes = unsafe.Pointer(&r.sqesMem[0])
+
+	cq := unsafe.Pointer(&r.cqRing[0])
+	r.cqHead = (*uint32)(unsafe.Add(cq, p.cqOff.head))
+	r.cqTail = (*uint32)(unsafe.Add(cq, p.cqOff.tail))
+	r.cqMask = *(*uint32)(unsafe.Add(cq, p.cqOff.ringMask))
+	r.cqes = unsafe.Add(cq, p.cqOff.cqes)
+
+	return r, nil
+}
+
+// queueRead adds a read of len(buf) bytes at off to the submission queue.
+// The caller must not queue more reads than the ring has entries.
+func (r *uring) queueRead(fd int, buf []byte, off int64, userData uint64) {
+	tail := atomic.LoadUint32(r.sqTail)
+	idx := tail & r.sqMask
+	sqe := (*ioUringSqe)(unsafe.Add(r.sqes, uintptr(idx)*unsafe.Sizeof(ioUringSqe{})))
+	*sqe = ioUringSqe{
+		opcode:   ioringOpRead,
+		fd:       int32(fd),
+		off:      uint64(off),
+		addr:     uint64(uintptr(unsafe.Pointer(&buf[0]))),
+		len:      uint32(len(buf)),
+		userData: userData,
+	}
+	*(*uint32)(unsafe.Add(r.sqArray, uintptr(idx)*4)) = idx
+	atomic.StoreUint32(r.sqTail, tail+1)
+	r.pending++
+}
+
+// submitAndWait submits the queued sqes and waits for at least one completion.
+func (r *uring) submitAndWait() error {
+	for {
+		_, _, errno := unix.Syscall6(unix.SYS_IO_URING_ENTER, uintptr(r.fd), uintptr(r.pending), 1, ioringEnterGetevents, 0, 0)
+		if errno == unix.EINTR {
+			continue
+		}
+		if errno != 0 {
+			return fmt.Errorf("io_uring_enter: %w", errno)
+		}
+		r.pending = 0
+		return nil
+	}
+}
+
+// reap calls f for every available completion.
+func (r *uring) reap(f func(userData uint64, res int32) error) error {
+	head := atomic.LoadUint32(r.cqHead)
+	tail := atomic.LoadUint32(r.cqTail)
+	for ; head != tail; head++ {
+		cqe := (*ioUringCqe)(unsafe.Add(r.cqes, uintptr(head&r.cqMask)*unsafe.Sizeof(ioUringCqe{})))
+		userData, res := cqe.userData, cqe.res
+		atomic.StoreUint32(r.cqHead, head+1)
+		if err := f(userData, res); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (r *uring) close() {
+	if r.sqesMem != nil {
+		unix.Munmap(r.sqesMem)
+	}
+	if r.cqRing != nil && &r.cqRing[0] != &r.sqRing[0] {
+		unix.Munmap(r.cqRing)
+	}
+	if r.sqRing != nil {
+		unix.Munmap(r.sqRing)
+	}
+	unix.Close(r.fd)
+}
+
+// uringBuf is one read buffer. The read lands at an aligned offset, the tail
+// of the previous block is copied right in front of it so the chunk handed to
+// the workers is contiguous.
+type uringBuf struct {
+	chunk []byte
+	mem   []byte // headroom + aligned read area
+	block int64  // index of the block in the file
+	n     int    // bytes read so far
+}
+
+// UringChunker reads the input with io_uring, keeping depth aligned reads in
+// flight. Completed reads are stitched back in file order and sent to the
+// workers through a channel, like Chunker.
+type UringChunker struct {
+	f         *os.File
+	size      int64
+	depth     int
+	chunkSize int
+	direct    bool
+	chunkCh   chan *[]byte
+	free      chan *uringBuf
+	// bufs maps the chunks handed out to their buffer, it's filled once by
+	// NewUringChunker and only read after
+	bufs map[*[]byte]*uringBuf
+}
+
+// NewUringChunker opens filename, with O_DIRECT when direct is set.
+// chunkSize is rounded up to a multiple of 4096.
+func NewUringChunker(filename string, chCap, chunkSize, depth int, direct bool) (*UringChunker, error) {
+	flags := os.O_RDONLY
+	if direct {
+		flags |= unix.O_DIRECT
+	}
+	f, err := os.OpenFile(filename, flags, 0)
+	if err != nil {
+		return nil, err
+	}
+	fi, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+
+	chunkSize = (chunkSize + uringAlign - 1) &^ (uringAlign - 1)
+	// enough buffers to keep depth reads in flight while the channel is full
+	// and as many workers as the channel capacity hold a chunk
+	nbufs := depth + 2*chCap + 1
+	c := &UringChunker{
+		f:         f,
+		size:      fi.Size(),
+		depth:     depth,
+		chunkSize: chunkSize,
+		direct:    direct,
+		chunkCh:   make(chan *[]byte, chCap),
+		free:      make(chan *uringBuf, nbufs),
+		bufs:      make(map[*[]byte]*uringBuf, nbufs),
+	}
+	for range nbufs {
+		// headroom + read area + alignment slack + pad for the workers
+		mem := make([]byte, uringAlign+chunkSize+uringAlign+chunkPad)
+		// align the read area
+		off := uringAlign - int(uintptr(unsafe.Pointer(&mem[uringAlign]))&(uringAlign-1))
+		if off == uringAlign {
+			off = 0
+		}
+		buf := &uringBuf{mem: mem[off : off+uringAlign+chunkSize]}
+		c.bufs[&buf.chunk] = buf
+		c.free <- buf
+	}
+	return c, nil
+}
+
+// Queued returns the number of chunks waiting in the channel, see Progress
+func (c *UringChunker) Queued() (n, capacity int) {
+	return len(c.chunkCh), cap(c.chunkCh)
+}
+
+func (c *UringChunker) NextChunk() *[]byte {
+	return <-c.chunkCh
+}
+
+func (c *UringChunker) ReleaseChunk(chunk *[]byte) {
+	c.free <- c.bufs[chunk]
+}
+
+func (c *UringChunker) Run() error {
+	defer c.f.Close()
+	defer close(c.chunkCh)
+
+	ring, err := newUring(uint32(c.depth))
+	if err != nil {
+		return err
+	}
+	defer ring.close()
+
+	fd := int(c.f.Fd())
+	nblocks := (c.size + int64(c.chunkSize) - 1) / int64(c.chunkSize)
+	completed := make(map[int64]*uringBuf, c.depth)
+	inflight := make(map[int64]*uringBuf, c.depth)
+	leftovers := make([]byte, 0, 256)
+	var nextSubmit, nextEmit int64
+
+	// submit queues the read of what is still missing in buf. The full
+	// block is always requested since O_DIRECT wants aligned lengths, the
+	// read of the last block is short.
+	submit := func(buf *uringBuf) {
+		inflight[buf.block] = buf
+		ring.queueRead(fd, buf.mem[uringAlign+buf.n:uringAlign+c.chunkSize], buf.block*int64(c.chunkSize)+int64(buf.n), uint64(buf.block))
+	}
+
+	for nextEmit < nblocks {
+		for len(inflight) < c.depth && nextSubmit < nblocks {
+			var buf *uringBuf
+			if len(inflight) == 0 && ring.pending == 0 {
+				buf = <-c.free
+			} else {
+				select {
+				case buf = <-c.free:
+				default:
+				}
+			}
+			if buf == nil {
+				break
+			}
+			buf.block, buf.n = nextSubmit, 0
+			submit(buf)
+			nextSubmit++
+		}
+
+		if err := ring.submitAndWait(); err != nil {
+			return err
+		}
+
+		err := ring.reap(func(userData uint64, res int32) error {
+			buf := inflight[int64(userData)]
+			delete(inflight, int64(userData))
+			if res < 0 {
+				return fmt.Errorf("read block %d: %w", userData, unix.Errno(-res))
+			}
+			prev := buf.n
+			buf.n += int(res)
+			want := int(min(int64(c.chunkSize), c.size-buf.block*int64(c.chunkSize)))
+			if buf.n < want {
+				if res == 0 {
+					return fmt.Errorf("read block %d: unexpected eof", userData)
+				}
+				if c.direct {
+					// O_DIRECT reads start aligned, the unaligned tail
+					// is read again
+					buf.n &^= uringAlign - 1
+					if buf.n <= prev {
+						return fmt.Errorf("read block %d: short O_DIRECT read of %d bytes", userData, res)
+					}
+				}
+				// short read, queue the rest
+				submit(buf)
+				return nil
+			}
+			completed[buf.block] = buf
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+
+		// emit in file order
+		for buf, ok := completed[nextEmit]; ok; buf, ok = completed[nextEmit] {
+			delete(completed, nextEmit)
+			nextEmit++
+
+			if len(leftovers) > uringAlign {
+				return fmt.Errorf("line longer than %d bytes", uringAlign)
+			}
+			start := uringAlign - len(leftovers)
+			copy(buf.mem[start:], leftovers)
+			leftovers = leftovers[:0]
+			data := buf.mem[start : uringAlign+buf.n]
+
+			if nextEmit == nblocks {
+				// last block, the last line might not have a \n
+				if data[len(data)-1] != '\n' {
+					data = append(data, '\n')
+				}
+			} else {
+				lastnl := bytes.LastIndexByte(data, '\n')
+				if lastnl == -1 {
+					return fmt.Errorf("missing \\n in block %d", buf.block)
+				}
+				leftovers = append(leftovers, data[lastnl+1:]...)
+				data = data[:lastnl+1]
+			}
+
+			buf.chunk = data
+			c.chunkCh <- &buf.chunk
+		}
+	}
+	return nil
+}
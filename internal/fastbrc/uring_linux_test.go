@@ -0,0 +1,66 @@
+package fastbrc
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/sys/unix"
+)
+
+func TestUringChunker(t *testing.T) {
+	b := make([]byte, 0, 1024*1024)
+	for i := range 64 * 1024 {
+		b = fmt.Appendf(b, "station%d;%d.%d\n", i%413, i%100, i%10)
+	}
+	dir := t.TempDir()
+
+	tcs := []struct {
+		name   string
+		input  []byte
+		direct bool
+	}{
+		{"buffered", b, false},
+		{"direct", b, true},
+		{"no-trailing-nl", b[:len(b)-1], false},
+	}
+	for _, tc := range tcs {
+		t.Run(tc.name, func(t *testing.T) {
+			filename := filepath.Join(dir, tc.name+".txt")
+			require.NoError(t, os.WriteFile(filename, tc.input, 0o644))
+
+			chunker, err := NewUringChunker(filename, 2, 8192, 4, tc.direct)
+			if tc.direct && errors.Is(err, unix.EINVAL) {
+				t.Skip("O_DIRECT not supported by the filesystem")
+			}
+			require.NoError(t, err)
+
+			errCh := make(chan error, 1)
+			go func() {
+				errCh <- chunker.Run()
+			}()
+
+			b2 := make([]byte, 0, len(b))
+			for {
+				chunk := chunker.NextChunk()
+				if chunk == nil {
+					break
+				}
+				require.Equalf(t, byte('\n'), (*chunk)[len(*chunk)-1], "chunk: %v", *chunk)
+				b2 = append(b2, *chunk...)
+				chunker.ReleaseChunk(chunk)
+			}
+
+			if err := <-errCh; errors.Is(err, unix.ENOSYS) || errors.Is(err, unix.EPERM) {
+				t.Skipf("io_uring not available: %s", err)
+			} else {
+				assert.NoError(t, err)
+			}
+			assert.Equal(t, b, b2)
+		})
+	}
+}
@@ -0,0 +1,15 @@
+//go:build !linux
+
+package fastbrc
+
+import "fmt"
+
+type UringChunker struct{}
+
+func NewUringChunker(filename string, chCap, chunkSize, depth int, direct bool) (*UringChunker, error) {
+	return nil, fmt.Errorf("io_uring is only supported on linux")
+}
+
+func (c *UringChunker) NextChunk() *[]byte         { return nil }
+func (c *UringChunker) ReleaseChunk(chunk *[]byte) {}
+func (c *UringChunker) Run() error                 { return nil }
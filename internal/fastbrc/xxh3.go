@@ -222,3 +222,136 @@ func mulFold64(x, y u64) u64 {
 	hi, lo := bits.Mul64(x, y)
 	return hi ^ lo
 }
+
+// hashName is the baby xxh3 inlined in ParseWorker, as a function for the
+// workers where the call overhead is acceptable.
+// l must be < 32, the longest station name is 26 bytes long.
+func hashName(p ptr, l int) u64 {
+	var acc u64
+	switch {
+	case l <= 16:
+		switch {
+		case l > 8: // 9-16
+			inputlo := readU64(p, 0) ^ (key64_024 ^ key64_032)
+			inputhi := readU64(p, ui(l)-8) ^ (key64_040 ^ key64_048)
+			folded := mulFold64(inputlo, inputhi)
+			return xxh3Avalanche(u64(l) + bits.ReverseBytes64(inputlo) + inputhi + folded)
+
+		case l > 3: // 4-8
+			input1 := readU32(p, 0)
+			input2 := readU32(p, ui(l)-4)
+			input64 := u64(input2) + u64(input1)<<32
+			keyed := input64 ^ (key64_008 ^ key64_016)
+			return rrmxmx(keyed, u64(l))
+
+		case l == 3: // 3
+			c12 := u64(readU16(p, 0))
+			c3 := u64(readU8(p, 2))
+			acc = c12<<16 + c3 + 3<<8
+			acc ^= u64(key32_000 ^ key32_004)
+			return xxhAvalancheSmall(acc)
+
+		case l > 1: // 2
+			c12 := u64(readU16(p, 0))
+			acc = c12*(1<<24+1)>>8 + 2<<8
+			acc ^= u64(key32_000 ^ key32_004)
+			return xxhAvalancheSmall(acc)
+
+		case l == 1: // 1
+			c1 := u64(readU8(p, 0))
+			acc = c1*(1<<24+1<<16+1) + 1<<8
+			acc ^= u64(key32_000 ^ key32_004)
+			return xxhAvalancheSmall(acc)
+
+		default: // 0
+			return 0x2d06800538d394c2 // xxh_avalanche(key64_056 ^ key64_064)
+		}
+
+	case l < 32:
+		acc = u64(l) * prime64_1
+		acc += mulFold64(readU64(p, 0*8)^key64_000, readU64(p, 1*8)^key64_008)
+		acc += mulFold64(readU64(p, ui(l)-2*8)^key64_016, readU64(p, ui(l)-1*8)^key64_024)
+		return xxh3Avalanche(acc)
+	default:
+		panic("input to baby xxh3 too long")
+	}
+}
+
+// hashBytes is xxh3 of b of any length, for the deduplication of lines and
+// blocks. Up to 31 bytes it is hashName.
+func hashBytes(b []byte) u64 {
+	p, l := ptr(unsafe.SliceData(b)), ui(len(b))
+	switch {
+	case l < 32:
+		return hashName(p, int(l))
+
+	case l <= 128:
+		acc := u64(l) * prime64_1
+		if l > 32 {
+			if l > 64 {
+				if l > 96 {
+					acc += mulFold64(readU64(p, 6*8)^key64_096, readU64(p, 7*8)^key64_104)
+					acc += mulFold64(readU64(p, l-8*8)^key64_112, readU64(p, l-7*8)^key64_120)
+				}
+				acc += mulFold64(readU64(p, 4*8)^key64_064, readU64(p, 5*8)^key64_072)
+				acc += mulFold64(readU64(p, l-6*8)^key64_080, readU64(p, l-5*8)^key64_088)
+			}
+			acc += mulFold64(readU64(p, 2*8)^key64_032, readU64(p, 3*8)^key64_040)
+			acc += mulFold64(readU64(p, l-4*8)^key64_048, readU64(p, l-3*8)^key64_056)
+		}
+		acc += mulFold64(readU64(p, 0*8)^key64_000, readU64(p, 1*8)^key64_008)
+		acc += mulFold64(readU64(p, l-2*8)^key64_016, readU64(p, l-1*8)^key64_024)
+		return xxh3Avalanche(acc)
+
+	case l <= 240:
+		acc := u64(l) * prime64_1
+		for i := ui(0); i < 8*16; i += 16 {
+			acc += mulFold64(readU64(p, i)^readU64(key, i), readU64(p, i+8)^readU64(key, i+8))
+		}
+		acc = xxh3Avalanche(acc)
+		for i := ui(8 * 16); i < l&^15; i += 16 {
+			acc += mulFold64(readU64(p, i)^readU64(key, i-125), readU64(p, i+8)^readU64(key, i-117))
+		}
+		acc += mulFold64(readU64(p, l-16)^key64_119, readU64(p, l-8)^key64_127)
+		return xxh3Avalanche(acc)
+
+	default:
+		accs := [8]u64{prime32_3, prime64_1, prime64_2, prime64_3, prime64_4, prime32_2, prime64_5, prime32_1}
+		blocks := (l - 1) / _block
+		for n := ui(0); n < blocks; n++ {
+			for s := ui(0); s < _block/_stripe; s++ {
+				accumulate(&accs, ptr(ui(p)+n*_block+s*_stripe), s*8)
+			}
+			// scramble with the last 64 bytes of the key
+			for i := range accs {
+				acc := accs[i]
+				acc ^= acc >> 47
+				acc ^= readU64(key, 128+ui(i)*8)
+				acc *= prime32_1
+				accs[i] = acc
+			}
+		}
+		for s := ui(0); s < ((l-1)-blocks*_block)/_stripe; s++ {
+			accumulate(&accs, ptr(ui(p)+blocks*_block+s*_stripe), s*8)
+		}
+		accumulate(&accs, ptr(ui(p)+l-_stripe), 121)
+
+		acc := u64(l) * prime64_1
+		acc += mulFold64(accs[0]^key64_011, accs[1]^key64_019)
+		acc += mulFold64(accs[2]^key64_027, accs[3]^key64_035)
+		acc += mulFold64(accs[4]^key64_043, accs[5]^key64_051)
+		acc += mulFold64(accs[6]^key64_059, accs[7]^key64_067)
+		return xxh3Avalanche(acc)
+	}
+}
+
+// accumulate mixes the 64 bytes stripe at p into accs, with the key at
+// offset k
+func accumulate(accs *[8]u64, p ptr, k ui) {
+	for i := range accs {
+		v := readU64(p, ui(i)*8)
+		keyed := v ^ readU64(key, k+ui(i)*8)
+		accs[i^1] += v
+		accs[i] += u64(u32(keyed)) * (keyed >> 32)
+	}
+}
@@ -0,0 +1,81 @@
+package format
+
+import "io"
+
+// encoderFlushSize is the size the buffer of the Encoder grows to before
+// being written out
+const encoderFlushSize = 64 * 1024
+
+// Encoder writes stations in the format of the challenge,
+// {name=min/mean/max, ...}, through a reusable buffer. Encoding a station
+// doesn't allocate.
+type Encoder struct {
+	w        io.Writer
+	rounding string
+	buf      []byte
+	n        int
+	err      error
+}
+
+func NewEncoder(w io.Writer, rounding string) *Encoder {
+	return &Encoder{
+		w:        w,
+		rounding: rounding,
+		buf:      make([]byte, 0, encoderFlushSize+256),
+	}
+}
+
+// Reset starts a new output on w, keeping the buffer
+func (e *Encoder) Reset(w io.Writer) {
+	e.w = w
+	e.buf = e.buf[:0]
+	e.n = 0
+	e.err = nil
+}
+
+func (e *Encoder) name(name string) {
+	if e.n == 0 {
+		e.buf = append(e.buf, '{')
+	} else {
+		e.buf = append(e.buf, ", "...)
+	}
+	e.n++
+	e.buf = append(e.buf, name...)
+	e.buf = append(e.buf, '=')
+}
+
+func (e *Encoder) flush() {
+	if e.err == nil && len(e.buf) > 0 {
+		_, e.err = e.w.Write(e.buf)
+	}
+	e.buf = e.buf[:0]
+}
+
+// Station encodes a station, min, max and total are in tenths
+func (e *Encoder) Station(name string, min, max, total, n int64) {
+	e.name(name)
+	e.buf = AppendStats(e.buf, min, max, total, n, e.rounding)
+	if len(e.buf) >= encoderFlushSize {
+		e.flush()
+	}
+}
+
+// FloatStation encodes a station of float values, see FloatStats
+func (e *Encoder) FloatStation(name string, min, max, total float64, n int64) {
+	e.name(name)
+	e.buf = AppendFloatStats(e.buf, min, max, total, n, e.rounding)
+	if len(e.buf) >= encoderFlushSize {
+		e.flush()
+	}
+}
+
+// Close ends the output and flushes the buffer. It returns the first error
+// of the writer.
+func (e *Encoder) Close() error {
+	if e.n == 0 {
+		e.buf = append(e.buf, '{')
+	}
+	e.buf = append(e.buf, '}')
+	e.flush()
+	return e.err
+}
@@ -0,0 +1,74 @@
+package format
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestEncoder(t *testing.T) {
+	var out strings.Builder
+	e := NewEncoder(&out, RoundHalfUp)
+	require.NoError(t, e.Close())
+	assert.Equal(t, "{}", out.String())
+
+	out.Reset()
+	e.Reset(&out)
+	e.Station("Abha", -15, 99, 15, 10)
+	e.FloatStation("Oslo", 0.05, 0.3, 0.1+0.2+0.3+0.05, 4)
+	require.NoError(t, e.Close())
+	assert.Equal(t, "{Abha=-1.5/0.2/9.9, Oslo=0.1/0.2/0.3}", out.String())
+}
+
+func TestEncoderFlush(t *testing.T) {
+	var out bytes.Buffer
+	var expected []string
+	e := NewEncoder(&out, RoundFloat)
+	for i := range 100000 {
+		name := fmt.Sprintf("station %d", i)
+		e.Station(name, int64(-i%999), int64(i%999), int64(i), 3)
+		expected = append(expected, name+"="+Stats(int64(-i%999), int64(i%999), int64(i), 3, RoundFloat))
+	}
+	require.NoError(t, e.Close())
+	assert.Equal(t, "{"+strings.Join(expected, ", ")+"}", out.String())
+}
+
+type failingWriter struct{ n int }
+
+func (w *failingWriter) Write(p []byte) (int, error) {
+	w.n++
+	return 0, errors.New("disk full")
+}
+
+func TestEncoderError(t *testing.T) {
+	w := &failingWriter{}
+	e := NewEncoder(w, RoundHalfUp)
+	for range 100000 {
+		e.Station("Abha", 1, 2, 3, 4)
+	}
+	assert.EqualError(t, e.Close(), "disk full")
+	assert.Equal(t, 1, w.n)
+}
+
+func TestEncoderAllocs(t *testing.T) {
+	e := NewEncoder(io.Discard, RoundFloat)
+	allocs := testing.AllocsPerRun(100000, func() {
+		e.Station("Las Palmas de Gran Canaria", -999, 999, 12345, 77)
+		e.FloatStation("Las Palmas de Gran Canaria", -99.9, 99.9, 1234.5, 77)
+	})
+	assert.Zero(t, allocs)
+}
+
+func BenchmarkEncoder(b *testing.B) {
+	e := NewEncoder(io.Discard, RoundHalfUp)
+	for range b.N {
+		e.Station("Las Palmas de Gran Canaria", -999, 999, 12345, 77)
+	}
+	e.Close()
+}
@@ -0,0 +1,119 @@
+// Package format formats the results like the reference implementation of
+// the challenge.
+package format
+
+import (
+	"math"
+	"strconv"
+)
+
+// Rounding modes of the values printed with one decimal
+const (
+	// RoundHalfUp rounds halves toward positive infinity, like Math.round in
+	// the reference java implementation. Means are computed exactly with
+	// integer arithmetic.
+	RoundHalfUp = "half-up"
+	// RoundFloat formats the float value with %.1f, which rounds half to even
+	// on the binary value: 0.15 is printed 0.1 and -0.04 is printed -0.0.
+	RoundFloat = "float"
+)
+
+var RoundingModes = []string{RoundHalfUp, RoundFloat}
+
+// Tenths formats a fixed point value with one decimal, i.e: -15 is "-1.5"
+func Tenths(t int64) string {
+	return string(AppendTenths(nil, t))
+}
+
+// AppendTenths is Tenths appending to b
+func AppendTenths(b []byte, t int64) []byte {
+	if t < 0 {
+		b = append(b, '-')
+		t = -t
+	}
+	b = strconv.AppendInt(b, t/10, 10)
+	return append(b, '.', byte('0'+t%10))
+}
+
+// MeanTenths returns total/n rounded half up, all in tenths
+func MeanTenths(total, n int64) int64 {
+	// floor(total/n + 1/2)
+	num, den := 2*total+n, 2*n
+	q := num / den
+	if num%den != 0 && num < 0 {
+		q--
+	}
+	return q
+}
+
+// Mean formats the mean of n values adding up to total tenths
+func Mean(total, n int64, rounding string) string {
+	return string(AppendMean(nil, total, n, rounding))
+}
+
+// AppendMean is Mean appending to b
+func AppendMean(b []byte, total, n int64, rounding string) []byte {
+	if rounding == RoundFloat {
+		return strconv.AppendFloat(b, float64(total)/10/float64(n), 'f', 1, 64)
+	}
+	return AppendTenths(b, MeanTenths(total, n))
+}
+
+// Stats formats min/mean/max, all in tenths
+func Stats(min, max, total, n int64, rounding string) string {
+	return string(AppendStats(nil, min, max, total, n, rounding))
+}
+
+// AppendStats is Stats appending to b
+func AppendStats(b []byte, min, max, total, n int64, rounding string) []byte {
+	b = AppendTenths(b, min)
+	b = append(b, '/')
+	b = AppendMean(b, total, n, rounding)
+	b = append(b, '/')
+	return AppendTenths(b, max)
+}
+
+// roundHalfUp is java's Math.round(v*10)/10, as tenths
+func roundHalfUp(v float64) int64 {
+	x := v * 10
+	r := math.Floor(x)
+	// not floor(x+0.5), it rounds 0.49999999999999994 up
+	if x-r >= 0.5 {
+		r++
+	}
+	return int64(r)
+}
+
+// Float formats v with one decimal
+func Float(v float64, rounding string) string {
+	return string(AppendFloat(nil, v, rounding))
+}
+
+// AppendFloat is Float appending to b
+func AppendFloat(b []byte, v float64, rounding string) []byte {
+	if rounding == RoundFloat {
+		// same as %.1f
+		return strconv.AppendFloat(b, v, 'f', 1, 64)
+	}
+	return AppendTenths(b, roundHalfUp(v))
+}
+
+// FloatStats formats min/mean/max of n float values adding up to total.
+// With RoundHalfUp, the mean is computed like the reference: the total is
+// rounded to one decimal, divided by n, then rounded again.
+func FloatStats(min, max, total float64, n int64, rounding string) string {
+	return string(AppendFloatStats(nil, min, max, total, n, rounding))
+}
+
+// AppendFloatStats is FloatStats appending to b
+func AppendFloatStats(b []byte, min, max, total float64, n int64, rounding string) []byte {
+	mean := total / float64(n)
+	if rounding == RoundHalfUp {
+		mean = float64(roundHalfUp(total)) / 10 / float64(n)
+	}
+	b = AppendFloat(b, min, rounding)
+	b = append(b, '/')
+	b = AppendFloat(b, mean, rounding)
+	b = append(b, '/')
+	return AppendFloat(b, max, rounding)
+}
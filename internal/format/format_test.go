@@ -0,0 +1,85 @@
+package format
+
+import (
+	"math/big"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTenths(t *testing.T) {
+	for v, expected := range map[int64]string{0: "0.0", 5: "0.5", -5: "-0.5", 15: "1.5", -999: "-99.9", 999: "99.9", -10: "-1.0"} {
+		assert.Equal(t, expected, Tenths(v), v)
+	}
+}
+
+func TestMean(t *testing.T) {
+	tests := []struct {
+		total, n      int64
+		halfUp, float string
+	}{
+		{15, 1, "1.5", "1.5"},
+		// 0.25 is exact in binary, %.1f rounds it to even
+		{5, 2, "0.3", "0.2"},
+		{-5, 2, "-0.2", "-0.2"},
+		// 0.15 is 0.1499999999999999944 in binary
+		{15, 10, "0.2", "0.1"},
+		{-15, 10, "-0.1", "-0.1"},
+		{35, 10, "0.4", "0.3"},
+		// halves go toward positive infinity, no negative zero
+		{-1, 2, "0.0", "-0.1"},
+		{-1, 3, "0.0", "-0.0"},
+		{-4, 10, "0.0", "-0.0"},
+		{-6, 10, "-0.1", "-0.1"},
+		{-999 * 3, 3, "-99.9", "-99.9"},
+		{999*2 + 998, 3, "99.9", "99.9"},
+		{2 * 1000000001, 2000000000, "0.1", "0.1"},
+		// right above and below 0.05
+		{1000000001, 2000000000, "0.1", "0.1"},
+		{999999999, 2000000000, "0.0", "0.0"},
+	}
+	for _, tt := range tests {
+		assert.Equal(t, tt.halfUp, Mean(tt.total, tt.n, RoundHalfUp), "%d/%d", tt.total, tt.n)
+		assert.Equal(t, tt.float, Mean(tt.total, tt.n, RoundFloat), "%d/%d", tt.total, tt.n)
+	}
+}
+
+func TestMeanTenthsExact(t *testing.T) {
+	half := big.NewRat(1, 2)
+	for n := int64(1); n <= 40; n++ {
+		for total := -2000 * n; total <= 2000*n; total += 7 {
+			// floor(total/n + 1/2)
+			r := new(big.Rat).Add(big.NewRat(total, n), half)
+			q := new(big.Int).Div(r.Num(), r.Denom()) // euclidean division, floor for a positive denominator
+			assert.Equal(t, q.Int64(), MeanTenths(total, n), "%d/%d", total, n)
+		}
+	}
+}
+
+func TestFloat(t *testing.T) {
+	tests := []struct {
+		v             float64
+		halfUp, float string
+	}{
+		{0.15, "0.2", "0.1"},
+		{-0.15, "-0.1", "-0.1"},
+		{0.25, "0.3", "0.2"},
+		{-0.04, "0.0", "-0.0"},
+		{-0.05, "0.0", "-0.1"},
+		{12.3, "12.3", "12.3"},
+		{-99.9, "-99.9", "-99.9"},
+		{0.049999999999999996, "0.0", "0.0"},
+	}
+	for _, tt := range tests {
+		assert.Equal(t, tt.halfUp, Float(tt.v, RoundHalfUp), "%v", tt.v)
+		assert.Equal(t, tt.float, Float(tt.v, RoundFloat), "%v", tt.v)
+	}
+}
+
+func TestStats(t *testing.T) {
+	assert.Equal(t, "-1.5/0.2/9.9", Stats(-15, 99, 15, 10, RoundHalfUp))
+	assert.Equal(t, "-1.5/0.1/9.9", Stats(-15, 99, 15, 10, RoundFloat))
+	// the reference rounds the total first: round(round(0.65) / 4) = round(0.7/4)
+	assert.Equal(t, "0.1/0.2/0.3", FloatStats(0.05, 0.3, 0.1+0.2+0.3+0.05, 4, RoundHalfUp))
+	assert.Equal(t, "0.1/0.2/0.3", FloatStats(0.05, 0.3, 0.1+0.2+0.3+0.05, 4, RoundFloat))
+}
@@ -0,0 +1,209 @@
+package names
+
+import (
+	"encoding/binary"
+	"fmt"
+	"slices"
+	"strings"
+	"unicode"
+)
+
+// Collator orders names for a locale with a simplified version of the
+// Unicode Collation Algorithm. Names are compared on their letters first,
+// ignoring accents and case, then on the accents, then on the case and
+// finally byte per byte so different names never compare equal.
+// Only the canonical decompositions and a few expansions are known, it is not
+// a full CLDR implementation.
+type Collator struct {
+	// backwardsAccents compares the accents from the end of the names, as
+	// in canadian french: cote < côte < coté < côté
+	backwardsAccents bool
+	// tailoring gives a letter its own place in the alphabet, after another
+	// one, instead of sorting it as an accented letter
+	tailoring map[rune]uint64
+}
+
+// Locales lists the locales known to NewCollator
+var Locales = []string{"root", "en", "fr", "fr-CA", "de", "es", "sv"}
+
+// NewCollator returns the collator of locale, see Locales.
+func NewCollator(locale string) (*Collator, error) {
+	c := &Collator{}
+	switch locale {
+	case "root", "en", "fr", "de":
+		// french from france uses the root order since CLDR 1.9
+	case "fr-CA":
+		c.backwardsAccents = true
+	case "es":
+		c.tailor('n', 'ñ')
+	case "sv":
+		c.tailor('z', 'å', 'ä', 'ö')
+	default:
+		return nil, fmt.Errorf("unknown locale %q, want one of %s", locale, strings.Join(Locales, ", "))
+	}
+	return c, nil
+}
+
+// tailor places letters right after the letter after, in order
+func (c *Collator) tailor(after rune, letters ...rune) {
+	if c.tailoring == nil {
+		c.tailoring = make(map[rune]uint64)
+	}
+	for i, r := range letters {
+		c.tailoring[r] = primaryWeight(after) + uint64(i) + 1
+	}
+}
+
+// Compare returns -1, 0 or 1 depending on whether a sorts before, the same or
+// after b. Sorts should compare the Key of the names instead, computed once
+// per name.
+func (c *Collator) Compare(a, b string) int {
+	return strings.Compare(c.Key(a), c.Key(b))
+}
+
+// Key returns the sort key of s: comparing the keys byte per byte orders the
+// names as Compare does.
+func (c *Collator) Key(s string) string {
+	k := c.key(s)
+	// every level ends with a 0 weight, lower than any weight of the level
+	// so shorter names sort first, as in slices.Compare
+	b := make([]byte, 0, 8*len(k.primary)+2*len(k.secondary)+len(k.tertiary)+len(s)+11)
+	for _, w := range k.primary {
+		b = binary.BigEndian.AppendUint64(b, w)
+	}
+	b = binary.BigEndian.AppendUint64(b, 0)
+	for _, w := range k.secondary {
+		b = binary.BigEndian.AppendUint16(b, w)
+	}
+	b = binary.BigEndian.AppendUint16(b, 0)
+	for _, w := range k.tertiary {
+		b = append(b, w+1)
+	}
+	b = append(b, 0)
+	b = append(b, s...)
+	return string(b)
+}
+
+type sortKey struct {
+	primary   []uint64
+	secondary []uint16
+	tertiary  []uint8
+}
+
+// secondary weights
+const (
+	noAccent = 0x20
+	// letters with a stroke or a slash that don't decompose, i.e: ø ł đ
+	strokeAccent = 0x40
+)
+
+// the secondary order of the common accents, as in the DUCET
+var accentOrder = []rune{
+	0x0301, // acute
+	0x0300, // grave
+	0x0306, // breve
+	0x0302, // circumflex
+	0x030C, // caron
+	0x030A, // ring
+	0x0308, // diaeresis
+	0x030B, // double acute
+	0x0303, // tilde
+	0x0307, // dot above
+	0x0327, // cedilla
+	0x0328, // ogonek
+	0x0304, // macron
+}
+
+// expansions are letters sorted as a sequence of other letters, with the
+// given accent
+var expansions = map[rune]struct {
+	letters string
+	accent  uint16
+}{
+	'ß': {"ss", noAccent},
+	'æ': {"ae", noAccent},
+	'œ': {"oe", noAccent},
+	'ø': {"o", strokeAccent},
+	'đ': {"d", strokeAccent},
+	'ł': {"l", strokeAccent},
+	'ħ': {"h", strokeAccent},
+	'ı': {"i", strokeAccent},
+}
+
+func accentWeight(mark rune) uint16 {
+	if i := slices.Index(accentOrder, mark); i >= 0 {
+		return noAccent + 1 + uint16(i)
+	}
+	return 0x100 + uint16(mark&0xfff)
+}
+
+// primaryWeight orders spaces, then punctuation, symbols, digits and letters.
+// Within a group, runes are sorted by code point, letters after lowering their
+// case. 0 means ignored.
+func primaryWeight(r rune) uint64 {
+	var group uint64
+	switch {
+	case unicode.IsSpace(r):
+		group = 1
+	case unicode.IsPunct(r):
+		group = 2
+	case unicode.IsSymbol(r):
+		group = 3
+	case unicode.IsDigit(r):
+		group = 4
+	case unicode.IsLetter(r):
+		group = 5
+		r = unicode.ToLower(r)
+	default:
+		return 0
+	}
+	// room for tailored letters in the low byte
+	return group<<32 | uint64(r)<<8
+}
+
+func (c *Collator) key(s string) sortKey {
+	var k sortKey
+	var decomposed []rune
+	for _, r := range NFC(s) {
+		var case_ uint8
+		if unicode.IsUpper(r) {
+			case_ = 1
+		}
+		lower := unicode.ToLower(r)
+
+		if w, ok := c.tailoring[lower]; ok {
+			k.primary = append(k.primary, w)
+			k.secondary = append(k.secondary, noAccent)
+			k.tertiary = append(k.tertiary, case_)
+			continue
+		}
+		if e, ok := expansions[lower]; ok {
+			for _, l := range e.letters {
+				k.primary = append(k.primary, primaryWeight(l))
+				k.secondary = append(k.secondary, e.accent)
+				k.tertiary = append(k.tertiary, case_)
+			}
+			continue
+		}
+
+		decomposed = decompose(decomposed[:0], r)
+		for _, d := range decomposed {
+			if combiningClass(d) != 0 {
+				// accents only have a secondary weight
+				k.secondary = append(k.secondary, accentWeight(d))
+				continue
+			}
+			w := primaryWeight(d)
+			if w == 0 {
+				continue
+			}
+			k.primary = append(k.primary, w)
+			k.secondary = append(k.secondary, noAccent)
+			k.tertiary = append(k.tertiary, case_)
+		}
+	}
+	if c.backwardsAccents {
+		slices.Reverse(k.secondary)
+	}
+	return k
+}
@@ -0,0 +1,82 @@
+package names
+
+import (
+	"math/rand/v2"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCollator(t *testing.T) {
+	tests := []struct {
+		locale   string
+		expected []string
+	}{
+		{"root", []string{"cote", "coté", "côte", "côté"}},
+		{"fr", []string{"Abéché", "Abidjan", "Ürümqi", "Zürich"}},
+		{"fr-CA", []string{"cote", "côte", "coté", "côté"}},
+		{"en", []string{"a", "A", "á", "b"}},
+		{"en", []string{"St John's", "St. John's", "Strasbourg", "Straße"}},
+		{"en", []string{"Ørsta", "Oslo", "Øystese"}},
+		{"en", []string{"Zagreb", "Ždiar", "Zürich"}},
+		{"es", []string{"Nuuk", "Ñuñoa", "Oaxaca"}},
+		{"en", []string{"Ñuñoa", "Nuuk", "Oaxaca"}},
+		{"sv", []string{"Zürich", "Åre", "Älmhult", "Örebro"}},
+		{"en", []string{"Älmhult", "Åre", "Örebro", "Zürich"}},
+		{"en", []string{"10 de Mayo", "9 de Julio", "Abha"}},
+	}
+	r := rand.New(rand.NewPCG(1, 2))
+	for _, tt := range tests {
+		c, err := NewCollator(tt.locale)
+		require.NoError(t, err)
+		for range 5 {
+			names := slices.Clone(tt.expected)
+			r.Shuffle(len(names), func(i, j int) { names[i], names[j] = names[j], names[i] })
+			slices.SortFunc(names, c.Compare)
+			assert.Equal(t, tt.expected, names, tt.locale)
+		}
+	}
+
+	_, err := NewCollator("tlh")
+	assert.Error(t, err)
+}
+
+func TestCollatorNormalizes(t *testing.T) {
+	c, err := NewCollator("fr")
+	require.NoError(t, err)
+	// same letters and accents, only the byte order tells them apart
+	assert.Equal(t, -1, c.Compare("Abéché", "Abéché"))
+	assert.Equal(t, 0, c.Compare("Abéché", "Abéché"))
+}
+
+func TestCollatorKey(t *testing.T) {
+	// compares the levels of the keys one after the other, without encoding
+	// them
+	compare := func(c *Collator, a, b string) int {
+		ka, kb := c.key(a), c.key(b)
+		if r := slices.Compare(ka.primary, kb.primary); r != 0 {
+			return r
+		}
+		if r := slices.Compare(ka.secondary, kb.secondary); r != 0 {
+			return r
+		}
+		if r := slices.Compare(ka.tertiary, kb.tertiary); r != 0 {
+			return r
+		}
+		return strings.Compare(a, b)
+	}
+	names := []string{"", "a", "A", "á", "ab", "Ab", "cote", "Cote", "cotes", "coté", "côte", "côté", "co te",
+		"St John's", "St. John's", "Straße", "Strasse", "Ørsta", "Oslo", "Ñuñoa", "Nuuk", "Åre", "Zürich", "Zurich", "9", "10"}
+	for _, locale := range Locales {
+		c, err := NewCollator(locale)
+		require.NoError(t, err)
+		for _, a := range names {
+			for _, b := range names {
+				assert.Equal(t, compare(c, a, b), c.Compare(a, b), "%s: %q %q", locale, a, b)
+			}
+		}
+	}
+}
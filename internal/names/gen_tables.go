@@ -0,0 +1,203 @@
+//go:build ignore
+
+// Generates tables.go from UnicodeData.txt and CompositionExclusions.txt of
+// the unicode character database.
+// usage: go run gen_tables.go [-version 14.0.0] [-ucd dir or url], see
+// go:generate in nfc.go
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"flag"
+	"fmt"
+	"go/format"
+	"io"
+	"log"
+	"maps"
+	"net/http"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+const hangulSBase, hangulSCount = 0xAC00, 11172
+
+var (
+	version = flag.String("version", "14.0.0", "unicode version")
+	ucd     = flag.String("ucd", "", "directory or url of the unicode character database, https://www.unicode.org/Public/<version>/ucd by default")
+	output  = flag.String("o", "tables.go", "output file")
+)
+
+// open opens a file of the database, from a directory or downloaded
+func open(name string) (io.ReadCloser, error) {
+	if !strings.HasPrefix(*ucd, "http://") && !strings.HasPrefix(*ucd, "https://") {
+		return os.Open(filepath.Join(*ucd, name))
+	}
+	resp, err := http.Get(*ucd + "/" + name)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		resp.Body.Close()
+		return nil, fmt.Errorf("%s: %s", name, resp.Status)
+	}
+	return resp.Body, nil
+}
+
+// readLines calls f with the fields of every line of name, without the
+// comments and the empty lines
+func readLines(name string, f func(fields []string) error) error {
+	r, err := open(name)
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line, _, _ := strings.Cut(scanner.Text(), "#")
+		if strings.TrimSpace(line) == "" {
+			continue
+		}
+		fields := strings.Split(line, ";")
+		for i := range fields {
+			fields[i] = strings.TrimSpace(fields[i])
+		}
+		if err := f(fields); err != nil {
+			return fmt.Errorf("%s: %s: %w", name, scanner.Text(), err)
+		}
+	}
+	return scanner.Err()
+}
+
+func parseRune(s string) (rune, error) {
+	r, err := strconv.ParseUint(s, 16, 32)
+	return rune(r), err
+}
+
+func main() {
+	flag.Parse()
+	if *ucd == "" {
+		*ucd = "https://www.unicode.org/Public/" + *version + "/ucd"
+	}
+
+	decompositions := make(map[rune][]rune)
+	ccc := make(map[rune]uint8)
+	err := readLines("UnicodeData.txt", func(fields []string) error {
+		if len(fields) < 6 {
+			return fmt.Errorf("%d fields", len(fields))
+		}
+		r, err := parseRune(fields[0])
+		if err != nil {
+			return err
+		}
+		if r >= hangulSBase && r < hangulSBase+hangulSCount {
+			// algorithmic, see nfc.go
+			return nil
+		}
+		class, err := strconv.ParseUint(fields[3], 10, 8)
+		if err != nil {
+			return err
+		}
+		if class != 0 {
+			ccc[r] = uint8(class)
+		}
+		// no decomposition or a compatibility one
+		if fields[5] == "" || strings.HasPrefix(fields[5], "<") {
+			return nil
+		}
+		for _, f := range strings.Fields(fields[5]) {
+			p, err := parseRune(f)
+			if err != nil {
+				return err
+			}
+			decompositions[r] = append(decompositions[r], p)
+		}
+		return nil
+	})
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	excluded := make(map[rune]bool)
+	err = readLines("CompositionExclusions.txt", func(fields []string) error {
+		r, err := parseRune(fields[0])
+		excluded[r] = true
+		return err
+	})
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	// first rune of the full decomposition of r
+	var first func(r rune) rune
+	first = func(r rune) rune {
+		if d, ok := decompositions[r]; ok {
+			return first(d[0])
+		}
+		return r
+	}
+	// a pair composes back unless it is excluded from composition: listed,
+	// or a non starter decomposition
+	compositions := make(map[[2]rune]rune)
+	for r, d := range decompositions {
+		if len(d) == 2 && !excluded[r] && ccc[r] == 0 && ccc[first(r)] == 0 {
+			compositions[[2]rune{d[0], d[1]}] = r
+		}
+	}
+
+	var out bytes.Buffer
+	fmt.Fprintf(&out, "// Code generated by gen_tables.go from the unicode %s data; DO NOT EDIT.\n\n", *version)
+	fmt.Fprintf(&out, "package names\n\n")
+	fmt.Fprintf(&out, "const unicodeVersion = %q\n\n", *version)
+
+	fmt.Fprintf(&out, "// canonical decompositions, not applied recursively\n")
+	fmt.Fprintf(&out, "var decompositions = map[rune][]rune{\n")
+	for _, r := range slices.Sorted(maps.Keys(decompositions)) {
+		parts := make([]string, len(decompositions[r]))
+		for i, p := range decompositions[r] {
+			parts[i] = hex(p)
+		}
+		fmt.Fprintf(&out, "\t%s: {%s},\n", hex(r), strings.Join(parts, ", "))
+	}
+	fmt.Fprintf(&out, "}\n\n")
+
+	fmt.Fprintf(&out, "// canonical combining classes, 0 when missing\n")
+	fmt.Fprintf(&out, "var combiningClasses = map[rune]uint8{\n")
+	classes := slices.Sorted(maps.Keys(ccc))
+	for i := 0; i < len(classes); i += 6 {
+		line := make([]string, 0, 6)
+		for _, r := range classes[i:min(i+6, len(classes))] {
+			line = append(line, fmt.Sprintf("%s: %d,", hex(r), ccc[r]))
+		}
+		fmt.Fprintf(&out, "\t%s\n", strings.Join(line, " "))
+	}
+	fmt.Fprintf(&out, "}\n\n")
+
+	fmt.Fprintf(&out, "// primary composites by the pair of runes they are made of\n")
+	fmt.Fprintf(&out, "var compositions = map[[2]rune]rune{\n")
+	pairs := slices.SortedFunc(maps.Keys(compositions), func(a, b [2]rune) int {
+		if a[0] != b[0] {
+			return int(a[0] - b[0])
+		}
+		return int(a[1] - b[1])
+	})
+	for _, pair := range pairs {
+		fmt.Fprintf(&out, "\t{%s, %s}: %s,\n", hex(pair[0]), hex(pair[1]), hex(compositions[pair]))
+	}
+	fmt.Fprintf(&out, "}\n")
+
+	src, err := format.Source(out.Bytes())
+	if err != nil {
+		log.Fatal(err)
+	}
+	if err := os.WriteFile(*output, src, 0o644); err != nil {
+		log.Fatal(err)
+	}
+}
+
+func hex(r rune) string {
+	return fmt.Sprintf("0x%04X", r)
+}
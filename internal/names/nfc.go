@@ -0,0 +1,150 @@
+// Package names normalizes and orders station names.
+//
+// The unicode tables are generated from the unicode character database by
+// gen_tables.go.
+package names
+
+import (
+	"slices"
+	"unicode/utf8"
+)
+
+//go:generate go run gen_tables.go
+
+// Hangul syllables are decomposed and composed algorithmically
+const (
+	hangulSBase  = 0xAC00
+	hangulLBase  = 0x1100
+	hangulVBase  = 0x1161
+	hangulTBase  = 0x11A7
+	hangulLCount = 19
+	hangulVCount = 21
+	hangulTCount = 28
+	hangulNCount = hangulVCount * hangulTCount
+	hangulSCount = hangulLCount * hangulNCount
+)
+
+// NFC returns s in Unicode Normalization Form C: canonically decomposed,
+// combining marks in canonical order, then recomposed. So "Abéché" typed
+// with combining accents (NFD) and with precomposed letters (NFC) are the
+// same string.
+func NFC(s string) string {
+	if isASCII(s) {
+		return s
+	}
+	runes := make([]rune, 0, len(s))
+	for _, r := range s {
+		runes = decompose(runes, r)
+	}
+	reorder(runes)
+	return string(compose(runes))
+}
+
+// NFCBytes is NFC for byte slices, b is returned as is when it is ASCII
+func NFCBytes(b []byte) []byte {
+	if isASCII(string(b)) {
+		return b
+	}
+	return []byte(NFC(string(b)))
+}
+
+func isASCII(s string) bool {
+	for i := 0; i < len(s); i++ {
+		if s[i] >= utf8.RuneSelf {
+			return false
+		}
+	}
+	return true
+}
+
+func combiningClass(r rune) uint8 {
+	if r < 0x300 {
+		return 0
+	}
+	return combiningClasses[r]
+}
+
+// decompose appends the full canonical decomposition of r to runes
+func decompose(runes []rune, r rune) []rune {
+	if r < 0xC0 {
+		return append(runes, r)
+	}
+	if s := r - hangulSBase; s >= 0 && s < hangulSCount {
+		runes = append(runes, hangulLBase+s/hangulNCount, hangulVBase+(s%hangulNCount)/hangulTCount)
+		if t := s % hangulTCount; t != 0 {
+			runes = append(runes, hangulTBase+t)
+		}
+		return runes
+	}
+	d, ok := decompositions[r]
+	if !ok {
+		return append(runes, r)
+	}
+	for _, r := range d {
+		runes = decompose(runes, r)
+	}
+	return runes
+}
+
+// reorder sorts every run of combining marks by combining class, keeping the
+// order of the marks of the same class
+func reorder(runes []rune) {
+	for i := 0; i < len(runes); {
+		if combiningClass(runes[i]) == 0 {
+			i++
+			continue
+		}
+		j := i + 1
+		for j < len(runes) && combiningClass(runes[j]) != 0 {
+			j++
+		}
+		slices.SortStableFunc(runes[i:j], func(a, b rune) int {
+			return int(combiningClass(a)) - int(combiningClass(b))
+		})
+		i = j
+	}
+}
+
+// compose recomposes decomposed runes in place: every rune that isn't
+// blocked from the last starter is combined with it when a primary composite
+// of the pair exists.
+func compose(runes []rune) []rune {
+	out := runes[:0]
+	starter := -1
+	var lastClass uint8
+	for _, r := range runes {
+		class := combiningClass(r)
+		if starter >= 0 {
+			// blocked when there is something between the starter and r
+			// with a class of 0 or at least the class of r
+			blocked := len(out)-1 != starter && (lastClass == 0 || lastClass >= class)
+			if !blocked {
+				if c, ok := composePair(out[starter], r); ok {
+					out[starter] = c
+					continue
+				}
+			}
+		}
+		if class == 0 {
+			starter = len(out)
+		}
+		lastClass = class
+		out = append(out, r)
+	}
+	return out
+}
+
+func composePair(a, b rune) (rune, bool) {
+	if l := a - hangulLBase; l >= 0 && l < hangulLCount {
+		if v := b - hangulVBase; v >= 0 && v < hangulVCount {
+			return hangulSBase + (l*hangulVCount+v)*hangulTCount, true
+		}
+	}
+	if s := a - hangulSBase; s >= 0 && s < hangulSCount && s%hangulTCount == 0 {
+		if t := b - hangulTBase; t > 0 && t < hangulTCount {
+			return a + t, true
+		}
+	}
+	c, ok := compositions[[2]rune{a, b}]
+	return c, ok
+}
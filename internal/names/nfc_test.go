@@ -0,0 +1,48 @@
+package names
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNFC(t *testing.T) {
+	tests := []struct {
+		in, expected string
+	}{
+		{"Abha", "Abha"},
+		{"Abéché", "Abéché"},
+		{"Abéché", "Abéché"},
+		{"Zürich", "Zürich"},
+		// combining marks are put in canonical order before composing
+		{"ệ", "ệ"},
+		{"ệ", "ệ"},
+		// blocked by a mark of the same class
+		{"á́", "á́"},
+		// singleton decomposition, the angstrom sign is never composed back
+		{"Å", "Å"},
+		// composition exclusion
+		{"क़", "क़"},
+		// hangul, LV + T and L + V + T
+		{"각", "각"},
+		{"각", "각"},
+		// leading non starter
+		{"́e", "́e"},
+		{"", ""},
+	}
+	for _, tt := range tests {
+		assert.Equal(t, tt.expected, NFC(tt.in), "%+q", tt.in)
+		assert.Equal(t, tt.expected, NFC(tt.expected), "%+q is NFC", tt.expected)
+	}
+	assert.Equal(t, []byte("Abéché"), NFCBytes([]byte("Abéché")))
+}
+
+func BenchmarkNFC(b *testing.B) {
+	for _, s := range []string{"Las Palmas de Gran Canaria", "Abéché"} {
+		b.Run(s, func(b *testing.B) {
+			for range b.N {
+				NFC(s)
+			}
+		})
+	}
+}
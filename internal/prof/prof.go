@@ -0,0 +1,120 @@
+// Package prof holds the profiling flags shared by the binaries
+package prof
+
+import (
+	"errors"
+	"flag"
+	"log"
+	"os"
+	"runtime"
+	"runtime/pprof"
+	"runtime/trace"
+)
+
+// Flags are the files the profiles are written to, empty means disabled
+type Flags struct {
+	CPU   string
+	Mem   string
+	Block string
+	Mutex string
+	Trace string
+}
+
+// Register adds -cpuprofile, -memprofile, -blockprofile, -mutexprofile and
+// -trace to fs
+func Register(fs *flag.FlagSet) *Flags {
+	f := &Flags{}
+	fs.StringVar(&f.CPU, "cpuprofile", "", "write cpu profile to file")
+	fs.StringVar(&f.Mem, "memprofile", "", "write memory profile to file, at exit")
+	fs.StringVar(&f.Block, "blockprofile", "", "write goroutine blocking profile to file, at exit")
+	fs.StringVar(&f.Mutex, "mutexprofile", "", "write mutex contention profile to file, at exit")
+	fs.StringVar(&f.Trace, "trace", "", "write execution trace to file, for go tool trace")
+	return f
+}
+
+// Start starts the cpu profile, the trace and the recording of the block and
+// mutex events. stop writes all the profiles, it must be called before
+// exiting. It logs its errors, there isn't much else to do with them then.
+func (f *Flags) Start() (stop func(), err error) {
+	var stops []func() error
+	stopAll := func() {
+		var errs []error
+		for i := len(stops) - 1; i >= 0; i-- {
+			errs = append(errs, stops[i]())
+		}
+		if err := errors.Join(errs...); err != nil {
+			log.Printf("profiles: %s", err)
+		}
+	}
+	defer func() {
+		if err != nil {
+			stopAll()
+		}
+	}()
+
+	if f.CPU != "" {
+		out, err := os.Create(f.CPU)
+		if err != nil {
+			return nil, err
+		}
+		if err := pprof.StartCPUProfile(out); err != nil {
+			out.Close()
+			return nil, err
+		}
+		stops = append(stops, func() error {
+			pprof.StopCPUProfile()
+			return out.Close()
+		})
+	}
+
+	if f.Trace != "" {
+		out, err := os.Create(f.Trace)
+		if err != nil {
+			return nil, err
+		}
+		if err := trace.Start(out); err != nil {
+			out.Close()
+			return nil, err
+		}
+		stops = append(stops, func() error {
+			trace.Stop()
+			return out.Close()
+		})
+	}
+
+	if f.Block != "" {
+		runtime.SetBlockProfileRate(1)
+		stops = append(stops, func() error {
+			return writeProfile("block", f.Block)
+		})
+	}
+
+	if f.Mutex != "" {
+		runtime.SetMutexProfileFraction(1)
+		stops = append(stops, func() error {
+			return writeProfile("mutex", f.Mutex)
+		})
+	}
+
+	if f.Mem != "" {
+		stops = append(stops, func() error {
+			// up to date statistics
+			runtime.GC()
+			return writeProfile("allocs", f.Mem)
+		})
+	}
+
+	return stopAll, nil
+}
+
+func writeProfile(name, filename string) error {
+	out, err := os.Create(filename)
+	if err != nil {
+		return err
+	}
+	if err := pprof.Lookup(name).WriteTo(out, 0); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
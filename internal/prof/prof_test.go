@@ -0,0 +1,44 @@
+package prof
+
+import (
+	"flag"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestProfiles(t *testing.T) {
+	dir := t.TempDir()
+	fs := flag.NewFlagSet("test", flag.ContinueOnError)
+	f := Register(fs)
+	names := []string{"cpuprofile", "memprofile", "blockprofile", "mutexprofile", "trace"}
+	var args []string
+	for _, name := range names {
+		args = append(args, "-"+name, filepath.Join(dir, name))
+	}
+	require.NoError(t, fs.Parse(args))
+
+	stop, err := f.Start()
+	require.NoError(t, err)
+	stop()
+
+	for _, name := range names {
+		fi, err := os.Stat(filepath.Join(dir, name))
+		if assert.NoError(t, err) {
+			assert.NotZero(t, fi.Size(), name)
+		}
+	}
+}
+
+func TestProfilesStartError(t *testing.T) {
+	f := &Flags{CPU: filepath.Join(t.TempDir(), "cpu"), Trace: filepath.Join(t.TempDir(), "missing", "trace")}
+	_, err := f.Start()
+	assert.Error(t, err)
+	// the cpu profile was stopped, it can start again
+	stop, err := (&Flags{CPU: f.CPU}).Start()
+	require.NoError(t, err)
+	stop()
+}
@@ -0,0 +1,631 @@
+// Package query runs SQL like queries on measurement files with the workers
+// of fastbrc, i.e:
+//
+//	SELECT station, max(temp), count(*) FROM 'data.txt'
+//	WHERE station LIKE 'A%' GROUP BY station ORDER BY max DESC LIMIT 10
+//
+// Only the station can be filtered in WHERE, it is compiled to a
+// fastbrc.Filter. Conditions on the aggregates go in HAVING.
+package query
+
+import (
+	"fmt"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// Aggregates are the functions of the measurements known to the queries
+var Aggregates = []string{"min", "max", "avg", "count", "sum", "stddev"}
+
+// Statement is a parsed query
+type Statement struct {
+	Columns []Column
+	// From is the measurements file
+	From string
+	// Where selects the stations, nil keeps all of them
+	Where Predicate
+	// GroupBy is true with GROUP BY station, without it the aggregates are
+	// the ones of all the stations together
+	GroupBy bool
+	Having  []Comparison
+	OrderBy []Order
+	// Limit is the number of rows kept, -1 keeps all of them
+	Limit int
+}
+
+// Column is a selected column, the station or an aggregate
+type Column struct {
+	// Func is one of Aggregates, empty for the station
+	Func  string
+	Alias string
+	// star is count(*)
+	star bool
+}
+
+// Name is the header of the column
+func (c Column) Name() string {
+	switch {
+	case c.Alias != "":
+		return c.Alias
+	case c.Func == "":
+		return "station"
+	case c.star:
+		return c.Func + "(*)"
+	}
+	return c.Func + "(temp)"
+}
+
+// Predicate is a condition on the station names
+type Predicate interface {
+	Match(name string) bool
+	String() string
+}
+
+// Comparison compares an aggregate to a number, in degrees
+type Comparison struct {
+	Func  string
+	Op    string
+	Value float64
+}
+
+// Order is a sort key, Func is empty for the station
+type Order struct {
+	Func string
+	Desc bool
+}
+
+type andPredicate struct{ l, r Predicate }
+
+func (p andPredicate) Match(name string) bool { return p.l.Match(name) && p.r.Match(name) }
+func (p andPredicate) String() string         { return "(" + p.l.String() + " AND " + p.r.String() + ")" }
+
+type orPredicate struct{ l, r Predicate }
+
+func (p orPredicate) Match(name string) bool { return p.l.Match(name) || p.r.Match(name) }
+func (p orPredicate) String() string         { return "(" + p.l.String() + " OR " + p.r.String() + ")" }
+
+type notPredicate struct{ p Predicate }
+
+func (p notPredicate) Match(name string) bool { return !p.p.Match(name) }
+func (p notPredicate) String() string         { return "NOT " + p.p.String() }
+
+type likePredicate struct {
+	pattern string
+	re      *regexp.Regexp
+}
+
+func (p likePredicate) Match(name string) bool { return p.re.MatchString(name) }
+func (p likePredicate) String() string         { return "station LIKE " + quote(p.pattern) }
+
+type inPredicate struct{ names []string }
+
+func (p inPredicate) Match(name string) bool { return slices.Contains(p.names, name) }
+func (p inPredicate) String() string {
+	quoted := make([]string, len(p.names))
+	for i, name := range p.names {
+		quoted[i] = quote(name)
+	}
+	return "station IN (" + strings.Join(quoted, ", ") + ")"
+}
+
+func quote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", "''") + "'"
+}
+
+// likeRegexp translates a LIKE pattern: % matches any string and _ any
+// character
+func likeRegexp(pattern string) *regexp.Regexp {
+	var b strings.Builder
+	b.WriteString("^(?s:")
+	for _, r := range pattern {
+		switch r {
+		case '%':
+			b.WriteString(".*")
+		case '_':
+			b.WriteString(".")
+		default:
+			b.WriteString(regexp.QuoteMeta(string(r)))
+		}
+	}
+	b.WriteString(")$")
+	return regexp.MustCompile(b.String())
+}
+
+type tokenKind int
+
+const (
+	tokEOF tokenKind = iota
+	tokIdent
+	tokString
+	tokNumber
+	tokSymbol
+)
+
+type token struct {
+	kind tokenKind
+	text string
+	pos  int
+}
+
+func (t token) String() string {
+	switch t.kind {
+	case tokEOF:
+		return "end of query"
+	case tokString:
+		return quote(t.text)
+	}
+	return strconv.Quote(t.text)
+}
+
+// symbols by length, so <= isn't lexed as <
+var symbols = []string{"<=", ">=", "!=", "<>", "(", ")", ",", "*", "=", "<", ">", ";"}
+
+func lex(s string) ([]token, error) {
+	var tokens []token
+	for i := 0; i < len(s); {
+		c := s[i]
+		switch {
+		case c == ' ' || c == '\t' || c == '\n' || c == '\r':
+			i++
+		case c == '\'':
+			// '' is an escaped quote
+			var b strings.Builder
+			j := i + 1
+			for {
+				if j >= len(s) {
+					return nil, fmt.Errorf("unterminated string at %d", i)
+				}
+				if s[j] == '\'' {
+					if j+1 < len(s) && s[j+1] == '\'' {
+						b.WriteByte('\'')
+						j += 2
+						continue
+					}
+					break
+				}
+				b.WriteByte(s[j])
+				j++
+			}
+			tokens = append(tokens, token{tokString, b.String(), i})
+			i = j + 1
+		case c >= '0' && c <= '9' || c == '-' && i+1 < len(s) && (s[i+1] >= '0' && s[i+1] <= '9' || s[i+1] == '.') || c == '.':
+			j := i + 1
+			for j < len(s) && (s[j] >= '0' && s[j] <= '9' || s[j] == '.') {
+				j++
+			}
+			tokens = append(tokens, token{tokNumber, s[i:j], i})
+			i = j
+		case c == '_' || c < 0x80 && unicode.IsLetter(rune(c)):
+			j := i + 1
+			for j < len(s) && (s[j] == '_' || s[j] < 0x80 && (unicode.IsLetter(rune(s[j])) || unicode.IsDigit(rune(s[j])))) {
+				j++
+			}
+			tokens = append(tokens, token{tokIdent, s[i:j], i})
+			i = j
+		default:
+			i0 := i
+			for _, sym := range symbols {
+				if strings.HasPrefix(s[i:], sym) {
+					tokens = append(tokens, token{tokSymbol, sym, i})
+					i += len(sym)
+					break
+				}
+			}
+			if i == i0 {
+				return nil, fmt.Errorf("unexpected %q at %d", c, i)
+			}
+		}
+	}
+	return append(tokens, token{kind: tokEOF, pos: len(s)}), nil
+}
+
+type parser struct {
+	tokens []token
+	pos    int
+}
+
+func (p *parser) peek() token {
+	return p.tokens[p.pos]
+}
+
+// take consumes the next token when it is of kind
+func (p *parser) take(kind tokenKind) (token, bool) {
+	t := p.tokens[p.pos]
+	if t.kind != kind || kind == tokEOF {
+		return t, false
+	}
+	p.pos++
+	return t, true
+}
+
+// keyword reports whether the next token is the keyword kw, and consumes it
+func (p *parser) keyword(kw string) bool {
+	t := p.peek()
+	if t.kind == tokIdent && strings.EqualFold(t.text, kw) {
+		p.pos++
+		return true
+	}
+	return false
+}
+
+func (p *parser) symbol(sym string) bool {
+	t := p.peek()
+	if t.kind == tokSymbol && t.text == sym {
+		p.pos++
+		return true
+	}
+	return false
+}
+
+func (p *parser) errorf(format string, args ...any) error {
+	t := p.peek()
+	return fmt.Errorf("at %d, near %s: %s", t.pos, t, fmt.Sprintf(format, args...))
+}
+
+func (p *parser) expectKeyword(kw string) error {
+	if !p.keyword(kw) {
+		return p.errorf("want %s", kw)
+	}
+	return nil
+}
+
+func (p *parser) expectSymbol(sym string) error {
+	if !p.symbol(sym) {
+		return p.errorf("want %s", sym)
+	}
+	return nil
+}
+
+// reserved can't be used as aliases
+var reserved = []string{"select", "from", "where", "group", "by", "having", "order", "limit", "as", "and", "or", "not", "like", "in", "asc", "desc"}
+
+// Parse parses a query, keywords and functions are case insensitive
+func Parse(s string) (*Statement, error) {
+	tokens, err := lex(s)
+	if err != nil {
+		return nil, err
+	}
+	p := &parser{tokens: tokens}
+	stmt := &Statement{Limit: -1}
+
+	if err := p.expectKeyword("select"); err != nil {
+		return nil, err
+	}
+	for {
+		c, err := p.column()
+		if err != nil {
+			return nil, err
+		}
+		stmt.Columns = append(stmt.Columns, c)
+		if !p.symbol(",") {
+			break
+		}
+	}
+
+	if err := p.expectKeyword("from"); err != nil {
+		return nil, err
+	}
+	t, ok := p.take(tokString)
+	if !ok {
+		return nil, p.errorf("want the file as a 'quoted string'")
+	}
+	stmt.From = t.text
+
+	if p.keyword("where") {
+		if stmt.Where, err = p.or(); err != nil {
+			return nil, err
+		}
+	}
+	if p.keyword("group") {
+		if err := p.expectKeyword("by"); err != nil {
+			return nil, err
+		}
+		if !p.keyword("station") {
+			return nil, p.errorf("only GROUP BY station is supported")
+		}
+		stmt.GroupBy = true
+	}
+	if p.keyword("having") {
+		for {
+			c, err := p.comparison()
+			if err != nil {
+				return nil, err
+			}
+			stmt.Having = append(stmt.Having, c)
+			if !p.keyword("and") {
+				break
+			}
+		}
+	}
+	if p.keyword("order") {
+		if err := p.expectKeyword("by"); err != nil {
+			return nil, err
+		}
+		for {
+			o, err := p.order()
+			if err != nil {
+				return nil, err
+			}
+			stmt.OrderBy = append(stmt.OrderBy, o)
+			if !p.symbol(",") {
+				break
+			}
+		}
+	}
+	if p.keyword("limit") {
+		n, err := strconv.Atoi(p.peek().text)
+		if _, ok := p.take(tokNumber); !ok || err != nil || n < 0 {
+			return nil, p.errorf("want the number of rows")
+		}
+		stmt.Limit = n
+	}
+	p.symbol(";")
+	if p.peek().kind != tokEOF {
+		return nil, p.errorf("unexpected")
+	}
+	return stmt, resolve(stmt)
+}
+
+// column parses station or func(temp|*) with an optional alias
+func (p *parser) column() (Column, error) {
+	var c Column
+	if p.keyword("station") {
+		// station
+	} else {
+		fn, star, err := p.aggregate()
+		if err != nil {
+			return c, err
+		}
+		c.Func, c.star = fn, star
+	}
+	if p.keyword("as") {
+		t, ok := p.take(tokIdent)
+		if !ok {
+			if t, ok = p.take(tokString); !ok {
+				return c, p.errorf("want an alias")
+			}
+		}
+		c.Alias = t.text
+	} else if t := p.peek(); t.kind == tokIdent && !slices.Contains(reserved, strings.ToLower(t.text)) {
+		c.Alias = t.text
+		p.pos++
+	}
+	return c, nil
+}
+
+// aggregate parses func(temp) or count(*)
+func (p *parser) aggregate() (fn string, star bool, err error) {
+	fn = strings.ToLower(p.peek().text)
+	if p.peek().kind != tokIdent || !slices.Contains(Aggregates, fn) {
+		return "", false, p.errorf("want station or one of %s", strings.Join(Aggregates, ", "))
+	}
+	p.pos++
+	if err := p.expectSymbol("("); err != nil {
+		return "", false, err
+	}
+	switch {
+	case p.symbol("*"):
+		if fn != "count" {
+			return "", false, p.errorf("only count(*) takes *")
+		}
+		star = true
+	case p.keyword("temp"):
+	default:
+		return "", false, p.errorf("want temp")
+	}
+	return fn, star, p.expectSymbol(")")
+}
+
+func (p *parser) or() (Predicate, error) {
+	l, err := p.and()
+	if err != nil {
+		return nil, err
+	}
+	for p.keyword("or") {
+		r, err := p.and()
+		if err != nil {
+			return nil, err
+		}
+		l = orPredicate{l, r}
+	}
+	return l, nil
+}
+
+func (p *parser) and() (Predicate, error) {
+	l, err := p.not()
+	if err != nil {
+		return nil, err
+	}
+	for p.keyword("and") {
+		r, err := p.not()
+		if err != nil {
+			return nil, err
+		}
+		l = andPredicate{l, r}
+	}
+	return l, nil
+}
+
+func (p *parser) not() (Predicate, error) {
+	if p.keyword("not") {
+		pred, err := p.not()
+		if err != nil {
+			return nil, err
+		}
+		return notPredicate{pred}, nil
+	}
+	if p.symbol("(") {
+		pred, err := p.or()
+		if err != nil {
+			return nil, err
+		}
+		return pred, p.expectSymbol(")")
+	}
+	return p.predicate()
+}
+
+// predicate parses station [NOT] LIKE 'p', station [NOT] IN ('a', ...) and
+// station =|!=|<> 'a'
+func (p *parser) predicate() (Predicate, error) {
+	if t := p.peek(); t.kind == tokIdent && strings.EqualFold(t.text, "temp") {
+		return nil, p.errorf("WHERE only filters stations, use HAVING for the aggregates")
+	}
+	if !p.keyword("station") {
+		return nil, p.errorf("want station")
+	}
+	negate := p.keyword("not")
+	var pred Predicate
+	switch {
+	case p.keyword("like"):
+		t, ok := p.take(tokString)
+		if !ok {
+			return nil, p.errorf("want a 'pattern'")
+		}
+		pred = likePredicate{pattern: t.text, re: likeRegexp(t.text)}
+	case p.keyword("in"):
+		if err := p.expectSymbol("("); err != nil {
+			return nil, err
+		}
+		var in inPredicate
+		for {
+			t, ok := p.take(tokString)
+			if !ok {
+				return nil, p.errorf("want a 'name'")
+			}
+			in.names = append(in.names, t.text)
+			if !p.symbol(",") {
+				break
+			}
+		}
+		if err := p.expectSymbol(")"); err != nil {
+			return nil, err
+		}
+		pred = in
+	case !negate && (p.symbol("=") || p.symbol("!=") || p.symbol("<>")):
+		op := p.tokens[p.pos-1].text
+		t, ok := p.take(tokString)
+		if !ok {
+			return nil, p.errorf("want a 'name'")
+		}
+		pred = inPredicate{names: []string{t.text}}
+		if op != "=" {
+			pred = notPredicate{pred}
+		}
+	default:
+		return nil, p.errorf("want LIKE, IN or a comparison")
+	}
+	if negate {
+		pred = notPredicate{pred}
+	}
+	return pred, nil
+}
+
+// ref parses a reference to a column in HAVING and ORDER BY: station, an
+// aggregate, a function name or an alias. Aliases and function names are
+// resolved once the whole statement is parsed.
+func (p *parser) ref() (string, error) {
+	t := p.peek()
+	if t.kind != tokIdent {
+		return "", p.errorf("want a column")
+	}
+	if p.tokens[p.pos+1].kind == tokSymbol && p.tokens[p.pos+1].text == "(" {
+		fn, _, err := p.aggregate()
+		return fn + "()", err
+	}
+	if strings.EqualFold(t.text, "station") {
+		p.pos++
+		return "", nil
+	}
+	p.pos++
+	return t.text, nil
+}
+
+func (p *parser) comparison() (Comparison, error) {
+	fn, err := p.ref()
+	if err != nil {
+		return Comparison{}, err
+	}
+	var c Comparison
+	c.Func = fn
+	if t := p.peek(); t.kind != tokSymbol || !slices.Contains([]string{"<", "<=", ">", ">=", "=", "!=", "<>"}, t.text) {
+		return c, p.errorf("want a comparison operator")
+	}
+	c.Op = p.tokens[p.pos].text
+	p.pos++
+	c.Value, err = strconv.ParseFloat(p.peek().text, 64)
+	if _, ok := p.take(tokNumber); !ok || err != nil {
+		return c, p.errorf("want a number")
+	}
+	return c, nil
+}
+
+func (p *parser) order() (Order, error) {
+	fn, err := p.ref()
+	if err != nil {
+		return Order{}, err
+	}
+	o := Order{Func: fn}
+	if p.keyword("desc") {
+		o.Desc = true
+	} else {
+		p.keyword("asc")
+	}
+	return o, nil
+}
+
+// resolve checks the statement and replaces the references of HAVING and
+// ORDER BY with the functions they name
+func resolve(stmt *Statement) error {
+	resolveRef := func(ref string) (string, error) {
+		if fn, ok := strings.CutSuffix(ref, "()"); ok {
+			return fn, nil
+		}
+		if ref == "" {
+			return "", nil
+		}
+		for _, c := range stmt.Columns {
+			if c.Alias == ref {
+				return c.Func, nil
+			}
+		}
+		if fn := strings.ToLower(ref); slices.Contains(Aggregates, fn) {
+			return fn, nil
+		}
+		return "", fmt.Errorf("unknown column %q", ref)
+	}
+
+	for i, c := range stmt.Having {
+		fn, err := resolveRef(c.Func)
+		if err != nil {
+			return err
+		}
+		if fn == "" {
+			return fmt.Errorf("HAVING only compares aggregates, use WHERE for the station")
+		}
+		stmt.Having[i].Func = fn
+	}
+	for i, o := range stmt.OrderBy {
+		fn, err := resolveRef(o.Func)
+		if err != nil {
+			return err
+		}
+		stmt.OrderBy[i].Func = fn
+	}
+
+	for _, c := range stmt.Columns {
+		if c.Func == "" && !stmt.GroupBy {
+			return fmt.Errorf("station is selected without GROUP BY station")
+		}
+	}
+	if !stmt.GroupBy {
+		for _, o := range stmt.OrderBy {
+			if o.Func == "" {
+				return fmt.Errorf("ORDER BY station without GROUP BY station")
+			}
+		}
+	}
+	return nil
+}
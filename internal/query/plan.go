@@ -0,0 +1,327 @@
+package query
+
+import (
+	"cmp"
+	"fmt"
+	"io"
+	"math"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"text/tabwriter"
+
+	"golang.org/x/sys/unix"
+
+	"1brc/internal/fastbrc"
+	"1brc/internal/format"
+)
+
+// Plan is a Statement compiled to the workers of fastbrc
+type Plan struct {
+	Statement *Statement
+	// NWorkers is the number of parse workers
+	NWorkers int
+	// ChunkSize is the size of the chunks given to the workers
+	ChunkSize int
+
+	// filter is WHERE, nil without it
+	filter *fastbrc.Filter
+	// squares is true when stddev is needed, the workers sum the squares of
+	// the measurements too
+	squares bool
+}
+
+// Compile plans stmt for nworkers workers
+func Compile(stmt *Statement, nworkers, chunkSize int) *Plan {
+	p := &Plan{Statement: stmt, NWorkers: max(nworkers, 1), ChunkSize: chunkSize}
+	if p.ChunkSize <= 0 {
+		p.ChunkSize = 2 * 1024 * 1024
+	}
+	if stmt.Where != nil {
+		p.filter = &fastbrc.Filter{}
+		p.filter.IncludeFunc(stmt.Where.Match)
+	}
+	for _, fn := range p.funcs() {
+		p.squares = p.squares || fn == "stddev"
+	}
+	return p
+}
+
+// funcs are the aggregates used anywhere in the statement
+func (p *Plan) funcs() []string {
+	var funcs []string
+	for _, c := range p.Statement.Columns {
+		funcs = append(funcs, c.Func)
+	}
+	for _, c := range p.Statement.Having {
+		funcs = append(funcs, c.Func)
+	}
+	for _, o := range p.Statement.OrderBy {
+		funcs = append(funcs, o.Func)
+	}
+	return funcs
+}
+
+// String explains the plan, one step per line
+func (p *Plan) String() string {
+	var b strings.Builder
+	worker := "ParseWorkerFilter"
+	if p.squares {
+		worker = "ParseWorkerSquares"
+	}
+	fmt.Fprintf(&b, "scan %s: %d x %s, chunks of %d bytes\n", quote(p.Statement.From), p.NWorkers, worker, p.ChunkSize)
+	if p.Statement.Where != nil {
+		fmt.Fprintf(&b, "filter stations: %s\n", p.Statement.Where)
+	}
+	if p.Statement.GroupBy {
+		b.WriteString("group by station\n")
+	} else {
+		b.WriteString("merge all stations\n")
+	}
+	for _, c := range p.Statement.Having {
+		fmt.Fprintf(&b, "having: %s %s %v\n", c.Func, c.Op, c.Value)
+	}
+	var keys []string
+	for _, o := range p.orderBy() {
+		key := cmp.Or(o.Func, "station")
+		if o.Desc {
+			key += " desc"
+		}
+		keys = append(keys, key)
+	}
+	fmt.Fprintf(&b, "order by %s\n", strings.Join(keys, ", "))
+	if p.Statement.Limit >= 0 {
+		fmt.Fprintf(&b, "limit %d\n", p.Statement.Limit)
+	}
+	return b.String()
+}
+
+// orderBy is ORDER BY with the station last to break ties
+func (p *Plan) orderBy() []Order {
+	orders := p.Statement.OrderBy
+	if p.Statement.GroupBy && !slices.ContainsFunc(orders, func(o Order) bool { return o.Func == "" }) {
+		orders = append(slices.Clip(orders), Order{})
+	}
+	return orders
+}
+
+// group aggregates the measurements of a station, or of all of them
+type group struct {
+	name     string
+	min, max int16
+	total, n int64
+	// squares is the sum of the squared measurements, see ParseWorkerSquares
+	squares int64
+}
+
+func newGroup(name string) *group {
+	return &group{name: name, min: math.MaxInt16, max: math.MinInt16}
+}
+
+func (g *group) add(s *fastbrc.StationInt16, squares int64) {
+	g.min = min(g.min, s.Min)
+	g.max = max(g.max, s.Max)
+	g.total += int64(s.Total)
+	g.n += int64(s.N)
+	g.squares += squares
+}
+
+// stddev is the population standard deviation, in tenths of degrees
+func (g *group) stddev() float64 {
+	mean := float64(g.total) / float64(g.n)
+	return math.Sqrt(max(float64(g.squares)/float64(g.n)-mean*mean, 0))
+}
+
+// value is the aggregate fn, in degrees. It's NaN for an empty group but
+// for count.
+func (g *group) value(fn string) float64 {
+	if g.n == 0 && fn != "count" {
+		return math.NaN()
+	}
+	switch fn {
+	case "min":
+		return float64(g.min) / 10
+	case "max":
+		return float64(g.max) / 10
+	case "avg":
+		return float64(g.total) / float64(g.n) / 10
+	case "count":
+		return float64(g.n)
+	case "sum":
+		return float64(g.total) / 10
+	case "stddev":
+		return g.stddev() / 10
+	}
+	panic("unknown aggregate " + fn)
+}
+
+// format formats the aggregate fn like the output of fastbrc, the station
+// when fn is empty
+func (g *group) format(fn, rounding string) string {
+	if fn == "" {
+		return g.name
+	}
+	if g.n == 0 && fn != "count" {
+		return "NULL"
+	}
+	switch fn {
+	case "min":
+		return format.Tenths(int64(g.min))
+	case "max":
+		return format.Tenths(int64(g.max))
+	case "avg":
+		return format.Mean(g.total, g.n, rounding)
+	case "count":
+		return strconv.FormatInt(g.n, 10)
+	case "sum":
+		return format.Tenths(g.total)
+	case "stddev":
+		return format.Float(g.stddev()/10, rounding)
+	}
+	panic("unknown aggregate " + fn)
+}
+
+func (c Comparison) match(g *group) bool {
+	v := g.value(c.Func)
+	switch c.Op {
+	case "<":
+		return v < c.Value
+	case "<=":
+		return v <= c.Value
+	case ">":
+		return v > c.Value
+	case ">=":
+		return v >= c.Value
+	case "=":
+		return v == c.Value
+	case "!=", "<>":
+		return v != c.Value
+	}
+	panic("unknown operator " + c.Op)
+}
+
+// Rows are the result of a query
+type Rows struct {
+	Columns []Column
+	groups  []*group
+}
+
+// Strings formats the rows, means and deviations rounded as set by rounding,
+// see format.RoundingModes
+func (r *Rows) Strings(rounding string) [][]string {
+	rows := make([][]string, len(r.groups))
+	for i, g := range r.groups {
+		for _, c := range r.Columns {
+			rows[i] = append(rows[i], g.format(c.Func, rounding))
+		}
+	}
+	return rows
+}
+
+// Write writes the rows as an aligned table, with a header
+func (r *Rows) Write(w io.Writer, rounding string) error {
+	tw := tabwriter.NewWriter(w, 0, 8, 2, ' ', 0)
+	var header []string
+	for _, c := range r.Columns {
+		header = append(header, c.Name())
+	}
+	fmt.Fprintln(tw, strings.Join(header, "\t"))
+	for _, row := range r.Strings(rounding) {
+		fmt.Fprintln(tw, strings.Join(row, "\t"))
+	}
+	return tw.Flush()
+}
+
+// Run runs the query on the file of its FROM clause
+func (p *Plan) Run() (*Rows, error) {
+	data, err := fastbrc.MmapFile(p.Statement.From, fastbrc.InputWillNeed)
+	if err != nil {
+		return nil, err
+	}
+	defer unix.Munmap(data)
+	if data[len(data)-1] != '\n' {
+		return nil, fmt.Errorf("%s doesn't end with a line", p.Statement.From)
+	}
+
+	chunker := fastbrc.NewAtomicChunker(data, p.ChunkSize)
+	tables := make([][]fastbrc.StationInt16, p.NWorkers)
+	squares := make([][]int64, p.NWorkers)
+	wg := sync.WaitGroup{}
+	wg.Add(p.NWorkers)
+	for i := range p.NWorkers {
+		go func() {
+			defer wg.Done()
+			if p.squares {
+				tables[i], squares[i] = fastbrc.ParseWorkerSquares(chunker, p.filter)
+			} else {
+				tables[i] = fastbrc.ParseWorkerFilter(chunker, p.filter)
+			}
+		}()
+	}
+	wg.Wait()
+
+	return p.rows(tables, squares), nil
+}
+
+// rows merges the tables of the workers and applies HAVING, ORDER BY and
+// LIMIT
+func (p *Plan) rows(tables [][]fastbrc.StationInt16, squares [][]int64) *Rows {
+	all := newGroup("")
+	groups := make(map[string]*group)
+	for i, table := range tables {
+		for j := range table {
+			s := &table[j]
+			if s.N == 0 || s.Excluded {
+				continue
+			}
+			var sq int64
+			if squares[i] != nil {
+				sq = squares[i][j]
+			}
+			if !p.Statement.GroupBy {
+				all.add(s, sq)
+				continue
+			}
+			g, ok := groups[string(s.Name)]
+			if !ok {
+				g = newGroup(string(s.Name))
+				groups[g.name] = g
+			}
+			g.add(s, sq)
+		}
+	}
+
+	rows := &Rows{Columns: p.Statement.Columns}
+	if !p.Statement.GroupBy {
+		groups = map[string]*group{"": all}
+	}
+	for _, g := range groups {
+		if !slices.ContainsFunc(p.Statement.Having, func(c Comparison) bool { return !c.match(g) }) {
+			rows.groups = append(rows.groups, g)
+		}
+	}
+
+	orders := p.orderBy()
+	slices.SortFunc(rows.groups, func(a, b *group) int {
+		for _, o := range orders {
+			var c int
+			if o.Func == "" {
+				c = strings.Compare(a.name, b.name)
+			} else {
+				c = cmp.Compare(a.value(o.Func), b.value(o.Func))
+			}
+			if o.Desc {
+				c = -c
+			}
+			if c != 0 {
+				return c
+			}
+		}
+		return 0
+	})
+	if p.Statement.Limit >= 0 && len(rows.groups) > p.Statement.Limit {
+		rows.groups = rows.groups[:p.Statement.Limit]
+	}
+	return rows
+}
@@ -0,0 +1,161 @@
+package query
+
+import (
+	"bytes"
+	"fmt"
+	"math"
+	"math/rand/v2"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"1brc/internal/format"
+)
+
+func TestParse(t *testing.T) {
+	stmt, err := Parse(`select station, MAX(temp), count(*) AS n FROM 'data.txt'
+		WHERE station LIKE 'A%' AND NOT station IN ('Abha', 'Ab''s') OR station = 'Oslo'
+		GROUP BY station HAVING max > 40 AND n >= 10 ORDER BY max DESC, station LIMIT 10;`)
+	require.NoError(t, err)
+	assert.Equal(t, []Column{{}, {Func: "max"}, {Func: "count", Alias: "n", star: true}}, stmt.Columns)
+	assert.Equal(t, "data.txt", stmt.From)
+	assert.Equal(t, "((station LIKE 'A%' AND NOT station IN ('Abha', 'Ab''s')) OR station IN ('Oslo'))", stmt.Where.String())
+	assert.True(t, stmt.GroupBy)
+	assert.Equal(t, []Comparison{{Func: "max", Op: ">", Value: 40}, {Func: "count", Op: ">=", Value: 10}}, stmt.Having)
+	assert.Equal(t, []Order{{Func: "max", Desc: true}, {}}, stmt.OrderBy)
+	assert.Equal(t, 10, stmt.Limit)
+	assert.Equal(t, []string{"station", "max(temp)", "n"}, []string{stmt.Columns[0].Name(), stmt.Columns[1].Name(), stmt.Columns[2].Name()})
+
+	stmt, err = Parse("SELECT avg(temp) mean, stddev(temp) FROM 'x' HAVING mean < -1.5 ORDER BY stddev(temp) ASC")
+	require.NoError(t, err)
+	assert.Nil(t, stmt.Where)
+	assert.False(t, stmt.GroupBy)
+	assert.Equal(t, []Comparison{{Func: "avg", Op: "<", Value: -1.5}}, stmt.Having)
+	assert.Equal(t, []Order{{Func: "stddev"}}, stmt.OrderBy)
+	assert.Equal(t, -1, stmt.Limit)
+}
+
+func TestParseErrors(t *testing.T) {
+	for _, q := range []string{
+		"",
+		"SELECT",
+		"SELECT station FROM 'x'",
+		"SELECT max(temp) FROM x",
+		"SELECT max(temp) FROM 'x",
+		"SELECT median(temp) FROM 'x'",
+		"SELECT max(*) FROM 'x'",
+		"SELECT max(temp) FROM 'x' WHERE temp > 10",
+		"SELECT max(temp) FROM 'x' WHERE station ~ 'a'",
+		"SELECT max(temp) FROM 'x' WHERE station LIKE",
+		"SELECT max(temp) FROM 'x' GROUP BY temp",
+		"SELECT max(temp) FROM 'x' HAVING station > 1",
+		"SELECT max(temp) FROM 'x' HAVING max > 'a'",
+		"SELECT max(temp) FROM 'x' ORDER BY foo",
+		"SELECT max(temp) FROM 'x' ORDER BY station",
+		"SELECT max(temp) FROM 'x' LIMIT -1",
+		"SELECT max(temp) FROM 'x' LIMIT 10 20",
+		"SELECT max(temp) FROM 'x' @",
+	} {
+		_, err := Parse(q)
+		assert.Error(t, err, q)
+	}
+}
+
+func TestLike(t *testing.T) {
+	for pattern, names := range map[string][2][]string{
+		"A%":     {{"A", "Abha", "Abéché"}, {"abha", "Oslo", ""}},
+		"_slo":   {{"Oslo", "Øslo"}, {"slo", "Osloo"}},
+		"%.%":    {{"St. John's"}, {"Abha"}},
+		"St. J%": {{"St. John's"}, {"StX John's"}},
+	} {
+		re := likeRegexp(pattern)
+		for _, name := range names[0] {
+			assert.True(t, re.MatchString(name), "%s LIKE %s", name, pattern)
+		}
+		for _, name := range names[1] {
+			assert.False(t, re.MatchString(name), "%s LIKE %s", name, pattern)
+		}
+	}
+}
+
+func TestRun(t *testing.T) {
+	r := rand.New(rand.NewPCG(1, 2))
+	names := []string{"Abha", "Abéché", "Addis Ababa", "Oslo", "St. John's", "Zürich"}
+	values := make(map[string][]int64)
+	var b bytes.Buffer
+	for range 20000 {
+		name := names[r.IntN(len(names))]
+		v := int64(r.IntN(1999) - 999)
+		values[name] = append(values[name], v)
+		fmt.Fprintf(&b, "%s;%s\n", name, format.Tenths(v))
+	}
+	filename := filepath.Join(t.TempDir(), "measurements.txt")
+	require.NoError(t, os.WriteFile(filename, b.Bytes(), 0o644))
+
+	// what the query below should return
+	var expected [][]string
+	for _, name := range []string{"Abha", "Abéché", "Addis Ababa"} {
+		vs := values[name]
+		var sum, sq int64
+		lo, hi := vs[0], vs[0]
+		for _, v := range vs {
+			sum += v
+			sq += v * v
+			lo, hi = min(lo, v), max(hi, v)
+		}
+		mean := float64(sum) / float64(len(vs))
+		stddev := math.Sqrt(float64(sq)/float64(len(vs))-mean*mean) / 10
+		expected = append(expected, []string{name, format.Tenths(lo), format.Tenths(hi), format.Mean(sum, int64(len(vs)), format.RoundHalfUp), fmt.Sprint(len(vs)), format.Tenths(sum), format.Float(stddev, format.RoundHalfUp)})
+	}
+
+	stmt, err := Parse(fmt.Sprintf("SELECT station, min(temp), max(temp), avg(temp), count(*), sum(temp), stddev(temp) FROM '%s' WHERE station LIKE 'A%%' GROUP BY station", filename))
+	require.NoError(t, err)
+	for _, nworkers := range []int{1, 3} {
+		plan := Compile(stmt, nworkers, 4096)
+		assert.Contains(t, plan.String(), "ParseWorkerSquares")
+		rows, err := plan.Run()
+		require.NoError(t, err)
+		assert.Equal(t, expected, rows.Strings(format.RoundHalfUp))
+	}
+
+	// HAVING, ORDER BY and LIMIT
+	stmt, err = Parse(fmt.Sprintf("SELECT station, count(*) n FROM '%s' GROUP BY station HAVING n > 3300 ORDER BY n DESC LIMIT 2", filename))
+	require.NoError(t, err)
+	plan := Compile(stmt, 2, 4096)
+	assert.NotContains(t, plan.String(), "ParseWorkerSquares")
+	rows, err := plan.Run()
+	require.NoError(t, err)
+	var counts []int
+	for _, name := range names {
+		if len(values[name]) > 3300 {
+			counts = append(counts, len(values[name]))
+		}
+	}
+	got := rows.Strings(format.RoundHalfUp)
+	require.Len(t, got, min(len(counts), 2))
+	for i := 1; i < len(got); i++ {
+		prev, _ := strconv.Atoi(got[i-1][1])
+		n, _ := strconv.Atoi(got[i][1])
+		assert.GreaterOrEqual(t, prev, n)
+	}
+	var out bytes.Buffer
+	require.NoError(t, rows.Write(&out, format.RoundHalfUp))
+	assert.Equal(t, len(got)+1, bytes.Count(out.Bytes(), []byte{'\n'}))
+	assert.Contains(t, out.String(), "station")
+
+	// no GROUP BY, one row even when nothing matches
+	stmt, err = Parse(fmt.Sprintf("SELECT count(*), max(temp) FROM '%s' WHERE station = 'Nowhere'", filename))
+	require.NoError(t, err)
+	rows, err = Compile(stmt, 2, 4096).Run()
+	require.NoError(t, err)
+	assert.Equal(t, [][]string{{"0", "NULL"}}, rows.Strings(format.RoundHalfUp))
+
+	stmt, err = Parse(fmt.Sprintf("SELECT count(*) FROM '%s'", filepath.Join(t.TempDir(), "missing")))
+	require.NoError(t, err)
+	_, err = Compile(stmt, 1, 0).Run()
+	assert.Error(t, err)
+}
@@ -1,147 +1,533 @@
 package main
 
 import (
+	"context"
 	"flag"
 	"fmt"
+	"io"
 	"log"
 	"log/slog"
+	"net"
+	"net/http"
 	"os"
-	"runtime/pprof"
+	"runtime"
+	"runtime/trace"
+	"slices"
 	"sort"
 	"strings"
 	"sync"
-	"syscall"
 	"time"
 
+	"1brc/internal/agg"
+	"1brc/internal/columnar"
 	"1brc/internal/fastbrc"
-
-	"golang.org/x/sys/unix"
+	"1brc/internal/format"
+	"1brc/internal/names"
+	"1brc/internal/prof"
 )
 
-func mmap(filename string) ([]byte, error) {
-	f, err := os.Open(filename)
-	if err != nil {
-		return nil, err
+type Chunker interface {
+	Run() error
+	fastbrc.ChunkGetter
+}
+
+// workerChunker is implemented by chunkers that serve a different region of
+// the input to each worker
+type workerChunker interface {
+	ForWorker(region int) fastbrc.ChunkGetter
+}
+
+type runOptions struct {
+	// placement pins workers to cpus, nil means no pinning
+	placement *fastbrc.Placement
+	// readahead touches pages ahead of the workers, nil means disabled
+	readahead *fastbrc.Readahead
+	// parseWorker parses the chunks, nil means fastbrc.ParseWorker
+	parseWorker func(fastbrc.ChunkGetter) []fastbrc.StationInt16
+	// query selects and orders the stations in the output
+	query fastbrc.Query
+	// rounding of the means, see format.RoundingModes, empty means half up
+	rounding string
+	// nfc merges the stations with the same name once normalized to NFC
+	nfc bool
+	// reportUnknown logs the line count of every station dropped for being
+	// unknown
+	reportUnknown bool
+	// progress counts the chunks released by the workers, nil means disabled
+	progress *fastbrc.Progress
+	// emitPartial is the file the partial of the input is written to, one
+	// per worker when it contains %d, empty means none. See agg.Partial
+	emitPartial string
+	// histogramWorker replaces parseWorker when set, the histograms of the
+	// stations are written to the partials of emitPartial. See
+	// fastbrc.ParseWorkerHistogram
+	histogramWorker func(fastbrc.ChunkGetter) ([]fastbrc.StationInt16, [][]uint64)
+	// anomalyWorker replaces parseWorker when set, the anomalies found by the
+	// workers are merged, keeping the first anomalyLimit, and written to
+	// anomaliesOut. See fastbrc.AnomalyDetector
+	anomalyWorker func(fastbrc.ChunkGetter) ([]fastbrc.StationInt16, *fastbrc.Anomalies)
+	anomalyLimit  int
+	anomaliesOut  io.Writer
+	// dedupWorker replaces parseWorker when set, it returns the number of
+	// duplicate lines it skipped. See fastbrc.LineDedup
+	dedupWorker func(fastbrc.ChunkGetter) ([]fastbrc.StationInt16, int64)
+	// input is the mmaped input, nil for the read chunkers
+	input []byte
+	// arrowFile and parquetFile are the files the output stations are
+	// written to as an Arrow IPC stream and as a Parquet file, empty means
+	// none. See columnar.Table
+	arrowFile   string
+	parquetFile string
+}
+
+type chunkerConfig struct {
+	// chunker selects how chunks are distributed to the workers
+	chunker string
+	// input selects how the input gets into memory, see fastbrc.InputStrategies
+	input      string
+	chCap      int
+	chunkSize  int
+	uringDepth int
+	direct     bool
+	// dedupBlock is the block size of fastbrc.DedupChunker, 0 means no
+	// chunk deduplication
+	dedupBlock int
+	// brcb serves the blocks of a .brcb input, see fastbrc.BRCBChunker
+	brcb bool
+	// ranges are the only parts of the input served, from the station
+	// index, nil means all of it
+	ranges []fastbrc.Range
+}
+
+// newChunker creates the chunker reading f as configured by cfg.
+func newChunker(f *os.File, cfg chunkerConfig, opts *runOptions) (Chunker, error) {
+	if cfg.brcb && (cfg.chunker == "read" || cfg.chunker == "uring" || cfg.input == fastbrc.InputPread || cfg.dedupBlock > 0) {
+		return nil, fmt.Errorf("a .brcb input needs to be mmaped and can't be deduplicated")
 	}
-	defer f.Close()
-	fi, err := f.Stat()
-	if err != nil {
-		return nil, err
+	if cfg.ranges != nil && (cfg.chunker != "mmap" || cfg.input == fastbrc.InputPread || cfg.dedupBlock > 0 || cfg.brcb) {
+		return nil, fmt.Errorf("-station needs -chunker mmap on a text input and can't be deduplicated")
 	}
-
-	size := fi.Size()
-	if size <= 0 {
-		return nil, fmt.Errorf("mmap: file %q too small", filename)
+	if cfg.chunker == "region" && (cfg.input == fastbrc.InputPread || cfg.dedupBlock > 0 || cfg.brcb) {
+		return nil, fmt.Errorf("-affinity numa needs a mmaped text input, without -dedup")
 	}
-	if size != int64(int(size)) {
-		return nil, fmt.Errorf("mmap: file %q is too large", filename)
+	if cfg.chunker == "uring" {
+		return fastbrc.NewUringChunker(f.Name(), cfg.chCap, cfg.chunkSize, cfg.uringDepth, cfg.direct)
+	}
+	if cfg.dedupBlock > 0 && (cfg.chunker == "read" || cfg.input == fastbrc.InputPread) {
+		return nil, fmt.Errorf("-dedup %s needs the input mmaped", fastbrc.DedupChunks)
+	}
+	if cfg.input == fastbrc.InputPread {
+		fi, err := f.Stat()
+		if err != nil {
+			return nil, err
+		}
+		return fastbrc.NewChunker(io.NewSectionReader(f, 0, fi.Size()), cfg.chCap, cfg.chunkSize), nil
+	}
+	if cfg.chunker == "read" {
+		return fastbrc.NewChunker(f, cfg.chCap, cfg.chunkSize), nil
 	}
 
-	data, err := unix.Mmap(int(f.Fd()), 0, int(size), syscall.PROT_READ, syscall.MAP_SHARED)
+	data, err := fastbrc.MmapFile(f.Name(), cfg.input)
 	if err != nil {
 		return nil, err
 	}
-
-	err = unix.Madvise(data, unix.MADV_WILLNEED|unix.MADV_SEQUENTIAL)
-	if err != nil {
-		return nil, fmt.Errorf("madvise: %w", err)
+	if cfg.input == fastbrc.InputReadahead {
+		opts.readahead = fastbrc.NewReadahead(data, max(cfg.chCap, 1)*cfg.chunkSize*4)
+	}
+	opts.input = data
+	if cfg.brcb {
+		return fastbrc.NewBRCBChunker(data)
+	}
+	if cfg.dedupBlock > 0 {
+		return fastbrc.NewDedupChunker(data, cfg.dedupBlock), nil
 	}
 
-	return data, nil
+	switch cfg.chunker {
+	case "mmap":
+		if cfg.ranges != nil {
+			return fastbrc.NewByteChunkerRanges(data, cfg.chCap, cfg.chunkSize, cfg.ranges), nil
+		}
+		return fastbrc.NewByteChunker(data, cfg.chCap, cfg.chunkSize), nil
+	case "atomic":
+		return fastbrc.NewAtomicChunker(data, cfg.chunkSize), nil
+	case "region":
+		return fastbrc.NewRegionChunker(data, opts.placement.RegionWorkers, cfg.chunkSize), nil
+	default:
+		return nil, fmt.Errorf("unknown chunker: %s", cfg.chunker)
+	}
 }
 
-type Chunker interface {
-	Run() error
-	fastbrc.ChunkGetter
+// dedupBlockSize is chunkerConfig.dedupBlock for the -dedup mode
+func dedupBlockSize(mode string, blockSize int) int {
+	if mode != fastbrc.DedupChunks {
+		return 0
+	}
+	return max(blockSize, 1)
 }
 
 // func run(reader io.Reader, nworkers, chunkerChannelCap, chunkSize int) string {
-func run(chunker Chunker, nworkers int) string {
+func run(chunker Chunker, nworkers int, opts runOptions) string {
+	var out strings.Builder
+	if err := runTo(&out, chunker, nworkers, opts); err != nil {
+		log.Fatal(err)
+	}
+	return out.String()
+}
+
+// runTo is run writing the output to w
+func runTo(w io.Writer, chunker Chunker, nworkers int, opts runOptions) error {
+	// regions show up with -trace, see go tool trace
+	ctx, task := trace.NewTask(context.Background(), "run")
+	defer task.End()
+	t0 := time.Now()
+
 	stationTables := make([][]fastbrc.StationInt16, nworkers)
+	anomalies := make([]*fastbrc.Anomalies, nworkers)
+	duplicates := make([]int64, nworkers)
+	histograms := make([][][]uint64, nworkers)
 	wg := sync.WaitGroup{}
 
 	wg.Add(1)
 	go func() {
 		defer wg.Done()
-		if err := chunker.Run(); err != nil {
-			log.Fatalf("chunker failed: %s", err)
-		}
-		slog.Debug("Chunker done")
+		trace.WithRegion(ctx, "chunking", func() {
+			if err := chunker.Run(); err != nil {
+				log.Fatalf("chunker failed: %s", err)
+			}
+		})
+		slog.Debug("Chunker done", "took", time.Since(t0))
 	}()
 
+	if opts.readahead != nil {
+		go opts.readahead.Run()
+		defer opts.readahead.Stop()
+	}
+
 	wg.Add(nworkers)
 	for i := range nworkers {
 		go func() {
 			defer wg.Done()
-			stationTables[i] = fastbrc.ParseWorker(chunker)
-			slog.Debug("Worker done", "id", i)
+			var getter fastbrc.ChunkGetter = chunker
+			if opts.placement != nil {
+				runtime.LockOSThread()
+				if err := opts.placement.Pin(i); err != nil {
+					log.Fatalf("worker %d: %s", i, err)
+				}
+				if wc, ok := chunker.(workerChunker); ok && opts.placement.WorkerRegion != nil {
+					getter = wc.ForWorker(opts.placement.WorkerRegion[i])
+				}
+			}
+			if opts.readahead != nil {
+				getter = opts.readahead.Wrap(getter)
+			}
+			if opts.progress != nil {
+				getter = opts.progress.Wrap(getter, i)
+			}
+			if trace.IsEnabled() {
+				getter = fastbrc.TraceChunks(ctx, getter)
+			}
+			parseWorker := fastbrc.ParseWorker
+			if opts.parseWorker != nil {
+				parseWorker = opts.parseWorker
+			}
+			switch {
+			case opts.anomalyWorker != nil:
+				stationTables[i], anomalies[i] = opts.anomalyWorker(getter)
+			case opts.dedupWorker != nil:
+				stationTables[i], duplicates[i] = opts.dedupWorker(getter)
+			case opts.histogramWorker != nil:
+				stationTables[i], histograms[i] = opts.histogramWorker(getter)
+			default:
+				stationTables[i] = parseWorker(getter)
+			}
+			slog.Debug("Worker done", "id", i, "took", time.Since(t0))
 		}()
 	}
 
 	wg.Wait()
+	tParse := time.Now()
 
-	mergedStations := make(map[string]*fastbrc.StationInt16, 2048)
-	for i := range stationTables {
-		table := stationTables[i]
-		for j := range table {
-			if len(table[j].Name) == 0 {
-				continue
-			}
-			merged, ok := mergedStations[string(table[j].Name)]
-			if !ok {
-				merged = &fastbrc.StationInt16{
-					Min:   table[j].Min,
-					Max:   table[j].Max,
-					Total: table[j].Total,
-					N:     table[j].N,
-				}
-				mergedStations[string(table[j].Name)] = merged
-				continue
-			}
+	region := trace.StartRegion(ctx, "merging")
+	mergedStations := agg.NewResults()
+	for _, table := range stationTables {
+		mergeTable(mergedStations, table, opts.nfc)
+	}
+	region.End()
+	if opts.emitPartial != "" {
+		if err := emitPartials(opts.emitPartial, stationTables, histograms, opts.nfc); err != nil {
+			return err
+		}
+	}
+	if dc, ok := chunker.(*fastbrc.DedupChunker); ok {
+		skipped := dc.Skipped()
+		slog.Info("Skipped duplicates", "lines", skipped.Lines, "bytes", skipped.Bytes, "copies", skipped.Copies)
+	}
+	if opts.dedupWorker != nil {
+		var skipped int64
+		for _, n := range duplicates {
+			skipped += n
+		}
+		if skipped > 0 {
+			// identical real readings in the window are dropped too
+			slog.Warn("Skipped duplicate lines, including identical real readings", "lines", skipped)
+		}
+	}
+	if opts.anomalyWorker != nil {
+		merged := fastbrc.MergeAnomalies(anomalies, opts.anomalyLimit)
+		slog.Info("Anomalies", "found", merged.Total, "written", len(merged.List))
+		if err := merged.Write(opts.anomaliesOut); err != nil {
+			return fmt.Errorf("anomalies: %w", err)
+		}
+	}
+	tMerge := time.Now()
 
-			merged.Total += table[j].Total
-			merged.N += table[j].N
-			if table[j].Min < merged.Min {
-				table[j].Min = merged.Min
-			}
-			if table[j].Max > merged.Max {
-				table[j].Max = merged.Max
+	var droppedLines, droppedStations int
+	var unknownStations []string
+	dropped := make(agg.Results)
+	for k, station := range mergedStations {
+		if station.Excluded {
+			droppedLines += int(station.N)
+			droppedStations++
+			if station.Unknown {
+				unknownStations = append(unknownStations, k)
 			}
+			dropped[k] = station
+			delete(mergedStations, k)
 		}
 	}
+	keys := opts.query.Apply(mergedStations)
 
-	keys := make([]string, 0, len(mergedStations))
-	for k := range mergedStations {
-		keys = append(keys, k)
+	if droppedStations > 0 {
+		slog.Info("Dropped lines", "lines", droppedLines, "stations", droppedStations, "unknown_stations", len(unknownStations))
+	}
+	if opts.reportUnknown {
+		sort.Strings(unknownStations)
+		for _, k := range unknownStations {
+			slog.Info("Unknown station", "name", k, "lines", dropped[k].N)
+		}
 	}
-	sort.Strings(keys)
 
-	out := make([]string, 0, len(mergedStations)+2)
-	out = append(out, "{")
-	for i, k := range keys {
-		station := mergedStations[k]
-		if i == len(keys)-1 {
-			out = append(out, fmt.Sprintf("%s=%s", k, station.FancyPrint()))
-		} else {
-			out = append(out, fmt.Sprintf("%s=%s, ", k, station.FancyPrint()))
+	tQuery := time.Now()
+
+	if opts.arrowFile != "" || opts.parquetFile != "" {
+		table := columnar.FromResults(mergedStations, keys)
+		if err := writeColumnar(opts.arrowFile, table, columnar.WriteArrow); err != nil {
+			return fmt.Errorf("arrow: %w", err)
+		}
+		if err := writeColumnar(opts.parquetFile, table, columnar.WriteParquet); err != nil {
+			return fmt.Errorf("parquet: %w", err)
+		}
+	}
+
+	region = trace.StartRegion(ctx, "output")
+	err := mergedStations.Encode(w, keys, opts.rounding)
+	region.End()
+	tOutput := time.Now()
+	slog.Debug("Phase timings",
+		"parse", tParse.Sub(t0),
+		"merge", tMerge.Sub(tParse),
+		"query", tQuery.Sub(tMerge),
+		"output", tOutput.Sub(tQuery),
+		"total", tOutput.Sub(t0))
+	return err
+}
+
+// mergeTable merges the stations of the table of a worker to results
+func mergeTable(results agg.Results, table []fastbrc.StationInt16, nfc bool) {
+	if !nfc {
+		results.MergeTable(table)
+		return
+	}
+	for j := range table {
+		if len(table[j].Name) == 0 {
+			continue
+		}
+		results.Merge(names.NFC(string(table[j].Name)), &table[j])
+	}
+}
+
+// writeColumnar writes table to path with write, nothing when path is empty
+func writeColumnar(path string, table *columnar.Table, write func(io.Writer, *columnar.Table) error) error {
+	if path == "" {
+		return nil
+	}
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	if err := write(f, table); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// emitPartials writes the partial of the tables to path, or the ones of every
+// table when path contains %d, replaced by the worker number. The tables are
+// summed into the partial, with int64 sums. histograms are the ones of the
+// tables, nil when not tracked
+func emitPartials(path string, tables [][]fastbrc.StationInt16, histograms [][][]uint64, nfc bool) error {
+	if !strings.Contains(path, "%d") {
+		p := agg.NewPartial()
+		for i, table := range tables {
+			addPartial(p, table, histograms[i], nfc)
+		}
+		return os.WriteFile(path, agg.Marshal(p), 0o644)
+	}
+	for i, table := range tables {
+		p := agg.NewPartial()
+		addPartial(p, table, histograms[i], nfc)
+		if err := os.WriteFile(fmt.Sprintf(path, i), agg.Marshal(p), 0o644); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// addPartial merges the stations of the table of a worker and their
+// histograms, if tracked, to p
+func addPartial(p *agg.Partial, table []fastbrc.StationInt16, histograms [][]uint64, nfc bool) {
+	for j := range table {
+		if len(table[j].Name) == 0 {
+			continue
+		}
+		name := string(table[j].Name)
+		if nfc {
+			name = names.NFC(name)
+		}
+		var h []uint64
+		if histograms != nil {
+			h = histograms[j]
 		}
+		p.AddHistogram(name, &table[j], h)
 	}
-	out = append(out, "}")
-	slog.Debug("all done")
-	return strings.Join(out, "")
+}
+
+// reportProgress logs the progress every interval until done is closed
+func reportProgress(p *fastbrc.Progress, interval time.Duration, done <-chan struct{}) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+		case <-done:
+			return
+		}
+		s := p.Snapshot()
+		slog.Info("Progress",
+			"bytes", s.Bytes,
+			"percent", fmt.Sprintf("%.1f", 100*float64(s.Bytes)/float64(max(s.Total, 1))),
+			"lines_per_sec", int64(s.LinesPerSecond()),
+			"eta", s.ETA().Round(100*time.Millisecond))
+	}
+}
+
+// serveMetrics serves the counters of p on addr in the background
+func serveMetrics(addr string, p *fastbrc.Progress) error {
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/metrics", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+		p.Snapshot().WriteMetrics(w)
+	})
+	slog.Info("Serving metrics", "addr", ln.Addr())
+	go http.Serve(ln, mux)
+	return nil
+}
+
+func hasherNames() []string {
+	names := make([]string, 0, len(fastbrc.Hashers))
+	for _, h := range fastbrc.Hashers {
+		names = append(names, h.Name)
+	}
+	return names
 }
 
 func main() {
+	if len(os.Args) > 1 && os.Args[1] == "merge" {
+		mergeMain(os.Args[2:])
+		return
+	}
+	if len(os.Args) > 1 && os.Args[1] == "query" {
+		queryMain(os.Args[2:])
+		return
+	}
+	if len(os.Args) > 1 && os.Args[1] == "convert" {
+		convertMain(os.Args[2:])
+		return
+	}
+	if len(os.Args) > 1 && os.Args[1] == "index" {
+		indexMain(os.Args[2:])
+		return
+	}
+
 	t0 := time.Now()
-	cpuprofile := flag.String("cpuprofile", "", "write cpu profile to file")
+	profiles := prof.Register(flag.CommandLine)
 	nworkers := flag.Int("n", 1, "number of workers for parallel funcs")
 	chunkSize := flag.Int("chunksize", 256*1024, "size of the chunks to be processed by workers")
 	chunkerChannelCap := flag.Int("channel-cap", -1, "capacity of the chunk channel")
-	inputFile := flag.String("f", "data/10m.txt", "input file")
+	inputFile := flag.String("f", "data/10m.txt", "input file, a .brcb file written by fastbrc convert is aggregated without parsing: -chunker, -hash and -scanner are ignored")
+	chunkerType := flag.String("chunker", "mmap", "chunk distribution: mmap (channel fed from a producer goroutine), atomic (workers claim chunks with an atomic offset), read (channel fed by read(2) into reused buffers) or uring (channel fed by io_uring reads)")
+	uringDepth := flag.Int("uring-depth", 16, "number of reads kept in flight by -chunker uring")
+	direct := flag.Bool("direct", false, "open the input with O_DIRECT, -chunker uring only")
+	affinity := flag.String("affinity", "none", "worker placement: none, cpus (pin worker i to the i-th cpu of -cpus) or numa (pin workers to the cpus of a numa node and give each node a contiguous region of the input, needs -chunker mmap)")
+	cpuList := flag.String("cpus", "", "cpus used by -affinity, i.e: 0-7,16-23 (default: all allowed cpus)")
+	scanner := flag.String("scanner", "indexbyte", "line splitting in the workers: indexbyte (search ';' then '\\n' 8 bytes at a time) or masks (scan 64 bytes blocks once for both)")
+	hash := flag.String("hash", "auto", "station name hash: auto (first supported of "+strings.Join(hasherNames(), ", ")+") or one of them")
+	stationsFile := flag.String("stations", "", "file listing the known stations, one per line (or the weather_stations.csv of the generator). Workers index a perfect hash of them, -hash is ignored")
+	unknown := flag.String("unknown", fastbrc.UnknownDynamic, "with -stations, what to do with unknown stations: dynamic (aggregate them like the others), error, skip (drop their lines) or count (drop their lines and report the count of each unknown station)")
+	// the rules are added once -nfc is known
+	var includeRules, excludeRules []string
+	flag.Func("include", "only aggregate the stations matching, can be repeated: a station name, @file for a list of names, re:regexp or prefix:prefix", func(rule string) error {
+		includeRules = append(includeRules, rule)
+		return nil
+	})
+	flag.Func("exclude", "drop the lines of the stations matching, can be repeated, same syntax as -include", func(rule string) error {
+		excludeRules = append(excludeRules, rule)
+		return nil
+	})
+	var stationNames []string
+	flag.Func("station", "only aggregate this station, can be repeated: only the chunks of the input where it occurs are parsed, from the index written by fastbrc index", func(name string) error {
+		stationNames = append(stationNames, name)
+		return nil
+	})
+	indexFile := flag.String("index", "", "with -station, the station index of the input (default: the -f file with a .idx extension)")
+	inputStrategy := flag.String("input", fastbrc.InputWillNeed, "how the input gets into memory: "+strings.Join(fastbrc.InputStrategies, ", ")+". pread implies -chunker read")
+	top := flag.Int("top", 0, "only output the first n stations, after sorting (default: all)")
+	var where []fastbrc.Condition
+	flag.Func("where", "only output the stations matching a condition, i.e: max>45.0, can be repeated: <mean|min|max|count|range><op><number>", func(s string) error {
+		c, err := fastbrc.ParseCondition(s)
+		where = append(where, c)
+		return err
+	})
+	sortBy := flag.String("sort-by", "name", "output order: "+strings.Join(fastbrc.QueryFields, ", ")+". Numbers sort descending, add :asc or :desc to change the direction, i.e: mean:asc")
+	nfc := flag.Bool("nfc", false, "normalize station names to unicode NFC, so names written with combining accents (NFD) and precomposed letters are the same station, for -include, -exclude, -station and -stations too")
+	collate := flag.String("collate", "", "sort names for a locale instead of byte order: "+strings.Join(names.Locales, ", "))
+	rounding := flag.String("rounding", format.RoundHalfUp, "rounding of the means: "+format.RoundHalfUp+" (like the reference implementation, halves toward positive infinity) or "+format.RoundFloat+" (%.1f on the float mean, halves to even on the binary value)")
+	progress := flag.Duration("progress", 0, "log the progress to stderr at this interval, i.e: 1s (default: disabled)")
+	metricsAddr := flag.String("metrics-addr", "", "serve the progress counters in the prometheus format on http://addr/metrics, i.e: localhost:9090")
+	anomaliesFile := flag.String("anomalies", "", "detect anomalies and write them to this file as station;value;offset;reason lines, - for stderr. -scanner indexbyte only, can't be combined with -hash or -stations")
+	anomalyK := flag.Float64("anomaly-k", 4, "with -anomalies, flag the measurements more than k standard deviations away from the running mean of their station (0: disabled)")
+	anomalyMinSamples := flag.Int64("anomaly-min-samples", 100, "with -anomalies, number of measurements of a station seen by a worker before -anomaly-k applies")
+	anomalyBounds := flag.String("anomaly-bounds", "", "with -anomalies, file of name;min;max lines, flag the measurements of these stations outside of [min, max]")
+	anomalyLimit := flag.Int("anomaly-limit", 10000, "with -anomalies, number of anomalies written, the first ones by offset (0: all)")
+	dedup := flag.String("dedup", fastbrc.DedupNone, "skip duplicate measurements: "+fastbrc.DedupNone+", "+fastbrc.DedupLines+" (lines equal to one of the -dedup-window lines before them: real readings of a station repeating the same value within the window are dropped too, which changes the aggregates of data without any retry) or "+fastbrc.DedupChunks+" (ranges of lines that are a copy of lines before them, found from blocks of about -dedup-block bytes equal to a block before them and extended line by line, copies of less than about 2 blocks are kept). Both need the input mmaped, "+fastbrc.DedupLines+" only supports -scanner indexbyte and ignores -hash and -stations")
+	dedupWindow := flag.Int("dedup-window", 0, "with -dedup "+fastbrc.DedupLines+", number of lines a line is compared to, required: the larger the more identical real readings are dropped, set it to how far a retry can repeat lines")
+	dedupBlock := flag.Int("dedup-block", 1024*1024, "with -dedup "+fastbrc.DedupChunks+", average size of the blocks, they replace the chunks of -chunksize")
+	arrowFile := flag.String("arrow", "", "also write the output stations to this file as an Arrow IPC stream, with the columns name (dictionary encoded), min, max, sum (in tenths of degrees) and count")
+	parquetFile := flag.String("parquet", "", "also write the output stations to this file as a Parquet file, with the columns of -arrow")
+	emitPartial := flag.String("emit-partial", "", "also write the partial aggregate of the input to this file, for fastbrc merge. A %d in the name writes one per worker instead")
+	partialHistograms := flag.Bool("partial-histograms", false, "with -emit-partial, also count the measurements of each value per station in the partial, 16KB per station and worker. -scanner indexbyte only, can't be combined with -stations, -anomalies or -dedup "+fastbrc.DedupLines)
 	var loglevel slog.Level
 	flag.TextVar(&loglevel, "loglevel", slog.LevelInfo, "loglevel")
 
 	flag.Parse()
+	// the flags given on the command line
+	set := make(map[string]bool)
+	flag.Visit(func(f *flag.Flag) { set[f.Name] = true })
 	if *chunkerChannelCap == -1 {
 		*chunkerChannelCap = *nworkers
 	}
@@ -150,14 +536,11 @@ func main() {
 		Level: loglevel,
 	})))
 
-	if *cpuprofile != "" {
-		f, err := os.Create(*cpuprofile)
-		if err != nil {
-			log.Fatal(err)
-		}
-		pprof.StartCPUProfile(f)
-		defer pprof.StopCPUProfile()
+	stopProfiles, err := profiles.Start()
+	if err != nil {
+		log.Fatal(err)
 	}
+	defer stopProfiles()
 
 	f, err := os.Open(*inputFile)
 	if err != nil {
@@ -165,11 +548,290 @@ func main() {
 	}
 	defer f.Close()
 
-	data, err := mmap(*inputFile)
+	filter := &fastbrc.Filter{}
+	var normalize func(string) string
+	if *nfc {
+		normalize = names.NFC
+		filter.Normalize = normalize
+	}
+	for _, rule := range includeRules {
+		if err := filter.Include(rule); err != nil {
+			log.Fatalf("-include %s: %s", rule, err)
+		}
+	}
+	for _, rule := range excludeRules {
+		if err := filter.Exclude(rule); err != nil {
+			log.Fatalf("-exclude %s: %s", rule, err)
+		}
+	}
+	filtered := len(includeRules) > 0 || len(excludeRules) > 0
+
+	var ranges []fastbrc.Range
+	if len(stationNames) > 0 {
+		if *nfc {
+			for i, name := range stationNames {
+				stationNames[i] = names.NFC(name)
+			}
+		}
+		// the other stations are only partly in the ranges
+		filtered = true
+		filter.ExcludeFunc(func(name string) bool { return !slices.Contains(stationNames, name) })
+		if *indexFile == "" {
+			*indexFile = fastbrc.IndexPath(*inputFile)
+		}
+		ranges, err = stationRanges(f, *indexFile, stationNames, normalize)
+		if err != nil {
+			log.Fatalf("index: %s", err)
+		}
+	}
+	if !filtered {
+		filter = nil
+	}
+
+	hasher, err := fastbrc.SelectHasher(*hash)
+	if err != nil {
+		log.Fatal(err)
+	}
+	slog.Debug("Selected hash", "hash", hasher.Name)
+
+	var opts runOptions
+	opts.query.Where = where
+	opts.query.Top = *top
+	opts.query.SortBy, opts.query.Desc, err = fastbrc.ParseSortBy(*sortBy)
+	if err != nil {
+		log.Fatal(err)
+	}
+	opts.nfc = *nfc
+	if !slices.Contains(format.RoundingModes, *rounding) {
+		log.Fatalf("unknown rounding: %s", *rounding)
+	}
+	opts.rounding = *rounding
+	opts.emitPartial = *emitPartial
+	opts.arrowFile = *arrowFile
+	opts.parquetFile = *parquetFile
+	if *collate != "" {
+		collator, err := names.NewCollator(*collate)
+		if err != nil {
+			log.Fatal(err)
+		}
+		opts.query.NameKey = collator.Key
+	}
+	switch *scanner {
+	case "indexbyte":
+		opts.parseWorker = func(g fastbrc.ChunkGetter) []fastbrc.StationInt16 {
+			return hasher.Worker(g, filter)
+		}
+	case "masks":
+		// with the xxh3 inlined
+		if *hash != "auto" && *hash != "xxh3" {
+			log.Fatalf("-scanner masks only supports the xxh3 hash")
+		}
+		opts.parseWorker = func(g fastbrc.ChunkGetter) []fastbrc.StationInt16 {
+			table, err := fastbrc.ParseWorkerMasksFilter(g, filter)
+			if err != nil {
+				log.Fatalf("worker: %s", err)
+			}
+			return table
+		}
+	default:
+		log.Fatalf("unknown scanner: %s", *scanner)
+	}
+
+	if *stationsFile != "" {
+		if *scanner != "indexbyte" {
+			log.Fatalf("-stations only supports -scanner indexbyte")
+		}
+		if !slices.Contains(fastbrc.UnknownModes, *unknown) {
+			log.Fatalf("unknown -unknown: %s", *unknown)
+		}
+		stations, err := fastbrc.LoadStationSet(*stationsFile, normalize)
+		if err != nil {
+			log.Fatalf("stations: %s", err)
+		}
+		slog.Debug("Loaded stations", "n", stations.Len())
+		opts.reportUnknown = *unknown == fastbrc.UnknownCount
+		opts.parseWorker = func(g fastbrc.ChunkGetter) []fastbrc.StationInt16 {
+			table, err := stations.ParseWorker(g, *unknown, filter)
+			if err != nil {
+				log.Fatalf("worker: %s", err)
+			}
+			return table
+		}
+	}
+
+	var detector *fastbrc.AnomalyDetector
+	if *anomaliesFile != "" {
+		if *scanner != "indexbyte" {
+			log.Fatalf("-anomalies only supports -scanner indexbyte")
+		}
+		// the detector has its own xxh3 worker, without a station set
+		if *hash != "auto" || set["stations"] || set["unknown"] {
+			log.Fatalf("-anomalies can't be combined with -hash, -stations or -unknown")
+		}
+		detector = &fastbrc.AnomalyDetector{K: *anomalyK, MinSamples: *anomalyMinSamples, Limit: *anomalyLimit}
+		if *anomalyBounds != "" {
+			if detector.Bounds, err = fastbrc.LoadBounds(*anomalyBounds); err != nil {
+				log.Fatalf("anomaly bounds: %s", err)
+			}
+		}
+		opts.anomalyWorker = func(g fastbrc.ChunkGetter) ([]fastbrc.StationInt16, *fastbrc.Anomalies) {
+			return detector.Worker(g, filter)
+		}
+		opts.anomalyLimit = *anomalyLimit
+		opts.anomaliesOut = os.Stderr
+		if *anomaliesFile != "-" {
+			out, err := os.Create(*anomaliesFile)
+			if err != nil {
+				log.Fatal(err)
+			}
+			defer out.Close()
+			opts.anomaliesOut = out
+		}
+	}
+
+	var lineDedup *fastbrc.LineDedup
+	switch *dedup {
+	case fastbrc.DedupNone, fastbrc.DedupChunks:
+	case fastbrc.DedupLines:
+		if *scanner != "indexbyte" {
+			log.Fatalf("-dedup %s only supports -scanner indexbyte", *dedup)
+		}
+		if detector != nil {
+			log.Fatalf("-dedup %s can't be combined with -anomalies", *dedup)
+		}
+		if *dedupWindow <= 0 {
+			log.Fatalf("-dedup %s needs a -dedup-window, there is no default as identical real readings in the window are dropped too", *dedup)
+		}
+		lineDedup = &fastbrc.LineDedup{Window: *dedupWindow}
+		opts.dedupWorker = func(g fastbrc.ChunkGetter) ([]fastbrc.StationInt16, int64) {
+			table, skipped, err := lineDedup.Worker(g, filter)
+			if err != nil {
+				log.Fatalf("worker: %s", err)
+			}
+			return table, skipped
+		}
+	default:
+		log.Fatalf("unknown dedup: %s", *dedup)
+	}
+
+	if *partialHistograms {
+		if *emitPartial == "" {
+			log.Fatalf("-partial-histograms needs -emit-partial")
+		}
+		if *scanner != "indexbyte" || *stationsFile != "" || detector != nil || lineDedup != nil {
+			log.Fatalf("-partial-histograms only supports -scanner indexbyte and can't be combined with -stations, -anomalies or -dedup %s", fastbrc.DedupLines)
+		}
+		worker := fastbrc.ParseWorkerHistogram(hasher.Hash)
+		opts.histogramWorker = func(g fastbrc.ChunkGetter) ([]fastbrc.StationInt16, [][]uint64) {
+			return worker(g, filter)
+		}
+	}
+
+	if *affinity != "none" {
+		cpus, err := fastbrc.ParseCPUList(*cpuList)
+		if err != nil {
+			log.Fatal(err)
+		}
+		if len(cpus) == 0 {
+			if cpus, err = fastbrc.AllowedCPUs(); err != nil {
+				log.Fatal(err)
+			}
+		}
+
+		switch *affinity {
+		case "cpus":
+			opts.placement, err = fastbrc.NewCPUPlacement(cpus, *nworkers)
+		case "numa":
+			nodes, err := fastbrc.NUMANodes()
+			if err != nil {
+				log.Fatalf("numa topology: %s", err)
+			}
+			opts.placement, err = fastbrc.NewNUMAPlacement(nodes, cpus, *nworkers)
+			if err != nil {
+				log.Fatal(err)
+			}
+			// each node works on its own region of the mmaped input
+			if *chunkerType != "mmap" {
+				log.Fatalf("-affinity numa needs -chunker mmap, it serves each node its own region of the mmaped input")
+			}
+			*chunkerType = "region"
+		default:
+			log.Fatalf("unknown affinity: %s", *affinity)
+		}
+		if err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	chunker, err := newChunker(f, chunkerConfig{
+		chunker:    *chunkerType,
+		input:      *inputStrategy,
+		chCap:      *chunkerChannelCap,
+		chunkSize:  *chunkSize,
+		uringDepth: *uringDepth,
+		direct:     *direct,
+		dedupBlock: dedupBlockSize(*dedup, *dedupBlock),
+		brcb:       strings.HasSuffix(*inputFile, ".brcb"),
+		ranges:     ranges,
+	}, &opts)
 	if err != nil {
-		log.Fatalf("mmap: %s", err)
+		log.Fatalf("chunker: %s", err)
+	}
+	if bc, ok := chunker.(*fastbrc.BRCBChunker); ok {
+		if detector != nil || lineDedup != nil || *stationsFile != "" || *partialHistograms {
+			log.Fatalf("a .brcb input can't be combined with -anomalies, -dedup, -stations or -partial-histograms")
+		}
+		slog.Debug("Opened .brcb", "rows", bc.Rows(), "stations", len(bc.Names))
+		opts.parseWorker = func(g fastbrc.ChunkGetter) []fastbrc.StationInt16 {
+			table, err := bc.Worker(g, filter)
+			if err != nil {
+				log.Fatalf("worker: %s", err)
+			}
+			return table
+		}
+	}
+	if lineDedup != nil {
+		offsets, ok := chunker.(fastbrc.ChunkOffsets)
+		if !ok || opts.input == nil {
+			log.Fatalf("-dedup %s needs the input mmaped", *dedup)
+		}
+		lineDedup.Input, lineDedup.Offsets = opts.input, offsets
+	}
+	if detector != nil {
+		if offsets, ok := chunker.(fastbrc.ChunkOffsets); ok {
+			detector.Offsets = offsets
+		} else {
+			slog.Warn("Anomaly offsets are unknown with this chunker", "chunker", *chunkerType)
+		}
+	}
+	if *progress > 0 || *metricsAddr != "" {
+		fi, err := f.Stat()
+		if err != nil {
+			log.Fatal(err)
+		}
+		total := fi.Size()
+		if ranges != nil {
+			// only the ranges of -station are served
+			total = 0
+			for _, r := range ranges {
+				total += int64(r.End - r.Start)
+			}
+		}
+		opts.progress = fastbrc.NewProgress(total, *nworkers, chunker)
+	}
+	if *metricsAddr != "" {
+		if err := serveMetrics(*metricsAddr, opts.progress); err != nil {
+			log.Fatalf("metrics: %s", err)
+		}
+	}
+	done := make(chan struct{})
+	if *progress > 0 {
+		go reportProgress(opts.progress, *progress, done)
+	}
+	if err := runTo(os.Stdout, chunker, *nworkers, opts); err != nil {
+		log.Fatal(err)
 	}
-	chunker := fastbrc.NewByteChunker(data, *chunkerChannelCap, *chunkSize)
-	fmt.Println(run(chunker, *nworkers))
+	close(done)
+	fmt.Println()
 	log.Printf("took: %0.3f", time.Since(t0).Seconds())
 }
@@ -1,13 +1,24 @@
 package main
 
 import (
+	"bytes"
+	"fmt"
 	"log"
+	"math/rand/v2"
 	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
 	"testing"
 
+	"1brc/internal/agg"
+	"1brc/internal/columnar"
 	"1brc/internal/fastbrc"
+	"1brc/internal/format"
 
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/sys/unix"
 )
 
 func BenchmarkFastBRCCopyChunker(b *testing.B) {
@@ -18,19 +29,160 @@ func BenchmarkFastBRCCopyChunker(b *testing.B) {
 			log.Fatal(err)
 		}
 		chunker := fastbrc.NewChunker(f, 8, 2048*1024)
-		run(chunker, 8)
+		run(chunker, 8, runOptions{})
 
 		f.Close()
 	}
 }
 
 func BenchmarkFastBRCMmapByteChunker(b *testing.B) {
-	b.ReportAllocs()
+	benchmarkMmapChunker(b, func(data []byte, nworkers int) Chunker {
+		return fastbrc.NewByteChunker(data, nworkers, 2048*1024)
+	})
+}
+
+func BenchmarkFastBRCMmapAtomicChunker(b *testing.B) {
+	benchmarkMmapChunker(b, func(data []byte, _ int) Chunker {
+		return fastbrc.NewAtomicChunker(data, 2048*1024)
+	})
+}
+
+// benchmarkMmapChunker runs the chunkers of newChunker over the mmaped input,
+// with the same worker counts for every chunker
+func benchmarkMmapChunker(b *testing.B, newChunker func(data []byte, nworkers int) Chunker) {
 	filename := "data/1b.txt"
-	for i := 0; i < b.N; i++ {
-		data, err := mmap(filename)
-		assert.NoError(b, err)
-		chunker := fastbrc.NewByteChunker(data, 24, 2048*1024)
-		run(chunker, 24)
+	for _, nworkers := range []int{8, 16, 24} {
+		b.Run(fmt.Sprintf("nworker%02d", nworkers), func(b *testing.B) {
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				data, err := fastbrc.MmapFile(filename, fastbrc.InputWillNeed)
+				assert.NoError(b, err)
+				run(newChunker(data, nworkers), nworkers, runOptions{})
+				unix.Munmap(data)
+			}
+		})
+	}
+}
+
+// BenchmarkFastBRCInputStrategy runs every input strategy with a warm page
+// cache and with the input evicted from the page cache before each run.
+func BenchmarkFastBRCInputStrategy(b *testing.B) {
+	filename := "data/1b.txt"
+	nworkers := runtime.NumCPU()
+	for _, cache := range []string{"warm", "cold"} {
+		for _, strategy := range fastbrc.InputStrategies {
+			b.Run(cache+"-"+strategy, func(b *testing.B) {
+				b.ReportAllocs()
+				for i := 0; i < b.N; i++ {
+					if cache == "cold" {
+						b.StopTimer()
+						assert.NoError(b, fastbrc.EvictPageCache(filename))
+						b.StartTimer()
+					}
+					f, err := os.Open(filename)
+					assert.NoError(b, err)
+					var opts runOptions
+					chunker, err := newChunker(f, chunkerConfig{chunker: "mmap", input: strategy, chCap: nworkers, chunkSize: 2048 * 1024}, &opts)
+					assert.NoError(b, err)
+					run(chunker, nworkers, opts)
+					if opts.input != nil {
+						unix.Munmap(opts.input)
+					}
+					f.Close()
+				}
+			})
+		}
+	}
+}
+
+func BenchmarkFastBRCUringChunker(b *testing.B) {
+	filename := "data/1b.txt"
+	nworkers := runtime.NumCPU()
+	for _, direct := range []bool{false, true} {
+		for _, depth := range []int{4, 16, 64} {
+			b.Run(fmt.Sprintf("direct%v-depth%02d", direct, depth), func(b *testing.B) {
+				b.ReportAllocs()
+				for i := 0; i < b.N; i++ {
+					b.StopTimer()
+					assert.NoError(b, fastbrc.EvictPageCache(filename))
+					b.StartTimer()
+					chunker, err := fastbrc.NewUringChunker(filename, nworkers, 2048*1024, depth, direct)
+					assert.NoError(b, err)
+					run(chunker, nworkers, runOptions{})
+				}
+			})
+		}
+	}
+}
+
+func TestColumnarRoundTrip(t *testing.T) {
+	rnd := rand.New(rand.NewPCG(1, 2))
+	var b bytes.Buffer
+	for range 100_000 {
+		fmt.Fprintf(&b, "Station %d;%.1f\n", rnd.IntN(500), float64(rnd.IntN(1999)-999)/10)
+	}
+	// stations sharing bytes with the others, and ones from the unicode part
+	b.WriteString("Station;-99.9\nSão Paulo;99.9\nZürich;0.0\n")
+
+	// ParseWorker doesn't check for collisions, some of the random names
+	// would share a station
+	hasher, err := fastbrc.SelectHasher("fnv1a")
+	require.NoError(t, err)
+	dir := t.TempDir()
+	opts := runOptions{
+		parseWorker: func(g fastbrc.ChunkGetter) []fastbrc.StationInt16 {
+			return hasher.Worker(g, nil)
+		},
+		rounding:    format.RoundHalfUp,
+		arrowFile:   filepath.Join(dir, "out.arrow"),
+		parquetFile: filepath.Join(dir, "out.parquet"),
+	}
+	want := run(fastbrc.NewChunker(bytes.NewReader(b.Bytes()), 3, 64*1024), 3, opts)
+
+	f, err := os.Open(opts.arrowFile)
+	require.NoError(t, err)
+	defer f.Close()
+	arrow, err := columnar.ReadArrow(f)
+	require.NoError(t, err)
+
+	data, err := os.ReadFile(opts.parquetFile)
+	require.NoError(t, err)
+	parquet, err := columnar.ReadParquet(bytes.NewReader(data), int64(len(data)))
+	require.NoError(t, err)
+
+	assert.Equal(t, 503, arrow.Len())
+	assert.Equal(t, arrow, parquet)
+	for _, table := range []*columnar.Table{arrow, parquet} {
+		var got strings.Builder
+		require.NoError(t, table.Encode(&got, opts.rounding))
+		assert.Equal(t, want, got.String())
+	}
+}
+
+func TestEmitPartialsDontOverflow(t *testing.T) {
+	// each worker is within int32, not their sum
+	tables := make([][]fastbrc.StationInt16, 3)
+	for i := range tables {
+		tables[i] = []fastbrc.StationInt16{{}, {Min: 999, Max: 999, Total: 999 * 2_000_000, N: 2_000_000, Name: []byte("Abha")}}
+	}
+	path := filepath.Join(t.TempDir(), "partial.bin")
+	require.NoError(t, emitPartials(path, tables, make([][][]uint64, len(tables)), false))
+	b, err := os.ReadFile(path)
+	require.NoError(t, err)
+	p, err := agg.Unmarshal(b)
+	require.NoError(t, err)
+	assert.Equal(t, &agg.PartialStation{Min: 999, Max: 999, Sum: 999 * 6_000_000, Count: 6_000_000}, p.Stations["Abha"])
+
+	histograms := make([][][]uint64, len(tables))
+	for i := range histograms {
+		histograms[i] = [][]uint64{nil, make([]uint64, agg.HistogramBins)}
+		histograms[i][1][999+agg.HistogramOffset] = 2_000_000
 	}
+	require.NoError(t, emitPartials(path, tables, histograms, false))
+	b, err = os.ReadFile(path)
+	require.NoError(t, err)
+	p, err = agg.Unmarshal(b)
+	require.NoError(t, err)
+	require.NotNil(t, p.Stations["Abha"].Histogram)
+	assert.Equal(t, uint64(6_000_000), p.Stations["Abha"].Histogram[999+agg.HistogramOffset])
 }
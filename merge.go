@@ -0,0 +1,56 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"slices"
+
+	"1brc/internal/agg"
+	"1brc/internal/format"
+)
+
+// mergeMain is fastbrc merge: it combines the partials written with
+// -emit-partial and prints the result
+func mergeMain(args []string) {
+	fs := flag.NewFlagSet("merge", flag.ExitOnError)
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "usage: %s merge [flags] partial...\n", os.Args[0])
+		fs.PrintDefaults()
+	}
+	rounding := fs.String("rounding", format.RoundHalfUp, "rounding of the means, see fastbrc -h")
+	emitPartial := fs.String("emit-partial", "", "also write the merged partial to this file")
+	fs.Parse(args)
+
+	if fs.NArg() == 0 {
+		fs.Usage()
+		os.Exit(2)
+	}
+	if !slices.Contains(format.RoundingModes, *rounding) {
+		log.Fatalf("unknown rounding: %s", *rounding)
+	}
+
+	merged := agg.NewPartial()
+	for _, path := range fs.Args() {
+		b, err := os.ReadFile(path)
+		if err != nil {
+			log.Fatal(err)
+		}
+		p, err := agg.Unmarshal(b)
+		if err != nil {
+			log.Fatalf("%s: %s", path, err)
+		}
+		merged.Merge(p)
+	}
+
+	if *emitPartial != "" {
+		if err := os.WriteFile(*emitPartial, agg.Marshal(merged), 0o644); err != nil {
+			log.Fatal(err)
+		}
+	}
+	if err := merged.Encode(os.Stdout, merged.Names(), *rounding); err != nil {
+		log.Fatal(err)
+	}
+	fmt.Println()
+}
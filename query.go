@@ -0,0 +1,54 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"runtime"
+	"slices"
+	"strings"
+
+	"1brc/internal/format"
+	"1brc/internal/query"
+)
+
+// queryMain is fastbrc query: it runs a SQL like query on a measurements
+// file, see package query
+func queryMain(args []string) {
+	fs := flag.NewFlagSet("query", flag.ExitOnError)
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "usage: %s query [flags] \"SELECT station, max(temp), count(*) FROM 'data.txt' WHERE station LIKE 'A%%' GROUP BY station ORDER BY max DESC LIMIT 10\"\n", os.Args[0])
+		fmt.Fprintf(fs.Output(), "aggregates: %s of temp, and count(*)\n", strings.Join(query.Aggregates, ", "))
+		fs.PrintDefaults()
+	}
+	nworkers := fs.Int("n", runtime.NumCPU(), "number of workers")
+	chunkSize := fs.Int("chunksize", 2*1024*1024, "size of the chunks to be processed by workers")
+	rounding := fs.String("rounding", format.RoundHalfUp, "rounding of the means and deviations, see fastbrc -h")
+	explain := fs.Bool("explain", false, "print the plan to stderr")
+	fs.Parse(args)
+
+	if fs.NArg() == 0 {
+		fs.Usage()
+		os.Exit(2)
+	}
+	if !slices.Contains(format.RoundingModes, *rounding) {
+		log.Fatalf("unknown rounding: %s", *rounding)
+	}
+
+	stmt, err := query.Parse(strings.Join(fs.Args(), " "))
+	if err != nil {
+		log.Fatalf("query: %s", err)
+	}
+	plan := query.Compile(stmt, *nworkers, *chunkSize)
+	if *explain {
+		fmt.Fprint(os.Stderr, plan)
+	}
+	rows, err := plan.Run()
+	if err != nil {
+		log.Fatal(err)
+	}
+	if err := rows.Write(os.Stdout, *rounding); err != nil {
+		log.Fatal(err)
+	}
+}